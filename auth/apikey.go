@@ -0,0 +1,223 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// generatedKeyPrefix 接口生成的API key前缀，便于在日志和代码中识别泄露的key
+const generatedKeyPrefix = "isk_"
+
+// keyPrefixLength 列表中展示的key前缀长度
+const keyPrefixLength = 8
+
+// keyNamePattern API key名称规则
+var keyNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]{0,63}$`)
+
+var (
+	// ErrKeyExists 同名的API key已存在
+	ErrKeyExists = errors.New("API key已存在")
+	// ErrKeyNotFound API key不存在
+	ErrKeyNotFound = errors.New("API key不存在")
+	// ErrConfigKey 来自配置的API key不能通过接口删除
+	ErrConfigKey = errors.New("API key来自配置（API_KEYS），不能通过接口删除")
+)
+
+// APIKey API key记录，只保存key的SHA-256哈希
+type APIKey struct {
+	Name   string   `json:"name"`
+	Hash   string   `json:"hash"`
+	Prefix string   `json:"prefix"`
+	Scopes []string `json:"scopes"`
+	// Namespaces 可以访问的命名空间，见 Principal.Namespaces
+	Namespaces []string  `json:"namespaces,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	CreatedBy  string    `json:"created_by,omitempty"`
+}
+
+// APIKeyInfo 列表中展示的API key信息（不含哈希）
+type APIKeyInfo struct {
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix,omitempty"`
+	Scopes     []string   `json:"scopes"`
+	Namespaces []string   `json:"namespaces,omitempty"`
+	Source     string     `json:"source"` // config 或 store
+	CreatedAt  *time.Time `json:"created_at,omitempty"`
+	CreatedBy  string     `json:"created_by,omitempty"`
+}
+
+// KeyStore 保存通过接口创建的API key
+type KeyStore interface {
+	ListAPIKeys() ([]*APIKey, error)
+	PutAPIKey(key *APIKey) error
+	DeleteAPIKey(name string) error
+}
+
+// hashKey 计算key的哈希
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// keyPrefix 截取key的前缀用于展示
+func keyPrefix(key string) string {
+	if len(key) <= keyPrefixLength {
+		return ""
+	}
+	return key[:keyPrefixLength]
+}
+
+// parseConfigKeys 解析配置中的静态key，格式为 名称:key:权限,权限[:命名空间,命名空间]，多个key用分号分隔
+func parseConfigKeys(raw string) ([]*APIKey, error) {
+	var keys []*APIKey
+	names := map[string]bool{}
+	hashes := map[string]bool{}
+	for _, entry := range strings.Split(raw, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 4)
+		if len(parts) < 3 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("无效的API_KEYS配置项，格式应为 名称:key:权限,权限[:命名空间,命名空间]")
+		}
+		name, secret := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if !keyNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("无效的API key名称: %s", name)
+		}
+		scopes, err := normalizeScopes(strings.Split(parts[2], ","))
+		if err != nil {
+			return nil, fmt.Errorf("API key %s: %w", name, err)
+		}
+		var namespaces []string
+		if len(parts) == 4 {
+			if namespaces, err = normalizeNamespaces(strings.Split(parts[3], ",")); err != nil {
+				return nil, fmt.Errorf("API key %s: %w", name, err)
+			}
+		}
+		hash := hashKey(secret)
+		if names[name] || hashes[hash] {
+			return nil, fmt.Errorf("API_KEYS中的API key %s 重复", name)
+		}
+		names[name], hashes[hash] = true, true
+		keys = append(keys, &APIKey{Name: name, Hash: hash, Scopes: scopes, Namespaces: namespaces})
+	}
+	return keys, nil
+}
+
+// generateKey 生成随机key
+func generateKey() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("生成API key失败: %w", err)
+	}
+	return generatedKeyPrefix + base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// CreateKey 生成并保存新的API key，返回记录和key明文（只在创建时返回一次）。
+// namespaces为key可以访问的命名空间，为空时只能访问默认命名空间
+func (a *Authenticator) CreateKey(name string, scopes, namespaces []string, createdBy string) (*APIKeyInfo, string, error) {
+	if !keyNamePattern.MatchString(name) {
+		return nil, "", fmt.Errorf("无效的API key名称: %s（字母、数字、_ . -，最长64个字符）", name)
+	}
+	scopes, err := normalizeScopes(scopes)
+	if err != nil {
+		return nil, "", err
+	}
+	if namespaces, err = normalizeNamespaces(namespaces); err != nil {
+		return nil, "", err
+	}
+	secret, err := generateKey()
+	if err != nil {
+		return nil, "", err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.findLocked(name) != nil {
+		return nil, "", fmt.Errorf("%w: %s", ErrKeyExists, name)
+	}
+
+	key := &APIKey{
+		Name:       name,
+		Hash:       hashKey(secret),
+		Prefix:     keyPrefix(secret),
+		Scopes:     scopes,
+		Namespaces: namespaces,
+		CreatedAt:  time.Now(),
+		CreatedBy:  createdBy,
+	}
+	if err := a.store.PutAPIKey(key); err != nil {
+		return nil, "", fmt.Errorf("保存API key失败: %w", err)
+	}
+	a.keys[key.Hash] = key
+	return a.info(key), secret, nil
+}
+
+// DeleteKey 吊销通过接口创建的API key，立即生效
+func (a *Authenticator) DeleteKey(name string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	key := a.findLocked(name)
+	if key == nil {
+		return fmt.Errorf("%w: %s", ErrKeyNotFound, name)
+	}
+	if a.configKeys[name] {
+		return fmt.Errorf("%w: %s", ErrConfigKey, name)
+	}
+	if err := a.store.DeleteAPIKey(name); err != nil {
+		return fmt.Errorf("删除API key失败: %w", err)
+	}
+	delete(a.keys, key.Hash)
+	return nil
+}
+
+// Keys 按名称排序列出全部API key
+func (a *Authenticator) Keys() []*APIKeyInfo {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	infos := make([]*APIKeyInfo, 0, len(a.keys))
+	for _, key := range a.keys {
+		infos = append(infos, a.info(key))
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// findLocked 按名称查找key，需持有锁
+func (a *Authenticator) findLocked(name string) *APIKey {
+	for _, key := range a.keys {
+		if key.Name == name {
+			return key
+		}
+	}
+	return nil
+}
+
+// info 转换为展示信息
+func (a *Authenticator) info(key *APIKey) *APIKeyInfo {
+	source := "store"
+	if a.configKeys[key.Name] {
+		source = "config"
+	}
+	info := &APIKeyInfo{
+		Name:       key.Name,
+		Prefix:     key.Prefix,
+		Scopes:     key.Scopes,
+		Namespaces: key.Namespaces,
+		Source:     source,
+		CreatedBy:  key.CreatedBy,
+	}
+	if !key.CreatedAt.IsZero() {
+		info.CreatedAt = &key.CreatedAt
+	}
+	return info
+}
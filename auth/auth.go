@@ -0,0 +1,300 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	"image-search-go/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 权限。admin包含read和write
+const (
+	ScopeRead  = "read"  // 搜索、查看统计信息和图像文件
+	ScopeWrite = "write" // 上传和删除图像
+	ScopeAdmin = "admin" // 管理命名空间和API key
+)
+
+// Scopes 全部权限
+var Scopes = []string{ScopeRead, ScopeWrite, ScopeAdmin}
+
+// 认证方式
+const (
+	MethodAPIKey    = "api_key"
+	MethodJWT       = "jwt"
+	MethodAnonymous = "anonymous" // 未启用认证
+)
+
+// 命名空间授权
+const (
+	// AllNamespaces 可以访问全部命名空间
+	AllNamespaces = "*"
+	// DefaultNamespace 默认命名空间，未指定命名空间的调用方只能访问它。命名空间管理（services）也使用此定义
+	DefaultNamespace = "default"
+)
+
+// namespacePattern 命名空间名称只允许小写字母、数字和下划线（同时用作collection名后缀）
+var namespacePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_]{0,31}$`)
+
+// ValidNamespace 命名空间名称是否符合规则，授权和创建命名空间时使用同一规则
+func ValidNamespace(name string) bool {
+	return namespacePattern.MatchString(name)
+}
+
+// APIKeyHeader 传递API key的请求头，也可以使用 Authorization: Bearer <key>
+const APIKeyHeader = "X-API-Key"
+
+// principalContextKey gin上下文中保存调用方的key
+const principalContextKey = "principal"
+
+var (
+	// ErrUnauthenticated 未提供凭证或凭证无效
+	ErrUnauthenticated = errors.New("未认证")
+	// ErrInvalidScope 未知的权限
+	ErrInvalidScope = errors.New("无效的权限")
+	// ErrInvalidNamespace 无效的命名空间名称
+	ErrInvalidNamespace = errors.New("无效的命名空间")
+)
+
+// Principal 通过认证的调用方
+type Principal struct {
+	Subject string   `json:"subject"` // API key名称或JWT的sub
+	Method  string   `json:"method"`
+	Scopes  []string `json:"scopes"`
+	// Namespaces 可以访问的命名空间，* 表示全部；为空时只能访问默认命名空间（admin可以访问全部）
+	Namespaces []string `json:"namespaces,omitempty"`
+}
+
+// Identity 调用方标识，如 api_key:ci、jwt:alice；未启用认证时为空
+func (p *Principal) Identity() string {
+	if p == nil || p.Method == MethodAnonymous {
+		return ""
+	}
+	return p.Method + ":" + p.Subject
+}
+
+// Has 是否拥有指定权限，admin拥有全部权限
+func (p *Principal) Has(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// CanAccess 是否可以访问指定命名空间
+func (p *Principal) CanAccess(namespace string) bool {
+	if p == nil {
+		return false
+	}
+	if p.Has(ScopeAdmin) {
+		return true
+	}
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+	if len(p.Namespaces) == 0 {
+		return namespace == DefaultNamespace
+	}
+	for _, ns := range p.Namespaces {
+		if ns == AllNamespaces || ns == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// anonymous 未启用认证时的调用方，拥有全部权限
+var anonymous = &Principal{Subject: MethodAnonymous, Method: MethodAnonymous, Scopes: []string{ScopeAdmin}}
+
+// normalizeScopes 校验权限并去重
+func normalizeScopes(scopes []string) ([]string, error) {
+	seen := map[string]bool{}
+	var result []string
+	for _, scope := range scopes {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		if scope == "" || seen[scope] {
+			continue
+		}
+		valid := false
+		for _, s := range Scopes {
+			valid = valid || s == scope
+		}
+		if !valid {
+			return nil, fmt.Errorf("%w: %s（可选 read, write, admin）", ErrInvalidScope, scope)
+		}
+		seen[scope] = true
+		result = append(result, scope)
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("%w: 至少需要一个权限", ErrInvalidScope)
+	}
+	return result, nil
+}
+
+// normalizeNamespaces 校验命名空间并去重，可以为空（只能访问默认命名空间）
+func normalizeNamespaces(namespaces []string) ([]string, error) {
+	seen := map[string]bool{}
+	var result []string
+	for _, ns := range namespaces {
+		ns = strings.TrimSpace(ns)
+		if ns == "" || seen[ns] {
+			continue
+		}
+		if ns != AllNamespaces && !ValidNamespace(ns) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidNamespace, ns)
+		}
+		seen[ns] = true
+		result = append(result, ns)
+	}
+	return result, nil
+}
+
+// Authenticator 校验API key和JWT
+type Authenticator struct {
+	enabled bool
+	store   KeyStore
+	jwt     *jwtVerifier
+
+	mu         sync.RWMutex
+	keys       map[string]*APIKey // key的哈希 -> key
+	configKeys map[string]bool    // 来自配置的key名称，不能通过接口删除
+}
+
+// New 创建认证器，加载配置中的静态key和store中保存的key。
+// 启用认证但没有任何可用的key或JWT密钥时返回错误
+func New(cfg *config.AuthConfig, store KeyStore) (*Authenticator, error) {
+	a := &Authenticator{
+		enabled:    cfg.Enabled,
+		store:      store,
+		keys:       map[string]*APIKey{},
+		configKeys: map[string]bool{},
+	}
+
+	configKeys, err := parseConfigKeys(cfg.APIKeys)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range configKeys {
+		a.keys[key.Hash] = key
+		a.configKeys[key.Name] = true
+	}
+
+	stored, err := store.ListAPIKeys()
+	if err != nil {
+		return nil, fmt.Errorf("加载API key失败: %w", err)
+	}
+	for _, key := range stored {
+		if a.configKeys[key.Name] {
+			return nil, fmt.Errorf("API key %s 同时存在于配置和数据库中", key.Name)
+		}
+		a.keys[key.Hash] = key
+	}
+
+	if a.jwt, err = newJWTVerifier(cfg); err != nil {
+		return nil, err
+	}
+
+	if a.enabled && len(a.keys) == 0 && a.jwt == nil {
+		return nil, errors.New("已启用认证，但未配置API key（API_KEYS）或JWT密钥（JWT_HMAC_SECRET、JWT_PUBLIC_KEY_FILE）")
+	}
+	return a, nil
+}
+
+// Enabled 是否启用认证
+func (a *Authenticator) Enabled() bool {
+	return a.enabled
+}
+
+// Authenticate 校验凭证：Authorization: Bearer 后为JWT（含两个"."）或API key，或 X-API-Key 请求头
+func (a *Authenticator) Authenticate(header http.Header) (*Principal, error) {
+	credential := strings.TrimSpace(header.Get(APIKeyHeader))
+	if authorization := header.Get("Authorization"); credential == "" && authorization != "" {
+		scheme, token, ok := strings.Cut(authorization, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") {
+			return nil, fmt.Errorf("%w: Authorization 请求头应为 Bearer <token>", ErrUnauthenticated)
+		}
+		credential = strings.TrimSpace(token)
+		if strings.Count(credential, ".") == 2 {
+			if a.jwt == nil {
+				return nil, fmt.Errorf("%w: 未配置JWT密钥", ErrUnauthenticated)
+			}
+			return a.jwt.verify(credential)
+		}
+	}
+	if credential == "" {
+		return nil, fmt.Errorf("%w: 缺少API key或JWT", ErrUnauthenticated)
+	}
+
+	a.mu.RLock()
+	key, ok := a.keys[hashKey(credential)]
+	a.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: 无效的API key", ErrUnauthenticated)
+	}
+	return &Principal{Subject: key.Name, Method: MethodAPIKey, Scopes: key.Scopes, Namespaces: key.Namespaces}, nil
+}
+
+// Middleware 校验请求的凭证，失败时返回401。未启用认证时所有请求拥有全部权限
+func (a *Authenticator) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !a.enabled {
+			c.Set(principalContextKey, anonymous)
+			c.Next()
+			return
+		}
+
+		principal, err := a.Authenticate(c.Request.Header)
+		if err != nil {
+			c.Header("WWW-Authenticate", `Bearer realm="image-search"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		c.Set(principalContextKey, principal)
+		c.Next()
+	}
+}
+
+// Require 要求调用方拥有指定权限，否则返回403。需在 Middleware 之后使用
+func Require(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal := PrincipalFrom(c)
+		if principal == nil || !principal.Has(scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"success": false,
+				"message": fmt.Sprintf("权限不足，需要 %s 权限", scope),
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireNamespace 要求调用方可以访问指定命名空间，否则返回403
+func RequireNamespace(c *gin.Context, namespace string) bool {
+	if PrincipalFrom(c).CanAccess(namespace) {
+		return true
+	}
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+		"success": false,
+		"message": fmt.Sprintf("无权访问命名空间 %s", namespace),
+	})
+	return false
+}
+
+// PrincipalFrom 返回请求的调用方，未经过 Middleware 时返回nil
+func PrincipalFrom(c *gin.Context) *Principal {
+	if principal, ok := c.Get(principalContextKey); ok {
+		return principal.(*Principal)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"image-search-go/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// jwtLeeway 校验exp和nbf时允许的时钟误差
+const jwtLeeway = 30 * time.Second
+
+// jwtClaims 支持的JWT声明。权限来自 scope（空格分隔，OAuth 2.0约定）或 scopes（数组），
+// 可以访问的命名空间来自 namespaces（数组，* 表示全部）
+type jwtClaims struct {
+	jwt.RegisteredClaims
+	Scope      string   `json:"scope,omitempty"`
+	Scopes     []string `json:"scopes,omitempty"`
+	Namespaces []string `json:"namespaces,omitempty"`
+}
+
+// jwtVerifier 校验HMAC或RSA签名的JWT
+type jwtVerifier struct {
+	secret    []byte
+	publicKey *rsa.PublicKey
+	parser    *jwt.Parser
+}
+
+// newJWTVerifier 按配置创建JWT校验器，未配置密钥时返回nil
+func newJWTVerifier(cfg *config.AuthConfig) (*jwtVerifier, error) {
+	if cfg.JWTSecret == "" && cfg.JWTPublicKeyFile == "" {
+		return nil, nil
+	}
+
+	v := &jwtVerifier{}
+	var methods []string
+	if cfg.JWTSecret != "" {
+		v.secret = []byte(cfg.JWTSecret)
+		methods = append(methods, "HS256", "HS384", "HS512")
+	}
+	if cfg.JWTPublicKeyFile != "" {
+		data, err := os.ReadFile(cfg.JWTPublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取JWT公钥失败: %w", err)
+		}
+		if v.publicKey, err = jwt.ParseRSAPublicKeyFromPEM(data); err != nil {
+			return nil, fmt.Errorf("解析JWT公钥失败: %w", err)
+		}
+		methods = append(methods, "RS256", "RS384", "RS512", "PS256", "PS384", "PS512")
+	}
+
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(methods),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(jwtLeeway),
+	}
+	if cfg.JWTIssuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.JWTIssuer))
+	}
+	if cfg.JWTAudience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.JWTAudience))
+	}
+	v.parser = jwt.NewParser(opts...)
+	return v, nil
+}
+
+// key 按签名算法选择校验密钥
+func (v *jwtVerifier) key(token *jwt.Token) (interface{}, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		return v.secret, nil
+	default:
+		return v.publicKey, nil
+	}
+}
+
+// verify 校验JWT并返回调用方，权限中未知的值被忽略，命名空间无效时拒绝
+func (v *jwtVerifier) verify(token string) (*Principal, error) {
+	claims := &jwtClaims{}
+	if _, err := v.parser.ParseWithClaims(token, claims, v.key); err != nil {
+		return nil, fmt.Errorf("%w: 无效的JWT: %v", ErrUnauthenticated, err)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: JWT缺少sub", ErrUnauthenticated)
+	}
+
+	var scopes []string
+	for _, scope := range append(strings.Fields(claims.Scope), claims.Scopes...) {
+		for _, known := range Scopes {
+			if scope == known {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	namespaces, err := normalizeNamespaces(claims.Namespaces)
+	if err != nil {
+		return nil, fmt.Errorf("%w: JWT的namespaces无效: %v", ErrUnauthenticated, err)
+	}
+	return &Principal{Subject: claims.Subject, Method: MethodJWT, Scopes: scopes, Namespaces: namespaces}, nil
+}
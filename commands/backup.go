@@ -0,0 +1,378 @@
+package commands
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"image-search-go/config"
+	"image-search-go/services"
+	"image-search-go/storage"
+)
+
+// backupEnv 导出和导入共用的服务
+type backupEnv struct {
+	milvusService *services.MilvusService
+	blobStore     storage.BlobStore
+	metadataStore *services.MetadataStore
+	namespaces    *services.NamespaceManager
+}
+
+func openBackupEnv(ctx context.Context, cfg *config.Config) (*backupEnv, error) {
+	milvusService, err := services.NewMilvusService(ctx, &cfg.Milvus)
+	if err != nil {
+		return nil, fmt.Errorf("初始化Milvus服务失败: %v", err)
+	}
+
+	blobStore, err := storage.NewBlobStore(ctx, cfg)
+	if err != nil {
+		milvusService.Close()
+		return nil, fmt.Errorf("初始化图像存储失败: %v", err)
+	}
+
+	metadataStore, err := services.NewMetadataStore(cfg.Server.MetadataPath)
+	if err != nil {
+		milvusService.Close()
+		return nil, fmt.Errorf("初始化元数据存储失败: %v", err)
+	}
+
+	namespaces, err := services.NewNamespaceManager(ctx, milvusService, cfg, metadataStore, blobStore)
+	if err != nil {
+		metadataStore.Close()
+		milvusService.Close()
+		return nil, fmt.Errorf("初始化命名空间失败: %v", err)
+	}
+
+	return &backupEnv{
+		milvusService: milvusService,
+		blobStore:     blobStore,
+		metadataStore: metadataStore,
+		namespaces:    namespaces,
+	}, nil
+}
+
+func (e *backupEnv) Close() {
+	e.namespaces.Close()
+	e.metadataStore.Close()
+	e.milvusService.Close()
+}
+
+// RunExport 执行 export 子命令：把命名空间的全部记录（向量、时间戳、元数据）及可选的图像文件导出到备份目录
+func RunExport(ctx context.Context, cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	out := fs.String("out", "", "备份目录，默认 backups/<collection>-<时间>")
+	format := fs.String("format", services.BackupFormatJSONL, "向量格式: jsonl 或 npy")
+	withFiles := fs.Bool("files", false, "同时导出图像文件")
+	namespace := fs.String("namespace", services.DefaultNamespace, "导出的命名空间")
+	batchSize := fs.Int("batch", 1000, "遍历集合时每批查询的记录数")
+	fs.Parse(args)
+
+	env, err := openBackupEnv(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer env.Close()
+
+	tenant, err := env.namespaces.Get(*namespace)
+	if err != nil {
+		return fmt.Errorf("命名空间 %s: %v", *namespace, err)
+	}
+	ns := tenant.Namespace
+
+	dir := *out
+	if dir == "" {
+		dir = filepath.Join("backups", fmt.Sprintf("%s-%s", ns.Collection, time.Now().Format("20060102-150405")))
+	}
+
+	writer, err := services.CreateBackup(dir, &services.BackupManifest{
+		Format:        *format,
+		Namespace:     ns.Name,
+		Collection:    ns.Collection,
+		SchemaVersion: services.LatestSchemaVersion,
+		Extractor:     ns.Extractor,
+		Dimension:     ns.Dimension,
+		IndexType:     ns.IndexType,
+		MetricType:    ns.MetricType,
+		PartitionBy:   ns.PartitionBy,
+	})
+	if err != nil {
+		return err
+	}
+
+	exported := map[string]bool{}
+	missingFiles := 0
+	err = tenant.Milvus.ScanImages(ctx, *batchSize, true, func(batch []*services.ImageRecord) error {
+		imageIDs := make([]string, len(batch))
+		for i, record := range batch {
+			imageIDs[i] = record.ImageID
+		}
+		metas, err := env.metadataStore.GetMany(imageIDs)
+		if err != nil {
+			return fmt.Errorf("查询元数据失败: %v", err)
+		}
+
+		for _, record := range batch {
+			// 重复行只导出一次
+			if exported[record.ImageID] {
+				continue
+			}
+			exported[record.ImageID] = true
+
+			row := &services.BackupRow{
+				ImageID:   record.ImageID,
+				Vector:    record.Vector,
+				Timestamp: record.Timestamp,
+				ObjectKey: record.ObjectKey,
+				MimeType:  record.MimeType,
+				FileSize:  record.FileSize,
+			}
+			if meta := metas[record.ImageID]; tenant.Owns(meta) {
+				row.Metadata = meta
+				if row.ObjectKey == "" {
+					row.ObjectKey, row.MimeType, row.FileSize = meta.ObjectKey, meta.MimeType, meta.FileSize
+				}
+			}
+			if err := writer.WriteRow(row); err != nil {
+				return err
+			}
+
+			if *withFiles && row.ObjectKey != "" {
+				if err := exportFile(ctx, env.blobStore, writer, row.ObjectKey); err != nil {
+					log.Printf("导出图像文件 %s 失败: %v", row.ObjectKey, err)
+					missingFiles++
+				}
+			}
+		}
+		log.Printf("已导出 %d 条记录", len(exported))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	log.Printf("导出完成：%d 条记录已写入 %s", len(exported), dir)
+	if missingFiles > 0 {
+		log.Printf("%d 个图像文件导出失败，导入后这些图像没有文件", missingFiles)
+	}
+	return nil
+}
+
+// exportFile 把一个图像文件复制到备份中
+func exportFile(ctx context.Context, blobStore storage.BlobStore, writer *services.BackupWriter, key string) error {
+	r, _, err := blobStore.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return writer.WriteFile(key, r)
+}
+
+// RunImport 执行 import 子命令：从备份目录重建命名空间的collection和索引，写入记录、元数据和图像文件
+func RunImport(ctx context.Context, cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	in := fs.String("in", "", "备份目录")
+	namespace := fs.String("namespace", "", "导入的命名空间，默认使用备份中的命名空间，不存在时按备份配置创建")
+	drop := fs.Bool("drop", false, "导入前删除目标命名空间的现有数据")
+	appendRows := fs.Bool("append", false, "目标命名空间已有数据时追加导入")
+	batchSize := fs.Int("batch", 1000, "每批写入的记录数")
+	fs.Parse(args)
+
+	if *in == "" {
+		return fmt.Errorf("用法: import -in 备份目录 [-namespace 名称] [-drop] [-append] [-batch 1000]")
+	}
+
+	reader, err := services.OpenBackup(*in)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	manifest := reader.Manifest()
+
+	name := *namespace
+	if name == "" {
+		name = manifest.Namespace
+	}
+	if name == "" {
+		name = services.DefaultNamespace
+	}
+
+	env, err := openBackupEnv(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer env.Close()
+
+	tenant, err := importTarget(ctx, env, manifest, name, *drop)
+	if err != nil {
+		return err
+	}
+	if tenant.Namespace.Dimension != manifest.Dimension {
+		return fmt.Errorf("备份的向量维度 %d 与命名空间 %s 的维度 %d 不一致", manifest.Dimension, name, tenant.Namespace.Dimension)
+	}
+	if tenant.Namespace.Extractor != manifest.Extractor {
+		log.Printf("备份使用特征提取器 %s，命名空间 %s 使用 %s，新上传图像的向量可能与导入的向量不可比",
+			manifest.Extractor, name, tenant.Namespace.Extractor)
+	}
+
+	count, err := tenant.Milvus.RowCount(ctx)
+	if err != nil {
+		return err
+	}
+	if count > 0 && !*appendRows {
+		return fmt.Errorf("命名空间 %s 已有 %d 条记录，使用 -drop 覆盖或 -append 追加", name, count)
+	}
+
+	// 图像文件key按目标命名空间的前缀改写
+	sourcePrefix := services.NamespaceKeyPrefix(manifest.Namespace)
+	targetPrefix := tenant.ObjectKeyPrefix()
+	targetNamespace := ""
+	if name != services.DefaultNamespace {
+		targetNamespace = name
+	}
+
+	var records []*services.ImageRecord
+	imported, files := 0, 0
+	flush := func() error {
+		if len(records) == 0 {
+			return nil
+		}
+		if err := tenant.Milvus.InsertImages(ctx, records); err != nil {
+			return err
+		}
+		imported += len(records)
+		records = records[:0]
+		log.Printf("已导入 %d/%d 条记录", imported, manifest.RowCount)
+		return nil
+	}
+
+	for {
+		row, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		record := &services.ImageRecord{
+			ImageID:   row.ImageID,
+			Vector:    row.Vector,
+			Timestamp: row.Timestamp,
+			MimeType:  row.MimeType,
+			FileSize:  row.FileSize,
+		}
+		if row.ObjectKey != "" {
+			record.ObjectKey = targetPrefix + strings.TrimPrefix(row.ObjectKey, sourcePrefix)
+			copied, err := importFile(ctx, env.blobStore, reader, row.ObjectKey, record.ObjectKey, row.MimeType)
+			if err != nil {
+				return err
+			}
+			if copied {
+				files++
+			}
+		}
+
+		if meta := row.Metadata; meta != nil {
+			meta.ObjectKey = record.ObjectKey
+			meta.Namespace = targetNamespace
+			if err := env.metadataStore.Put(meta); err != nil {
+				return fmt.Errorf("写入元数据 %s 失败: %v", meta.ImageID, err)
+			}
+			record.Category = meta.Category
+		}
+
+		records = append(records, record)
+		if len(records) >= *batchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	log.Printf("导入完成：%d 条记录、%d 个图像文件已导入命名空间 %s（collection: %s）",
+		imported, files, name, tenant.Namespace.Collection)
+	return nil
+}
+
+// importTarget 准备导入的目标命名空间：需要时删除现有数据，不存在的命名空间按备份配置创建
+func importTarget(ctx context.Context, env *backupEnv, manifest *services.BackupManifest, name string, drop bool) (*services.Tenant, error) {
+	if name == services.DefaultNamespace {
+		tenant := env.namespaces.Default()
+		if !drop {
+			return tenant, nil
+		}
+
+		// 默认命名空间的collection由配置决定，删除后按配置重建
+		if err := tenant.Milvus.Recreate(ctx); err != nil {
+			return nil, err
+		}
+		var imageIDs []string
+		err := env.metadataStore.ForEach(func(meta *services.ImageMetadata) error {
+			if tenant.Owns(meta) {
+				imageIDs = append(imageIDs, meta.ImageID)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, imageID := range imageIDs {
+			if err := env.metadataStore.Delete(imageID); err != nil {
+				return nil, err
+			}
+		}
+		log.Printf("已删除默认命名空间的 collection 和 %d 条元数据", len(imageIDs))
+		return tenant, nil
+	}
+
+	if drop {
+		result, err := env.namespaces.Drop(ctx, name)
+		if err != nil && err != services.ErrNamespaceNotFound {
+			return nil, err
+		}
+		if err == nil {
+			log.Printf("已删除命名空间 %s（%d 条元数据，%d 个图像文件）", name, result.MetadataDeleted, result.FilesDeleted)
+		}
+	}
+
+	if tenant, err := env.namespaces.Get(name); err == nil {
+		return tenant, nil
+	}
+	return env.namespaces.Create(ctx, &services.Namespace{
+		Name:        name,
+		Extractor:   manifest.Extractor,
+		Dimension:   manifest.Dimension,
+		IndexType:   manifest.IndexType,
+		MetricType:  manifest.MetricType,
+		PartitionBy: manifest.PartitionBy,
+	})
+}
+
+// importFile 把备份中的图像文件写入存储，备份不包含该文件时跳过
+func importFile(ctx context.Context, blobStore storage.BlobStore, reader *services.BackupReader, sourceKey, targetKey, mimeType string) (bool, error) {
+	f, size, err := reader.OpenFile(sourceKey)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	if err := blobStore.Put(ctx, targetKey, f, size, mimeType); err != nil {
+		return false, fmt.Errorf("写入图像文件 %s 失败: %v", targetKey, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,147 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"image-search-go/config"
+	"image-search-go/services"
+)
+
+// RunMigrate 执行 migrate 子命令：plan 比较collection结构并输出迁移计划，apply 执行迁移
+func RunMigrate(ctx context.Context, cfg *config.Config, args []string) error {
+	if len(args) == 0 || (args[0] != "plan" && args[0] != "apply") {
+		return fmt.Errorf("用法: migrate plan|apply [-namespace 名称] [-batch 1000] [-drop-old] [-json]")
+	}
+	mode := args[0]
+
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	namespace := fs.String("namespace", services.DefaultNamespace, "迁移的命名空间")
+	batchSize := fs.Int("batch", 1000, "复制数据时每批的记录数")
+	dropOld := fs.Bool("drop-old", false, "迁移后删除旧collection（默认保留以便回滚）")
+	jsonOutput := fs.Bool("json", false, "以JSON格式输出迁移计划")
+	fs.Parse(args[1:])
+
+	// 元数据库用于解析命名空间和补充旧结构中没有的文件信息。服务运行中时数据库被占用：
+	// plan 跳过补充；apply 拒绝执行，否则复制期间服务写入旧collection的记录会在切换后丢失
+	metadataStore, err := services.NewMetadataStore(cfg.Server.MetadataPath)
+	if err != nil && (mode == "apply" || *namespace != services.DefaultNamespace) {
+		return fmt.Errorf("初始化元数据存储失败: %v", err)
+	}
+	if err != nil {
+		log.Printf("元数据库不可用，迁移时不补充文件信息: %v", err)
+		metadataStore = nil
+	} else {
+		defer metadataStore.Close()
+	}
+
+	milvusConfig, err := migrateConfig(cfg, metadataStore, *namespace)
+	if err != nil {
+		return err
+	}
+
+	migrator, err := services.NewMigrator(ctx, milvusConfig)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+	if metadataStore != nil {
+		migrator.Backfill = backfillFileFields(metadataStore)
+	}
+
+	plan, err := migrator.Plan(ctx)
+	if err != nil {
+		return err
+	}
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(plan); err != nil {
+			return err
+		}
+	} else {
+		printPlan(os.Stdout, plan)
+	}
+
+	if mode == "plan" || plan.UpToDate {
+		return nil
+	}
+
+	err = migrator.Apply(ctx, plan, *batchSize, *dropOld, func(copied int64) {
+		log.Printf("已复制 %d/%d 条记录", copied, plan.RowCount)
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("迁移完成：%s 已升级到结构版本 %d，请重启服务", plan.Collection, plan.TargetVersion)
+	return nil
+}
+
+// migrateConfig 命名空间对应的Milvus配置
+func migrateConfig(cfg *config.Config, metadataStore *services.MetadataStore, namespace string) (*config.MilvusConfig, error) {
+	if namespace == services.DefaultNamespace {
+		return &cfg.Milvus, nil
+	}
+
+	namespaces, err := metadataStore.ListNamespaces()
+	if err != nil {
+		return nil, err
+	}
+	for _, ns := range namespaces {
+		if ns.Name == namespace {
+			return services.NamespaceMilvusConfig(cfg.Milvus, ns), nil
+		}
+	}
+	return nil, fmt.Errorf("命名空间 %s: %v", namespace, services.ErrNamespaceNotFound)
+}
+
+// backfillFileFields 从元数据库补充记录的文件信息
+func backfillFileFields(metadataStore *services.MetadataStore) func(records []*services.ImageRecord) {
+	return func(records []*services.ImageRecord) {
+		var imageIDs []string
+		for _, record := range records {
+			if record.ObjectKey == "" {
+				imageIDs = append(imageIDs, record.ImageID)
+			}
+		}
+		if len(imageIDs) == 0 {
+			return
+		}
+
+		metas, err := metadataStore.GetMany(imageIDs)
+		if err != nil {
+			log.Printf("查询元数据失败，跳过补充文件信息: %v", err)
+			return
+		}
+		for _, record := range records {
+			if meta := metas[record.ImageID]; meta != nil && record.ObjectKey == "" {
+				record.ObjectKey, record.MimeType, record.FileSize = meta.ObjectKey, meta.MimeType, meta.FileSize
+			}
+		}
+	}
+}
+
+// printPlan 以文本格式输出迁移计划
+func printPlan(w io.Writer, plan *services.MigrationPlan) {
+	fmt.Fprintf(w, "Collection: %s（实际: %s）\n", plan.Collection, plan.Physical)
+	fmt.Fprintf(w, "  结构版本: %d，最新版本: %d\n", plan.CurrentVersion, plan.TargetVersion)
+	fmt.Fprintf(w, "  差异: %s\n", plan.Diff)
+	fmt.Fprintf(w, "  记录数: %d，分区: %v\n", plan.RowCount, plan.Partitions)
+
+	switch {
+	case plan.UpToDate:
+		fmt.Fprintln(w, "  已是最新结构，无需迁移")
+	case plan.Blocked != "":
+		fmt.Fprintf(w, "  无法自动迁移: %s\n", plan.Blocked)
+	default:
+		fmt.Fprintln(w, "  迁移步骤:")
+		for i, step := range plan.Steps {
+			fmt.Fprintf(w, "    %d. %s\n", i+1, step)
+		}
+	}
+}
@@ -0,0 +1,407 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"image-search-go/config"
+	"image-search-go/models"
+	"image-search-go/services"
+	"image-search-go/storage"
+	"image-search-go/utils"
+)
+
+// ReconcileReport 一致性检查报告
+type ReconcileReport struct {
+	DryRun                 bool           `json:"dry_run"`
+	FilesScanned           int            `json:"files_scanned"`
+	VectorsScanned         int            `json:"vectors_scanned"`
+	OrphanFiles            []string       `json:"orphan_files"`             // 没有向量的文件
+	VectorsWithoutFiles    []string       `json:"vectors_without_files"`    // 没有文件的向量（image_id）
+	DuplicateImages        map[string]int `json:"duplicate_images"`         // image_id -> 行数
+	MetadataWithoutVectors []string       `json:"metadata_without_vectors"` // 没有向量的元数据（image_id）
+	SkippedRecent          int            `json:"skipped_recent"`           // 未达到 -min-age 而跳过的文件、向量和元数据
+	Fixed                  ReconcileFixed `json:"fixed"`
+	Errors                 []string       `json:"errors,omitempty"`
+}
+
+// ReconcileFixed 修复操作统计
+type ReconcileFixed struct {
+	FilesDeleted      int `json:"files_deleted"`
+	FilesReingested   int `json:"files_reingested"`
+	VectorsDeleted    int `json:"vectors_deleted"`
+	DuplicatesRemoved int `json:"duplicates_removed"`
+	MetadataDeleted   int `json:"metadata_deleted"`
+}
+
+// reconciler 一致性检查器
+type reconciler struct {
+	tenant        *services.Tenant
+	milvusService *services.MilvusService
+	blobStore     storage.BlobStore
+	metadataStore *services.MetadataStore
+	extractor     models.FeatureExtractor
+	report        *ReconcileReport
+	// vectorRows 按image_id分组的向量记录
+	vectorRows map[string][]*services.ImageRecord
+	// cutoff 晚于该时间写入的文件、向量和元数据可能属于进行中的上传，不计入不一致
+	cutoff time.Time
+}
+
+// RunReconcile 执行 reconcile 子命令：检查并修复文件、向量和元数据之间的不一致
+func RunReconcile(ctx context.Context, cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", true, "只报告不修复，使用 -dry-run=false 执行修复")
+	jsonOutput := fs.Bool("json", false, "以JSON格式输出报告")
+	reingest := fs.Bool("reingest", false, "修复时重新提取孤立文件的特征并写入向量，而不是删除文件")
+	batchSize := fs.Int("batch", 1000, "遍历集合时每批查询的记录数")
+	namespace := fs.String("namespace", services.DefaultNamespace, "检查的命名空间")
+	minAge := fs.Duration("min-age", 15*time.Minute, "只处理写入时间早于该时长的文件、向量和元数据，跳过可能仍在上传中的记录")
+	fs.Parse(args)
+
+	// 先打开元数据库：服务运行时无法获得文件锁，尽早退出
+	metadataStore, err := services.NewMetadataStore(cfg.Server.MetadataPath)
+	if err != nil {
+		return fmt.Errorf("初始化元数据存储失败: %v", err)
+	}
+	defer metadataStore.Close()
+
+	milvusService, err := services.NewMilvusService(ctx, &cfg.Milvus)
+	if err != nil {
+		return fmt.Errorf("初始化Milvus服务失败: %v", err)
+	}
+	defer milvusService.Close()
+
+	blobStore, err := storage.NewBlobStore(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("初始化图像存储失败: %v", err)
+	}
+
+	namespaces, err := services.NewNamespaceManager(ctx, milvusService, cfg, metadataStore, blobStore)
+	if err != nil {
+		return fmt.Errorf("初始化命名空间失败: %v", err)
+	}
+	defer namespaces.Close()
+	tenant, err := namespaces.Get(*namespace)
+	if err != nil {
+		return fmt.Errorf("命名空间 %s: %v", *namespace, err)
+	}
+
+	r := &reconciler{
+		tenant:        tenant,
+		milvusService: tenant.Milvus,
+		blobStore:     blobStore,
+		metadataStore: metadataStore,
+		extractor:     tenant.Extractor,
+		cutoff:        time.Now().Add(-*minAge),
+		report: &ReconcileReport{
+			DryRun:          *dryRun,
+			DuplicateImages: map[string]int{},
+		},
+	}
+
+	if err := r.check(ctx, *batchSize); err != nil {
+		return err
+	}
+	if !*dryRun {
+		r.fix(ctx, *reingest)
+	}
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(r.report)
+	}
+	r.report.print(os.Stdout)
+	return nil
+}
+
+// check 扫描文件、向量和元数据，生成报告
+func (r *reconciler) check(ctx context.Context, batchSize int) error {
+	// 扫描向量
+	r.vectorRows = map[string][]*services.ImageRecord{}
+	err := r.milvusService.ScanImages(ctx, batchSize, false, func(batch []*services.ImageRecord) error {
+		for _, record := range batch {
+			r.vectorRows[record.ImageID] = append(r.vectorRows[record.ImageID], record)
+		}
+		r.report.VectorsScanned += len(batch)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// 扫描文件
+	files := map[string]bool{}
+	recentFiles := map[string]bool{}
+	filesByImageID := map[string]string{}
+	prefix := r.tenant.ObjectKeyPrefix()
+	err = r.blobStore.List(ctx, prefix, func(info *storage.ObjectInfo) error {
+		// 默认命名空间的文件没有目录前缀，带前缀的属于其他命名空间
+		if !utils.IsValidImageFormat(info.Key) || strings.Contains(strings.TrimPrefix(info.Key, prefix), "/") {
+			return nil
+		}
+		files[info.Key] = true
+		filesByImageID[imageIDFromKey(info.Key)] = info.Key
+		r.report.FilesScanned++
+		if r.recent(info.LastModified) {
+			recentFiles[info.Key] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("遍历图像文件失败: %v", err)
+	}
+
+	// 没有向量的文件
+	for key := range files {
+		if _, ok := r.vectorRows[imageIDFromKey(key)]; !ok {
+			if recentFiles[key] {
+				r.report.SkippedRecent++
+				continue
+			}
+			r.report.OrphanFiles = append(r.report.OrphanFiles, key)
+		}
+	}
+
+	// 没有文件的向量、重复行
+	for imageID, rows := range r.vectorRows {
+		if len(rows) > 1 {
+			r.report.DuplicateImages[imageID] = len(rows)
+		}
+
+		key := rows[0].ObjectKey
+		if key == "" {
+			key = filesByImageID[imageID]
+		}
+		if !files[key] {
+			if r.recent(time.Unix(newestTimestamp(rows), 0)) {
+				r.report.SkippedRecent++
+				continue
+			}
+			r.report.VectorsWithoutFiles = append(r.report.VectorsWithoutFiles, imageID)
+		}
+	}
+
+	// 没有向量的元数据
+	err = r.metadataStore.ForEach(func(meta *services.ImageMetadata) error {
+		if !r.tenant.Owns(meta) {
+			return nil
+		}
+		if _, ok := r.vectorRows[meta.ImageID]; !ok {
+			if r.recent(meta.CreatedAt) {
+				r.report.SkippedRecent++
+				return nil
+			}
+			r.report.MetadataWithoutVectors = append(r.report.MetadataWithoutVectors, meta.ImageID)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("遍历元数据失败: %v", err)
+	}
+
+	sort.Strings(r.report.OrphanFiles)
+	sort.Strings(r.report.VectorsWithoutFiles)
+	sort.Strings(r.report.MetadataWithoutVectors)
+	return nil
+}
+
+// recent 判断写入时间是否晚于 -min-age 的截止时间，时间未知时按已过期处理
+func (r *reconciler) recent(t time.Time) bool {
+	return !t.IsZero() && t.After(r.cutoff)
+}
+
+// newestTimestamp 返回同一image_id各行中最新的写入时间（Unix秒）
+func newestTimestamp(rows []*services.ImageRecord) int64 {
+	var newest int64
+	for _, row := range rows {
+		if row.Timestamp > newest {
+			newest = row.Timestamp
+		}
+	}
+	return newest
+}
+
+// fix 按报告修复不一致
+func (r *reconciler) fix(ctx context.Context, reingest bool) {
+	// 孤立文件：重新入库或删除
+	reingested := map[string]bool{}
+	for _, key := range r.report.OrphanFiles {
+		if reingest {
+			if err := r.reingestFile(ctx, key); err != nil {
+				r.addError("重新入库 %s 失败: %v", key, err)
+				continue
+			}
+			reingested[imageIDFromKey(key)] = true
+			r.report.Fixed.FilesReingested++
+			continue
+		}
+
+		if err := r.blobStore.Delete(ctx, key); err != nil {
+			r.addError("删除文件 %s 失败: %v", key, err)
+			continue
+		}
+		r.report.Fixed.FilesDeleted++
+	}
+
+	// 没有文件的向量：删除向量及元数据
+	deleted := map[string]bool{}
+	for _, imageID := range r.report.VectorsWithoutFiles {
+		if err := r.milvusService.DeleteVector(ctx, imageID); err != nil {
+			r.addError("删除向量 %s 失败: %v", imageID, err)
+			continue
+		}
+		deleted[imageID] = true
+		r.report.Fixed.VectorsDeleted++
+
+		if err := r.metadataStore.Delete(imageID); err != nil {
+			r.addError("删除元数据 %s 失败: %v", imageID, err)
+		}
+	}
+
+	// 重复行：保留最新的一行
+	for imageID := range r.report.DuplicateImages {
+		if deleted[imageID] {
+			continue
+		}
+		rows := r.vectorRows[imageID]
+		sort.Slice(rows, func(i, j int) bool {
+			if rows[i].Timestamp != rows[j].Timestamp {
+				return rows[i].Timestamp > rows[j].Timestamp
+			}
+			return rows[i].ID > rows[j].ID
+		})
+
+		var ids []int64
+		for _, row := range rows[1:] {
+			ids = append(ids, row.ID)
+		}
+		if err := r.milvusService.DeleteByIDs(ctx, ids); err != nil {
+			r.addError("删除重复向量 %s 失败: %v", imageID, err)
+			continue
+		}
+		r.report.Fixed.DuplicatesRemoved += len(ids)
+	}
+
+	// 没有向量的元数据（重新入库的除外）
+	for _, imageID := range r.report.MetadataWithoutVectors {
+		if reingested[imageID] {
+			continue
+		}
+		if err := r.metadataStore.Delete(imageID); err != nil {
+			r.addError("删除元数据 %s 失败: %v", imageID, err)
+			continue
+		}
+		r.report.Fixed.MetadataDeleted++
+	}
+}
+
+// reingestFile 重新提取孤立文件的特征，沿用文件名中的image_id写入向量
+func (r *reconciler) reingestFile(ctx context.Context, key string) error {
+	reader, info, err := r.blobStore.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		return err
+	}
+
+	img, err := utils.LoadImageFromBytes(data)
+	if err != nil {
+		return err
+	}
+	features, err := r.extractor.ExtractFeatures(ctx, img)
+	if err != nil {
+		return err
+	}
+
+	imageID := imageIDFromKey(key)
+	mimeType := utils.ContentTypeByFilename(key)
+
+	// 保留已有的元数据（描述、标签等），没有时补充基本信息
+	meta, err := r.metadataStore.Get(imageID)
+	if err != nil {
+		return err
+	}
+	if meta == nil {
+		meta = &services.ImageMetadata{ImageID: imageID, OriginalFilename: path.Base(key)}
+		if name := r.tenant.Namespace.Name; name != services.DefaultNamespace {
+			meta.Namespace = name
+		}
+		if imageInfo, err := utils.GetImageInfoFromBytes(data, key); err == nil {
+			meta.Width, meta.Height, meta.Format, meta.Exif = imageInfo.Width, imageInfo.Height, imageInfo.Format, imageInfo.Exif
+		}
+	}
+	meta.ObjectKey, meta.MimeType, meta.FileSize = key, mimeType, info.Size
+	if err := r.metadataStore.Put(meta); err != nil {
+		return err
+	}
+
+	return r.milvusService.InsertImages(ctx, []*services.ImageRecord{{
+		ImageID:   imageID,
+		Vector:    features,
+		ObjectKey: key,
+		MimeType:  mimeType,
+		FileSize:  info.Size,
+		Category:  meta.Category,
+	}})
+}
+
+// addError 记录修复过程中的错误
+func (r *reconciler) addError(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	log.Println(message)
+	r.report.Errors = append(r.report.Errors, message)
+}
+
+// print 以文本格式输出报告
+func (report *ReconcileReport) print(w io.Writer) {
+	mode := "修复"
+	if report.DryRun {
+		mode = "dry-run"
+	}
+
+	fmt.Fprintf(w, "一致性检查（%s）\n", mode)
+	fmt.Fprintf(w, "  扫描文件: %d，扫描向量: %d，跳过最近写入: %d\n", report.FilesScanned, report.VectorsScanned, report.SkippedRecent)
+	printList(w, "没有向量的文件", report.OrphanFiles)
+	printList(w, "没有文件的向量", report.VectorsWithoutFiles)
+	printList(w, "没有向量的元数据", report.MetadataWithoutVectors)
+
+	fmt.Fprintf(w, "  重复的image_id: %d\n", len(report.DuplicateImages))
+	for imageID, count := range report.DuplicateImages {
+		fmt.Fprintf(w, "    %s (%d 行)\n", imageID, count)
+	}
+
+	if !report.DryRun {
+		fixed := report.Fixed
+		fmt.Fprintf(w, "  已删除文件: %d，已重新入库: %d，已删除向量: %d，已删除重复行: %d，已删除元数据: %d\n",
+			fixed.FilesDeleted, fixed.FilesReingested, fixed.VectorsDeleted, fixed.DuplicatesRemoved, fixed.MetadataDeleted)
+	}
+	printList(w, "错误", report.Errors)
+}
+
+// printList 输出列表项
+func printList(w io.Writer, title string, items []string) {
+	fmt.Fprintf(w, "  %s: %d\n", title, len(items))
+	for _, item := range items {
+		fmt.Fprintf(w, "    %s\n", item)
+	}
+}
+
+// imageIDFromKey 从文件key中解析image_id（去掉目录和扩展名）
+func imageIDFromKey(key string) string {
+	base := path.Base(key)
+	return strings.TrimSuffix(base, path.Ext(base))
+}
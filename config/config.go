@@ -1,22 +1,131 @@
 package config
 
 import (
+	"fmt"
+	"log/slog"
 	"os"
+	"runtime"
 	"strconv"
+	"time"
 )
 
 // Config 应用配置结构
 type Config struct {
-	Server ServerConfig `json:"server"`
-	Milvus MilvusConfig `json:"milvus"`
+	Server     ServerConfig     `json:"server"`
+	Milvus     MilvusConfig     `json:"milvus"`
+	Storage    StorageConfig    `json:"storage"`
+	Timeouts   TimeoutConfig    `json:"timeouts"`
+	Tracing    TracingConfig    `json:"tracing"`
+	Logging    LoggingConfig    `json:"logging"`
+	Auth       AuthConfig       `json:"auth"`
+	RateLimit  RateLimitConfig  `json:"rate_limit"`
+	Extraction ExtractionConfig `json:"extraction"`
+}
+
+// ExtractionConfig 特征提取池：同时执行的提取数、排队上限和排队等待时间，超出时返回503
+type ExtractionConfig struct {
+	Workers      int           `json:"workers"`       // 同时执行的提取数，默认为CPU数
+	QueueSize    int           `json:"queue_size"`    // 最多排队的请求数，队列满时直接拒绝
+	QueueTimeout time.Duration `json:"queue_timeout"` // 排队等待的最长时间，0表示只受请求超时限制
+	MaxImages    int           `json:"max_images"`    // 同时读取、解码和等待提取的图像数，限制内存中的上传内容和解码后的图像，不小于Workers
+}
+
+// RateLimit 令牌桶限流：每秒补充Rate个令牌，最多累积Burst个。Rate为0时不限流
+type RateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// RateLimitConfig 按客户端（API key/JWT调用方，未认证时为IP）的限流和每日配额
+type RateLimitConfig struct {
+	Search RateLimit `json:"search"` // 各类搜索（含特征提取）
+	Upload RateLimit `json:"upload"`
+	Delete RateLimit `json:"delete"`
+	// DailyImages、DailyBytes 每个客户端每天（UTC）最多存入的图像数和字节数（当天删除的图像归还用量），0表示不限制
+	DailyImages int64 `json:"daily_images"`
+	DailyBytes  int64 `json:"daily_bytes"`
+}
+
+// AuthConfig 认证配置。启用后除健康检查、指标和API文档外的接口都需要API key或JWT
+type AuthConfig struct {
+	Enabled bool `json:"enabled"`
+	// APIKeys 静态API key，格式为 名称:key:权限,权限[:命名空间,命名空间]，多个key用分号分隔，
+	// 如 "ci:secret:read,write:team_a;ops:secret2:admin"
+	APIKeys string `json:"-"`
+	// JWTSecret HMAC签名（HS256/HS384/HS512）JWT的密钥
+	JWTSecret string `json:"-"`
+	// JWTPublicKeyFile RSA签名（RS*/PS*）JWT的PEM公钥文件
+	JWTPublicKeyFile string `json:"jwt_public_key_file"`
+	// JWTIssuer、JWTAudience 不为空时校验JWT的iss和aud
+	JWTIssuer   string `json:"jwt_issuer"`
+	JWTAudience string `json:"jwt_audience"`
+	// CORSOrigins 允许跨域访问的来源，逗号分隔，* 表示任意来源；默认为空，不允许跨域访问
+	CORSOrigins string `json:"cors_origins"`
+}
+
+// redacted 日志中代替密钥的占位符，未配置时为空
+func redacted(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "[REDACTED]"
+}
+
+// String 打印配置时隐藏API key和JWT密钥
+func (c AuthConfig) String() string {
+	return fmt.Sprintf("{Enabled:%t APIKeys:%s JWTSecret:%s JWTPublicKeyFile:%s JWTIssuer:%s JWTAudience:%s CORSOrigins:%s}",
+		c.Enabled, redacted(c.APIKeys), redacted(c.JWTSecret), c.JWTPublicKeyFile, c.JWTIssuer, c.JWTAudience, c.CORSOrigins)
+}
+
+// LogValue 记录日志时隐藏API key和JWT密钥
+func (c AuthConfig) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Bool("enabled", c.Enabled),
+		slog.String("api_keys", redacted(c.APIKeys)),
+		slog.String("jwt_secret", redacted(c.JWTSecret)),
+		slog.String("jwt_public_key_file", c.JWTPublicKeyFile),
+		slog.String("jwt_issuer", c.JWTIssuer),
+		slog.String("jwt_audience", c.JWTAudience),
+		slog.String("cors_origins", c.CORSOrigins),
+	)
+}
+
+// LoggingConfig 日志配置
+type LoggingConfig struct {
+	Level  string `json:"level"`  // debug、info、warn 或 error
+	Format string `json:"format"` // json 或 text
+}
+
+// TracingConfig 链路追踪配置。OTLP导出地址等使用OpenTelemetry标准环境变量（如 OTEL_EXPORTER_OTLP_ENDPOINT）
+type TracingConfig struct {
+	Exporter    string  `json:"exporter"` // 空（不导出）、otlp（OTLP/HTTP）或 stdout
+	ServiceName string  `json:"service_name"`
+	SampleRatio float64 `json:"sample_ratio"` // 采样比例 [0,1]，上游已采样的请求始终采样
+}
+
+// TimeoutConfig 各类请求的处理时限，超时后取消对Milvus和特征提取的调用并返回504
+type TimeoutConfig struct {
+	Search time.Duration `json:"search"` // 搜索（含查询图像特征提取）
+	Upload time.Duration `json:"upload"` // 上传（存储文件、提取特征、写入向量）
+	Delete time.Duration `json:"delete"`
+	Stats  time.Duration `json:"stats"` // 统计信息和健康检查
+	Admin  time.Duration `json:"admin"` // 创建和删除命名空间
 }
 
 // ServerConfig 服务器配置
 type ServerConfig struct {
-	Port        string `json:"port"`
-	Host        string `json:"host"`
-	UploadPath  string `json:"upload_path"`
-	MaxFileSize int64  `json:"max_file_size"`
+	Port         string `json:"port"`
+	Host         string `json:"host"`
+	UploadPath   string `json:"upload_path"`
+	MaxFileSize  int64  `json:"max_file_size"`
+	StripExif    bool   `json:"strip_exif"` // 存储文件时移除EXIF隐私信息
+	MetadataPath string `json:"metadata_path"`
+	// IdempotencyTTL 上传幂等键的保留时间
+	IdempotencyTTL time.Duration `json:"idempotency_ttl"`
+	// IdempotencyLease 上传进行中时幂等键的占用时间，超过后（如进程崩溃）允许重试接管，默认为上传超时的3倍
+	IdempotencyLease time.Duration `json:"idempotency_lease"`
+	// MinFreeDisk 上传目录所在磁盘的最小剩余空间（字节），低于该值时就绪检查失败
+	MinFreeDisk int64 `json:"min_free_disk"`
 }
 
 // MilvusConfig Milvus数据库配置
@@ -27,24 +136,140 @@ type MilvusConfig struct {
 	Dimension      int    `json:"dimension"`
 	IndexType      string `json:"index_type"`
 	MetricType     string `json:"metric_type"`
+	// PartitionBy 分区方式：空（不分区）、month（按插入月份）或 category（按分类）
+	PartitionBy string `json:"partition_by"`
+	// MaxLoadedPartitions 最多同时加载到内存的分区数，0表示加载整个collection
+	MaxLoadedPartitions int `json:"max_loaded_partitions"`
+	// InsertBufferSize 写入缓冲的记录数上限，达到后批量写入；不大于1时每次插入直接写入
+	InsertBufferSize int `json:"insert_buffer_size"`
+	// InsertFlushInterval 写入缓冲的最长等待时间
+	InsertFlushInterval time.Duration `json:"insert_flush_interval"`
+	// InsertBufferMaxPending 写入缓冲最多保留的记录数（含写入失败等待重试的记录），超过后拒绝新的写入
+	InsertBufferMaxPending int `json:"insert_buffer_max_pending"`
+	// RetryAttempts 幂等操作（搜索、查询、统计）遇到连接错误时的最多尝试次数
+	RetryAttempts int `json:"retry_attempts"`
+	// RetryBackoff 重试和重连的初始等待时间，之后每次翻倍，不超过MaxBackoff
+	RetryBackoff time.Duration `json:"retry_backoff"`
+	MaxBackoff   time.Duration `json:"max_backoff"`
+	// BreakerThreshold 连续多少次连接错误后熔断，熔断期间直接返回错误；0表示不熔断
+	BreakerThreshold int `json:"breaker_threshold"`
+	// BreakerCooldown 熔断后多久放行一次探测请求
+	BreakerCooldown time.Duration `json:"breaker_cooldown"`
+}
+
+// StorageConfig 图像文件存储配置
+type StorageConfig struct {
+	Backend         string        `json:"backend"` // local 或 s3
+	S3Endpoint      string        `json:"s3_endpoint"`
+	S3Region        string        `json:"s3_region"`
+	S3Bucket        string        `json:"s3_bucket"`
+	S3AccessKey     string        `json:"-"`
+	S3SecretKey     string        `json:"-"`
+	S3UseSSL        bool          `json:"s3_use_ssl"`
+	SignedURLExpiry time.Duration `json:"signed_url_expiry"`
+}
+
+// String 打印配置时隐藏密钥
+func (c StorageConfig) String() string {
+	return fmt.Sprintf("{Backend:%s S3Endpoint:%s S3Region:%s S3Bucket:%s S3UseSSL:%t SignedURLExpiry:%v}",
+		c.Backend, c.S3Endpoint, c.S3Region, c.S3Bucket, c.S3UseSSL, c.SignedURLExpiry)
+}
+
+// LogValue 记录日志时按配置项分组输出，各配置项的密钥（S3密钥、API key、JWT密钥）均被隐藏
+func (c *Config) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Any("server", c.Server),
+		slog.Any("milvus", c.Milvus),
+		slog.String("storage", c.Storage.String()),
+		slog.Any("timeouts", c.Timeouts),
+		slog.Any("tracing", c.Tracing),
+		slog.Any("logging", c.Logging),
+		slog.Any("auth", c.Auth),
+		slog.Any("rate_limit", c.RateLimit),
+		slog.Any("extraction", c.Extraction),
+	)
 }
 
 // LoadConfig 加载配置，从环境变量或使用默认值
 func LoadConfig() *Config {
+	uploadTimeout := getEnvAsDuration("UPLOAD_TIMEOUT", 30*time.Second)
 	return &Config{
 		Server: ServerConfig{
-			Port:        getEnv("SERVER_PORT", "8888"),
-			Host:        getEnv("SERVER_HOST", "0.0.0.0"),
-			UploadPath:  getEnv("UPLOAD_PATH", "./uploads"),
-			MaxFileSize: getEnvAsInt64("MAX_FILE_SIZE", 10*1024*1024), // 10MB
+			Port:             getEnv("SERVER_PORT", "8888"),
+			Host:             getEnv("SERVER_HOST", "0.0.0.0"),
+			UploadPath:       getEnv("UPLOAD_PATH", "./uploads"),
+			MaxFileSize:      getEnvAsInt64("MAX_FILE_SIZE", 10*1024*1024), // 10MB
+			StripExif:        getEnvAsBool("STRIP_EXIF", false),
+			MetadataPath:     getEnv("METADATA_PATH", "./data/metadata.db"),
+			IdempotencyTTL:   getEnvAsDuration("IDEMPOTENCY_TTL", 24*time.Hour),
+			IdempotencyLease: getEnvAsDuration("IDEMPOTENCY_LEASE", 3*uploadTimeout),
+			MinFreeDisk:      getEnvAsInt64("MIN_FREE_DISK", 100*1024*1024), // 100MB
 		},
 		Milvus: MilvusConfig{
-			Host:           getEnv("MILVUS_HOST", "localhost"),
-			Port:           getEnv("MILVUS_PORT", "19530"),
-			CollectionName: getEnv("MILVUS_COLLECTION", "image_vectors"),
-			Dimension:      getEnvAsInt("MILVUS_DIMENSION", 512), // ResNet特征维度
-			IndexType:      getEnv("MILVUS_INDEX_TYPE", "IVF_FLAT"),
-			MetricType:     getEnv("MILVUS_METRIC_TYPE", "L2"),
+			Host:                   getEnv("MILVUS_HOST", "localhost"),
+			Port:                   getEnv("MILVUS_PORT", "19530"),
+			CollectionName:         getEnv("MILVUS_COLLECTION", "image_vectors"),
+			Dimension:              getEnvAsInt("MILVUS_DIMENSION", 512), // ResNet特征维度
+			IndexType:              getEnv("MILVUS_INDEX_TYPE", "IVF_FLAT"),
+			MetricType:             getEnv("MILVUS_METRIC_TYPE", "L2"),
+			PartitionBy:            getEnv("MILVUS_PARTITION_BY", ""),
+			MaxLoadedPartitions:    getEnvAsInt("MILVUS_MAX_LOADED_PARTITIONS", 0),
+			InsertBufferSize:       getEnvAsInt("MILVUS_INSERT_BUFFER_SIZE", 100),
+			InsertFlushInterval:    getEnvAsDuration("MILVUS_INSERT_FLUSH_INTERVAL", time.Second),
+			InsertBufferMaxPending: getEnvAsInt("MILVUS_INSERT_BUFFER_MAX_PENDING", 1000),
+			RetryAttempts:          getEnvAsInt("MILVUS_RETRY_ATTEMPTS", 3),
+			RetryBackoff:           getEnvAsDuration("MILVUS_RETRY_BACKOFF", 200*time.Millisecond),
+			MaxBackoff:             getEnvAsDuration("MILVUS_MAX_BACKOFF", 30*time.Second),
+			BreakerThreshold:       getEnvAsInt("MILVUS_BREAKER_THRESHOLD", 5),
+			BreakerCooldown:        getEnvAsDuration("MILVUS_BREAKER_COOLDOWN", 10*time.Second),
+		},
+		Storage: StorageConfig{
+			Backend:         getEnv("STORAGE_BACKEND", "local"),
+			S3Endpoint:      getEnv("S3_ENDPOINT", "localhost:9000"),
+			S3Region:        getEnv("S3_REGION", "us-east-1"),
+			S3Bucket:        getEnv("S3_BUCKET", "image-search"),
+			S3AccessKey:     getEnv("S3_ACCESS_KEY", "minioadmin"),
+			S3SecretKey:     getEnv("S3_SECRET_KEY", "minioadmin"),
+			S3UseSSL:        getEnvAsBool("S3_USE_SSL", false),
+			SignedURLExpiry: getEnvAsDuration("SIGNED_URL_EXPIRY", 15*time.Minute),
+		},
+		Timeouts: TimeoutConfig{
+			Search: getEnvAsDuration("SEARCH_TIMEOUT", 10*time.Second),
+			Upload: uploadTimeout,
+			Delete: getEnvAsDuration("DELETE_TIMEOUT", 10*time.Second),
+			Stats:  getEnvAsDuration("STATS_TIMEOUT", 5*time.Second),
+			Admin:  getEnvAsDuration("ADMIN_TIMEOUT", 2*time.Minute),
+		},
+		Extraction: ExtractionConfig{
+			Workers:      getEnvAsInt("EXTRACT_WORKERS", runtime.NumCPU()),
+			QueueSize:    getEnvAsInt("EXTRACT_QUEUE_SIZE", 4*runtime.NumCPU()),
+			QueueTimeout: getEnvAsDuration("EXTRACT_QUEUE_TIMEOUT", 5*time.Second),
+			MaxImages:    getEnvAsInt("EXTRACT_MAX_IMAGES", 2*runtime.NumCPU()),
+		},
+		RateLimit: RateLimitConfig{
+			Search:      RateLimit{Rate: getEnvAsFloat("SEARCH_RATE_LIMIT", 10), Burst: getEnvAsInt("SEARCH_RATE_BURST", 20)},
+			Upload:      RateLimit{Rate: getEnvAsFloat("UPLOAD_RATE_LIMIT", 5), Burst: getEnvAsInt("UPLOAD_RATE_BURST", 20)},
+			Delete:      RateLimit{Rate: getEnvAsFloat("DELETE_RATE_LIMIT", 10), Burst: getEnvAsInt("DELETE_RATE_BURST", 20)},
+			DailyImages: getEnvAsInt64("DAILY_UPLOAD_IMAGES", 0),
+			DailyBytes:  getEnvAsInt64("DAILY_UPLOAD_BYTES", 0),
+		},
+		Auth: AuthConfig{
+			Enabled:          getEnvAsBool("AUTH_ENABLED", false),
+			APIKeys:          getEnv("API_KEYS", ""),
+			JWTSecret:        getEnv("JWT_HMAC_SECRET", ""),
+			JWTPublicKeyFile: getEnv("JWT_PUBLIC_KEY_FILE", ""),
+			JWTIssuer:        getEnv("JWT_ISSUER", ""),
+			JWTAudience:      getEnv("JWT_AUDIENCE", ""),
+			CORSOrigins:      getEnv("CORS_ALLOWED_ORIGINS", ""),
+		},
+		Logging: LoggingConfig{
+			Level:  getEnv("LOG_LEVEL", "info"),
+			Format: getEnv("LOG_FORMAT", "json"),
+		},
+		Tracing: TracingConfig{
+			Exporter:    getEnv("TRACING_EXPORTER", ""),
+			ServiceName: getEnv("OTEL_SERVICE_NAME", "image-search"),
+			SampleRatio: getEnvAsFloat("TRACING_SAMPLE_RATIO", 1),
 		},
 	}
 }
@@ -76,3 +301,33 @@ func getEnvAsInt64(key string, defaultValue int64) int64 {
 	}
 	return defaultValue
 }
+
+// getEnvAsBool 获取环境变量并转换为布尔值
+func getEnvAsBool(key string, defaultValue bool) bool {
+	if value, exists := os.LookupEnv(key); exists {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
+
+// getEnvAsFloat 获取环境变量并转换为浮点数
+func getEnvAsFloat(key string, defaultValue float64) float64 {
+	if value, exists := os.LookupEnv(key); exists {
+		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatValue
+		}
+	}
+	return defaultValue
+}
+
+// getEnvAsDuration 获取环境变量并解析为时间间隔（如 "15m"）
+func getEnvAsDuration(key string, defaultValue time.Duration) time.Duration {
+	if value, exists := os.LookupEnv(key); exists {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+	}
+	return defaultValue
+}
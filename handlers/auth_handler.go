@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"image-search-go/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AuthHandler API key管理处理器
+type AuthHandler struct {
+	auth *auth.Authenticator
+}
+
+// CreateKeyRequest 创建API key请求
+type CreateKeyRequest struct {
+	Name   string   `json:"name" binding:"required"`
+	Scopes []string `json:"scopes" binding:"required"`
+	// Namespaces 可以访问的命名空间，* 表示全部；为空时只能访问默认命名空间
+	Namespaces []string `json:"namespaces"`
+}
+
+// NewAuthHandler 创建API key管理处理器
+func NewAuthHandler(authenticator *auth.Authenticator) *AuthHandler {
+	return &AuthHandler{auth: authenticator}
+}
+
+// ListKeys 列出API key（不含key本身）
+func (h *AuthHandler) ListKeys(c *gin.Context) {
+	keys := h.auth.Keys()
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"keys":    keys,
+		"total":   len(keys),
+	})
+}
+
+// CreateKey 生成API key，key只在响应中返回一次
+func (h *AuthHandler) CreateKey(c *gin.Context) {
+	var req CreateKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": fmt.Sprintf("无效的请求参数: %v", err),
+		})
+		return
+	}
+
+	createdBy := auth.PrincipalFrom(c).Identity()
+	info, secret, err := h.auth.CreateKey(req.Name, req.Scopes, req.Namespaces, createdBy)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, auth.ErrKeyExists) {
+			status = http.StatusConflict
+		} else if !errors.Is(err, auth.ErrInvalidScope) && !errors.Is(err, auth.ErrInvalidNamespace) {
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	slog.InfoContext(c.Request.Context(), "API key已创建", "name", info.Name, "scopes", info.Scopes, "namespaces", info.Namespaces, "created_by", createdBy)
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"message": "API key创建成功，请妥善保存，key不会再次返回",
+		"key":     info,
+		"secret":  secret,
+	})
+}
+
+// DeleteKey 吊销API key
+func (h *AuthHandler) DeleteKey(c *gin.Context) {
+	name := c.Param("name")
+	if err := h.auth.DeleteKey(name); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, auth.ErrKeyNotFound) {
+			status = http.StatusNotFound
+		} else if errors.Is(err, auth.ErrConfigKey) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	slog.InfoContext(c.Request.Context(), "API key已吊销", "name", name, "revoked_by", auth.PrincipalFrom(c).Identity())
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "API key已吊销",
+	})
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"image-search-go/auth"
+	"image-search-go/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// corsAllowedHeaders 跨域请求允许携带的请求头
+var corsAllowedHeaders = strings.Join([]string{
+	"Origin", "Authorization", "Content-Type", "Idempotency-Key",
+	TenantHeader, auth.APIKeyHeader, logging.RequestIDHeader,
+}, ", ")
+
+// CORSMiddleware 按配置的来源列表（逗号分隔，* 表示任意来源）设置跨域响应头并响应预检请求。
+// 列表为空时不允许任何跨域访问，不在列表中的来源不返回跨域响应头
+func CORSMiddleware(origins string) gin.HandlerFunc {
+	allowed := map[string]bool{}
+	for _, origin := range strings.Split(origins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			allowed[origin] = true
+		}
+	}
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		allowOrigin := ""
+		switch {
+		case allowed["*"]:
+			allowOrigin = "*"
+		case origin != "" && allowed[origin]:
+			allowOrigin = origin
+			c.Header("Vary", "Origin")
+		}
+		if allowOrigin != "" {
+			c.Header("Access-Control-Allow-Origin", allowOrigin)
+			c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+			c.Header("Access-Control-Allow-Headers", corsAllowedHeaders)
+			c.Header("Access-Control-Expose-Headers", logging.RequestIDHeader+", Retry-After")
+		}
+
+		if c.Request.Method == "OPTIONS" {
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"image-search-go/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Livez 存活检查：进程能处理请求即返回200。不检查Milvus等依赖，依赖不可用时重启进程无济于事
+func (h *ImageHandler) Livez(c *gin.Context) {
+	c.JSON(http.StatusOK, &services.HealthReport{Status: services.CheckOK, Checks: []*services.CheckResult{}})
+}
+
+// Readyz 就绪检查：向量存储已初始化、Milvus可以访问、collection已加载、上传目录可写且特征提取正常时返回200，否则返回503
+func (h *ImageHandler) Readyz(c *gin.Context) {
+	ctx, cancel := operationContext(c, h.config.Timeouts.Stats)
+	defer cancel()
+
+	report := h.health.Run(ctx, services.ReadinessChecks...)
+	c.JSON(healthStatus(report), report)
+}
+
+// HealthCheck 健康检查API，verbose=1时返回各检查项的状态、耗时和详细信息
+func (h *ImageHandler) HealthCheck(c *gin.Context) {
+	ctx, cancel := operationContext(c, h.config.Timeouts.Stats)
+	defer cancel()
+
+	report := h.health.Run(ctx, services.ReadinessChecks...)
+	response := gin.H{
+		"success":   report.OK(),
+		"message":   "服务正常",
+		"status":    report.Status,
+		"timestamp": time.Now().Unix(),
+	}
+	if !report.OK() {
+		response["message"] = "服务不可用: " + firstFailure(report)
+	}
+	if verbose, _ := strconv.ParseBool(c.Query("verbose")); verbose {
+		response["checks"] = report.Checks
+	}
+	c.JSON(healthStatus(report), response)
+}
+
+// healthStatus 检查全部通过时返回200，否则返回503
+func healthStatus(report *services.HealthReport) int {
+	if report.OK() {
+		return http.StatusOK
+	}
+	return http.StatusServiceUnavailable
+}
+
+// firstFailure 第一个失败检查项的说明
+func firstFailure(report *services.HealthReport) string {
+	for _, result := range report.Checks {
+		if result.Status == services.CheckFailed {
+			return result.Name + ": " + result.Message
+		}
+	}
+	return ""
+}
@@ -1,50 +1,71 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"mime/multipart"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/google/uuid"
-
+	"image-search-go/auth"
 	"image-search-go/config"
-	"image-search-go/models"
+	"image-search-go/metrics"
+	"image-search-go/ratelimit"
 	"image-search-go/services"
+	"image-search-go/storage"
+	"image-search-go/tracing"
 	"image-search-go/utils"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // ImageHandler 图像处理器
 type ImageHandler struct {
-	milvusService    *services.MilvusService
-	featureExtractor models.FeatureExtractor
-	config           *config.Config
+	backend       *services.Backend
+	health        *services.HealthChecker
+	dailyQuota    *services.DailyQuota
+	blobStore     storage.BlobStore
+	metadataStore *services.MetadataStore
+	textIndex     *services.TextIndex
+	config        *config.Config
 }
 
 // NewImageHandler 创建图像处理器
-func NewImageHandler(milvusService *services.MilvusService, featureExtractor models.FeatureExtractor, cfg *config.Config) *ImageHandler {
+func NewImageHandler(backend *services.Backend, blobStore storage.BlobStore, metadataStore *services.MetadataStore,
+	textIndex *services.TextIndex, cfg *config.Config) *ImageHandler {
 	return &ImageHandler{
-		milvusService:    milvusService,
-		featureExtractor: featureExtractor,
-		config:           cfg,
+		backend:       backend,
+		health:        services.NewHealthChecker(backend, cfg),
+		dailyQuota:    services.NewDailyQuota(metadataStore, &cfg.RateLimit),
+		blobStore:     blobStore,
+		metadataStore: metadataStore,
+		textIndex:     textIndex,
+		config:        cfg,
 	}
 }
 
 // UploadImageRequest 上传图像请求
 type UploadImageRequest struct {
 	Description string `form:"description"`
+	Tags        string `form:"tags"` // 逗号分隔
+	Category    string `form:"category"`
 }
 
 // UploadImageResponse 上传图像响应
 type UploadImageResponse struct {
-	Success   bool   `json:"success"`
-	Message   string `json:"message"`
-	ImageID   string `json:"image_id,omitempty"`
-	ImagePath string `json:"image_path,omitempty"`
+	Success   bool                    `json:"success"`
+	Message   string                  `json:"message"`
+	ImageID   string                  `json:"image_id,omitempty"`
+	ImagePath string                  `json:"image_path,omitempty"`
+	ImageInfo *utils.ImageInfo        `json:"image_info,omitempty"`
+	Metadata  *services.ImageMetadata `json:"metadata,omitempty"`
 }
 
 // SearchImageResponse 搜索图像响应
@@ -57,11 +78,19 @@ type SearchImageResponse struct {
 
 // SearchResultWithDetails 带详细信息的搜索结果
 type SearchResultWithDetails struct {
-	ImageID    string  `json:"image_id"`
-	Score      float32 `json:"score"`
-	Distance   float32 `json:"distance"`
-	ImagePath  string  `json:"image_path"`
-	Similarity string  `json:"similarity"`
+	ImageID     string  `json:"image_id"`
+	Score       float32 `json:"score"`
+	Distance    float32 `json:"distance"`
+	FusionScore float32 `json:"fusion_score,omitempty"`
+	// ScoreBreakdown 混合搜索的分数构成
+	ScoreBreakdown *services.ScoreBreakdown `json:"score_breakdown,omitempty"`
+	ImagePath      string                   `json:"image_path"`
+	ImageURL       string                   `json:"image_url,omitempty"`
+	MimeType       string                   `json:"mime_type,omitempty"`
+	FileSize       int64                    `json:"file_size,omitempty"`
+	FileMissing    bool                     `json:"file_missing,omitempty"`
+	Similarity     string                   `json:"similarity"`
+	Metadata       *services.ImageMetadata  `json:"metadata,omitempty"`
 }
 
 // StatsResponse 统计信息响应
@@ -74,6 +103,15 @@ type StatsResponse struct {
 
 // UploadImage 上传图像API
 func (h *ImageHandler) UploadImage(c *gin.Context) {
+	var req UploadImageRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, UploadImageResponse{
+			Success: false,
+			Message: fmt.Sprintf("无效的请求参数: %v", err),
+		})
+		return
+	}
+
 	// 获取上传的文件
 	file, err := c.FormFile("image")
 	if err != nil {
@@ -102,58 +140,131 @@ func (h *ImageHandler) UploadImage(c *gin.Context) {
 		return
 	}
 
-	// 生成唯一的文件ID
-	imageID := uuid.New().String()
-	ext := filepath.Ext(file.Filename)
-	filename := imageID + ext
-	filePath := filepath.Join(h.config.Server.UploadPath, filename)
+	ctx, cancel := operationContext(c, h.config.Timeouts.Upload)
+	defer cancel()
 
-	// 保存文件
-	if err := utils.SaveUploadedFile(file, filePath); err != nil {
-		c.JSON(http.StatusInternalServerError, UploadImageResponse{
+	// 读入内存和解码前占用图像名额，上传流程结束后归还
+	releaseImage, err := h.namespaces().AdmitImage(ctx)
+	if err != nil {
+		setRetryAfter(c, err)
+		c.JSON(errorStatus(ctx, err, http.StatusInternalServerError), UploadImageResponse{
 			Success: false,
-			Message: fmt.Sprintf("保存文件失败: %v", err),
+			Message: err.Error(),
 		})
 		return
 	}
+	defer releaseImage()
 
-	// 加载图像进行特征提取
-	img, err := utils.LoadImageFromFile(filePath)
+	// 读取上传内容
+	_, span := tracing.Start(ctx, "upload.read", attribute.Int64("image.size", file.Size))
+	data, err := utils.ReadMultipartFile(file)
+	tracing.End(span, err)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, UploadImageResponse{
+		c.JSON(http.StatusBadRequest, UploadImageResponse{
 			Success: false,
-			Message: fmt.Sprintf("加载图像失败: %v", err),
+			Message: fmt.Sprintf("读取上传文件失败: %v", err),
 		})
 		return
 	}
 
-	// 提取特征
-	features, err := h.featureExtractor.ExtractFeatures(img)
+	// 按请求的一致性级别写入向量（strong/session时立即写入，不经过写入缓冲）
+	tenant := h.tenant(c)
+	milvusService, err := tenant.Milvus.WithConsistency(c.Query("consistency"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, UploadImageResponse{
+		c.JSON(http.StatusBadRequest, UploadImageResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	// 检查命名空间配额
+	if err := tenant.CheckQuota(ctx); err != nil {
+		c.JSON(uploadErrorStatus(ctx, err), UploadImageResponse{
 			Success: false,
-			Message: fmt.Sprintf("特征提取失败: %v", err),
+			Message: err.Error(),
 		})
 		return
 	}
 
-	// 插入到Milvus
-	if err := h.milvusService.InsertVectors([]string{imageID}, [][]float32{features}); err != nil {
+	// 预占客户端今日的上传配额，上传失败或幂等重放时归还
+	charge, release, err := h.dailyQuota.Reserve(ratelimit.ClientKey(c), int64(len(data)))
+	if err != nil {
+		if errors.Is(err, services.ErrDailyQuotaExceeded) {
+			metrics.IncRateLimited(ratelimit.OpUpload, "daily_quota")
+			ratelimit.AbortTooManyRequests(c, h.dailyQuota.ResetAfter(), err.Error())
+			return
+		}
 		c.JSON(http.StatusInternalServerError, UploadImageResponse{
 			Success: false,
-			Message: fmt.Sprintf("向量存储失败: %v", err),
+			Message: err.Error(),
+		})
+		return
+	}
+
+	// 执行上传流程（存储文件、提取特征、写入元数据和向量，失败时自动回滚）
+	result, err := tenant.Pipeline.WithVectors(milvusService).Run(ctx, &services.UploadInput{
+		IdempotencyKey: c.GetHeader("Idempotency-Key"),
+		Filename:       file.Filename,
+		Data:           data,
+		Description:    req.Description,
+		Tags:           parseTags(req.Tags),
+		Category:       strings.TrimSpace(req.Category),
+		ClientIP:       c.ClientIP(),
+		UploadedBy:     auth.PrincipalFrom(c).Identity(),
+		QuotaCharge:    charge,
+	})
+	if err != nil {
+		release()
+		setRetryAfter(c, err)
+		c.JSON(uploadErrorStatus(ctx, err), UploadImageResponse{
+			Success: false,
+			Message: err.Error(),
 		})
 		return
 	}
 
+	if result.Replayed {
+		release()
+		c.Header("Idempotent-Replayed", "true")
+	}
 	c.JSON(http.StatusOK, UploadImageResponse{
 		Success:   true,
 		Message:   "图像上传成功",
-		ImageID:   imageID,
-		ImagePath: filename,
+		ImageID:   result.ImageID,
+		ImagePath: result.ObjectKey,
+		ImageInfo: result.ImageInfo,
+		Metadata:  result.Metadata,
 	})
 }
 
+// uploadErrorStatus 根据上传流程错误确定HTTP状态码
+func uploadErrorStatus(ctx context.Context, err error) int {
+	if errors.Is(err, services.ErrUploadInProgress) {
+		return http.StatusConflict
+	}
+	if errors.Is(err, services.ErrQuotaExceeded) {
+		return http.StatusForbidden
+	}
+
+	var stageErr *services.StageError
+	if errors.As(err, &stageErr) && stageErr.Stage == services.StageInspect {
+		return http.StatusBadRequest
+	}
+	return errorStatus(ctx, err, http.StatusInternalServerError)
+}
+
+// parseTags 解析逗号分隔的标签列表
+func parseTags(raw string) []string {
+	var tags []string
+	for _, tag := range strings.Split(raw, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
 // SearchImage 搜索相似图像API
 func (h *ImageHandler) SearchImage(c *gin.Context) {
 	// 获取查询参数
@@ -166,72 +277,71 @@ func (h *ImageHandler) SearchImage(c *gin.Context) {
 		})
 		return
 	}
+	tracing.SetAttributes(c.Request.Context(), attribute.Int("search.top_k", topK))
 
-	// 获取上传的查询图像
-	file, err := c.FormFile("image")
-	if err != nil {
+	// 最低相似度阈值，[0,1]区间
+	minSimilarity, err := strconv.ParseFloat(c.DefaultQuery("min_similarity", "0"), 32)
+	if err != nil || minSimilarity < 0 || minSimilarity > 1 {
 		c.JSON(http.StatusBadRequest, SearchImageResponse{
 			Success: false,
-			Message: "没有找到查询图像文件",
+			Message: "无效的min_similarity参数 (0-1)",
 		})
 		return
 	}
 
-	// 检查文件格式
-	if !utils.IsValidImageFormat(file.Filename) {
+	// 获取上传的查询图像
+	file, err := c.FormFile("image")
+	if err != nil {
 		c.JSON(http.StatusBadRequest, SearchImageResponse{
 			Success: false,
-			Message: "不支持的图像格式",
+			Message: "没有找到查询图像文件",
 		})
 		return
 	}
 
-	// 加载查询图像
-	img, err := utils.LoadImageFromMultipart(file)
+	ctx, cancel := operationContext(c, h.config.Timeouts.Search)
+	defer cancel()
+
+	// 提取查询图像特征
+	tenant := h.tenant(c)
+	queryFeatures, status, err := h.queryFeatures(ctx, tenant, file)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, SearchImageResponse{
+		setRetryAfter(c, err)
+		c.JSON(status, SearchImageResponse{
 			Success: false,
-			Message: fmt.Sprintf("加载查询图像失败: %v", err),
+			Message: err.Error(),
 		})
 		return
 	}
 
-	// 提取查询图像特征
-	queryFeatures, err := h.featureExtractor.ExtractFeatures(img)
+	// 在Milvus中搜索相似向量
+	searchService, err := h.searchService(ctx, c, tenant)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, SearchImageResponse{
+		c.JSON(errorStatus(ctx, err, http.StatusBadRequest), SearchImageResponse{
 			Success: false,
-			Message: fmt.Sprintf("查询图像特征提取失败: %v", err),
+			Message: err.Error(),
 		})
 		return
 	}
-
-	// 在Milvus中搜索相似向量
-	searchResults, err := h.milvusService.SearchSimilar(queryFeatures, topK)
+	vectorCtx, span := tracing.Start(ctx, "search.vector", attribute.Int("search.top_k", topK))
+	searchResults, err := searchService.SearchSimilar(vectorCtx, queryFeatures, topK)
+	tracing.End(span, err)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, SearchImageResponse{
+		c.JSON(errorStatus(ctx, err, http.StatusInternalServerError), SearchImageResponse{
 			Success: false,
 			Message: fmt.Sprintf("搜索失败: %v", err),
 		})
 		return
 	}
 
-	// 转换搜索结果
-	var results []SearchResultWithDetails
-	for _, result := range searchResults {
-		similarity := h.calculateSimilarity(result.Distance)
+	// 过滤相似度低于阈值的结果
+	searchResults = services.FilterBySimilarity(searchResults, float32(minSimilarity))
 
-		// 查找实际的文件路径
-		actualFilePath := h.findActualImageFile(result.ImageID)
-
-		results = append(results, SearchResultWithDetails{
-			ImageID:    result.ImageID,
-			Score:      result.Score,
-			Distance:   result.Distance,
-			ImagePath:  actualFilePath,
-			Similarity: similarity,
-		})
-	}
+	// 转换搜索结果
+	_, span = tracing.Start(ctx, "search.details")
+	results := h.resultsWithDetails(ctx, tenant, searchResults, verifyFiles(c))
+	span.End()
+	tracing.SetAttributes(ctx, attribute.Int("search.result_count", len(results)))
 
 	c.JSON(http.StatusOK, SearchImageResponse{
 		Success: true,
@@ -241,23 +351,174 @@ func (h *ImageHandler) SearchImage(c *gin.Context) {
 	})
 }
 
-// findActualImageFile 查找实际的图像文件路径
-func (h *ImageHandler) findActualImageFile(imageID string) string {
+// queryFeatures 加载查询图像并提取特征，失败时返回对应的HTTP状态码
+func (h *ImageHandler) queryFeatures(ctx context.Context, tenant *services.Tenant, file *multipart.FileHeader) ([]float32, int, error) {
+	// 检查文件格式
+	if !utils.IsValidImageFormat(file.Filename) {
+		return nil, http.StatusBadRequest, fmt.Errorf("不支持的图像格式")
+	}
+
+	// 解码前占用图像名额，特征提取完成后归还
+	releaseImage, err := h.namespaces().AdmitImage(ctx)
+	if err != nil {
+		return nil, errorStatus(ctx, err, http.StatusInternalServerError), fmt.Errorf("查询图像特征提取失败: %w", err)
+	}
+	defer releaseImage()
+
+	// 加载查询图像
+	_, span := tracing.Start(ctx, "search.decode", attribute.Int64("image.size", file.Size))
+	img, err := utils.LoadImageFromMultipart(file)
+	tracing.End(span, err)
+	if err != nil {
+		// 无法解码是客户端上传的数据有误，与上传接口一致返回400
+		status := http.StatusInternalServerError
+		if errors.Is(err, utils.ErrDecodeImage) {
+			status = http.StatusBadRequest
+		}
+		return nil, status, fmt.Errorf("加载查询图像失败: %w", err)
+	}
+
+	// 提取查询图像特征
+	extractCtx, span := tracing.Start(ctx, "search.extract")
+	features, err := tenant.Extractor.ExtractFeatures(extractCtx, img)
+	tracing.End(span, err)
+	if err != nil {
+		return nil, errorStatus(ctx, err, http.StatusInternalServerError), fmt.Errorf("查询图像特征提取失败: %w", err)
+	}
+	return features, http.StatusOK, nil
+}
+
+// fileCheckConcurrency verify_files=true 时同时检查文件的数量
+const fileCheckConcurrency = 8
+
+// verifyFiles 请求是否要求逐个检查结果的文件是否存在（verify_files=true）
+func verifyFiles(c *gin.Context) bool {
+	verify, _ := strconv.ParseBool(c.Query("verify_files"))
+	return verify
+}
+
+// resultsWithDetails 批量查询元数据并补充搜索结果详情。文件信息取自向量记录和元数据，不访问存储；
+// verify为true时并发检查文件是否存在，并按扩展名查找旧数据的文件
+func (h *ImageHandler) resultsWithDetails(ctx context.Context, tenant *services.Tenant, searchResults []*services.SearchResult, verify bool) []SearchResultWithDetails {
+	imageIDs := make([]string, len(searchResults))
+	for i, result := range searchResults {
+		imageIDs[i] = result.ImageID
+	}
+
+	// 一次批量查询元数据，失败时仅返回向量库中的信息
+	metas, err := h.metadataStore.GetMany(imageIDs)
+	if err != nil {
+		slog.WarnContext(ctx, "查询元数据失败", "error", err)
+	}
+
+	var results []SearchResultWithDetails
+	for _, result := range searchResults {
+		// 不返回其他命名空间的元数据
+		meta := metas[result.ImageID]
+		if meta != nil && !tenant.Owns(meta) {
+			meta = nil
+		}
+		results = append(results, h.resultWithDetails(result, meta))
+	}
+	if verify {
+		h.verifyResultFiles(ctx, tenant, results)
+	}
+
+	for i := range results {
+		if results[i].FileMissing {
+			continue
+		}
+		if url, err := h.blobStore.SignedURL(ctx, results[i].ImagePath, h.config.Storage.SignedURLExpiry); err == nil {
+			results[i].ImageURL = url
+		}
+	}
+	return results
+}
+
+// resultWithDetails 用向量记录和元数据中的文件信息补充搜索结果，没有记录文件key（旧数据）时标记为文件缺失
+func (h *ImageHandler) resultWithDetails(result *services.SearchResult, meta *services.ImageMetadata) SearchResultWithDetails {
+	if result.ObjectKey == "" && meta != nil {
+		result.ObjectKey = meta.ObjectKey
+		result.MimeType = meta.MimeType
+		result.FileSize = meta.FileSize
+	}
+
+	return SearchResultWithDetails{
+		ImageID:        result.ImageID,
+		Score:          result.Score,
+		Distance:       result.Distance,
+		FusionScore:    result.FusionScore,
+		ScoreBreakdown: result.Breakdown,
+		ImagePath:      result.ObjectKey,
+		MimeType:       result.MimeType,
+		FileSize:       result.FileSize,
+		Similarity:     h.calculateSimilarity(result.Score),
+		Metadata:       meta,
+		FileMissing:    result.ObjectKey == "",
+	}
+}
+
+// verifyResultFiles 并发检查结果的文件是否存在，没有记录文件key的旧数据按扩展名查找
+func (h *ImageHandler) verifyResultFiles(ctx context.Context, tenant *services.Tenant, results []SearchResultWithDetails) {
+	sem := make(chan struct{}, fileCheckConcurrency)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(details *SearchResultWithDetails) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			if details.ImagePath == "" {
+				if key, ok := h.findImageKey(ctx, tenant, details.ImageID); ok {
+					details.ImagePath = key
+					details.MimeType = utils.ContentTypeByFilename(key)
+					details.FileMissing = false
+				}
+				return
+			}
+			if _, err := h.blobStore.Stat(ctx, details.ImagePath); errors.Is(err, storage.ErrNotFound) {
+				details.FileMissing = true
+			}
+		}(&results[i])
+	}
+	wg.Wait()
+}
+
+// validImageID 图像ID由上传时生成，为标准格式的UUID
+func validImageID(imageID string) bool {
+	_, err := uuid.Parse(imageID)
+	return err == nil && len(imageID) == 36
+}
+
+// imageKey 获取命名空间内图像文件的key，优先使用元数据和向量记录中保存的key
+func (h *ImageHandler) imageKey(ctx context.Context, tenant *services.Tenant, imageID string) (string, bool) {
+	if meta, err := h.metadataStore.Get(imageID); err == nil && tenant.Owns(meta) && meta.ObjectKey != "" {
+		return meta.ObjectKey, true
+	}
+	if record, err := tenant.Milvus.GetImage(ctx, imageID); err == nil && record != nil && record.ObjectKey != "" {
+		return record.ObjectKey, true
+	}
+	return h.findImageKey(ctx, tenant, imageID)
+}
+
+// findImageKey 按支持的扩展名查找图像在存储中的key
+func (h *ImageHandler) findImageKey(ctx context.Context, tenant *services.Tenant, imageID string) (string, bool) {
 	// 支持的图像扩展名
-	extensions := []string{".jpg", ".jpeg", ".png", ".bmp", ".tiff", ".gif"}
+	extensions := []string{".jpg", ".jpeg", ".png", ".bmp", ".tif", ".tiff", ".gif"}
 
 	for _, ext := range extensions {
-		filename := imageID + ext
-		fullPath := filepath.Join(h.config.Server.UploadPath, filename)
+		key := tenant.ObjectKeyPrefix() + imageID + ext
 
 		// 检查文件是否存在
-		if _, err := os.Stat(fullPath); err == nil {
-			return filename // 返回相对路径
+		if _, err := h.blobStore.Stat(ctx, key); err == nil {
+			return key, true
 		}
 	}
 
-	// 如果找不到文件，返回默认的jpg扩展名
-	return imageID + ".jpg"
+	return "", false
 }
 
 // DeleteImage 删除图像API
@@ -270,28 +531,101 @@ func (h *ImageHandler) DeleteImage(c *gin.Context) {
 		})
 		return
 	}
+	if !validImageID(imageID) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "图像ID格式无效",
+		})
+		return
+	}
+
+	ctx, cancel := operationContext(c, h.config.Timeouts.Delete)
+	defer cancel()
+
+	// 删除前查询文件key
+	tenant := h.tenant(c)
+	key, hasFile := h.imageKey(ctx, tenant, imageID)
 
 	// 从Milvus删除向量
-	if err := h.milvusService.DeleteVector(imageID); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
+	if err := tenant.Milvus.DeleteVector(ctx, imageID); err != nil {
+		c.JSON(errorStatus(ctx, err, http.StatusInternalServerError), gin.H{
 			"success": false,
 			"message": fmt.Sprintf("删除向量失败: %v", err),
 		})
 		return
 	}
 
+	// 删除存储中的图像文件
+	if hasFile {
+		if err := h.blobStore.Delete(ctx, key); err != nil {
+			c.JSON(errorStatus(ctx, err, http.StatusInternalServerError), gin.H{
+				"success": false,
+				"message": fmt.Sprintf("删除图像文件失败: %v", err),
+			})
+			return
+		}
+	}
+
+	// 删除元数据（只删除属于当前命名空间的记录），当天上传的图像归还每日配额用量
+	meta, err := h.metadataStore.Get(imageID)
+	if err == nil && tenant.Owns(meta) {
+		if err = h.metadataStore.Delete(imageID); err == nil {
+			h.dailyQuota.Refund(meta.QuotaCharge)
+		}
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": fmt.Sprintf("删除元数据失败: %v", err),
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "图像删除成功",
 	})
 }
 
+// ServeImage 从存储中读取图像文件API，只能读取当前命名空间的文件（其他命名空间的文件返回404）
+func (h *ImageHandler) ServeImage(c *gin.Context) {
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	if !h.tenant(c).OwnsObjectKey(key) {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	reader, info, err := h.blobStore.Get(c.Request.Context(), key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": fmt.Sprintf("读取图像失败: %v", err),
+		})
+		return
+	}
+	defer reader.Close()
+
+	contentType := info.ContentType
+	if contentType == "" || contentType == "application/octet-stream" {
+		contentType = utils.ContentTypeByFilename(key)
+	}
+	c.DataFromReader(http.StatusOK, info.Size, contentType, reader, nil)
+}
+
 // GetStats 获取统计信息API
 func (h *ImageHandler) GetStats(c *gin.Context) {
+	ctx, cancel := operationContext(c, h.config.Timeouts.Stats)
+	defer cancel()
+
 	// 获取collection统计信息
-	collectionStats, err := h.milvusService.GetCollectionStats()
+	tenant := h.tenant(c)
+	collectionStats, err := tenant.Milvus.GetCollectionStats(ctx)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, StatsResponse{
+		c.JSON(errorStatus(ctx, err, http.StatusInternalServerError), StatsResponse{
 			Success: false,
 			Message: fmt.Sprintf("获取统计信息失败: %v", err),
 		})
@@ -301,10 +635,13 @@ func (h *ImageHandler) GetStats(c *gin.Context) {
 	// 服务器信息
 	serverInfo := map[string]interface{}{
 		"version":       "1.0.0",
-		"feature_dim":   h.featureExtractor.GetDimension(),
+		"namespace":     tenant.Namespace.Name,
+		"feature_dim":   tenant.Extractor.GetDimension(),
 		"upload_path":   h.config.Server.UploadPath,
+		"storage":       h.config.Storage.Backend,
 		"max_file_size": h.config.Server.MaxFileSize,
 		"timestamp":     time.Now().Unix(),
+		"extraction":    h.namespaces().ExtractionStats(),
 	}
 
 	c.JSON(http.StatusOK, StatsResponse{
@@ -315,35 +652,37 @@ func (h *ImageHandler) GetStats(c *gin.Context) {
 	})
 }
 
-// HealthCheck 健康检查API
-func (h *ImageHandler) HealthCheck(c *gin.Context) {
-	// 检查Milvus连接
-	if err := h.milvusService.HealthCheck(); err != nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{
-			"success": false,
-			"message": fmt.Sprintf("服务不可用: %v", err),
-		})
-		return
+// operationContext 基于请求的context创建带处理时限的context，客户端断开连接时同样会取消
+func operationContext(c *gin.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(c.Request.Context())
 	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"success":   true,
-		"message":   "服务正常",
-		"timestamp": time.Now().Unix(),
-	})
+	return context.WithTimeout(c.Request.Context(), timeout)
 }
 
-// calculateSimilarity 计算相似度百分比
-func (h *ImageHandler) calculateSimilarity(distance float32) string {
-	// 距离越小，相似度越高
-	// 这里使用简单的转换公式，可以根据实际情况调整
-	similarity := (1.0 - distance) * 100
-	if similarity < 0 {
-		similarity = 0
+// errorStatus 操作超过处理时限时返回504，Milvus熔断中、尚未就绪、特征提取或向量写入繁忙时返回503，否则返回status
+func errorStatus(ctx context.Context, err error, status int) int {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
 	}
-	if similarity > 100 {
-		similarity = 100
+	if errors.Is(err, services.ErrMilvusUnavailable) || errors.Is(err, services.ErrNotReady) ||
+		errors.Is(err, services.ErrExtractionOverloaded) || errors.Is(err, services.ErrInsertOverloaded) {
+		return http.StatusServiceUnavailable
 	}
+	return status
+}
+
+// extractionRetryAfter 特征提取繁忙时建议客户端重试的间隔（秒）
+const extractionRetryAfter = 1
+
+// setRetryAfter 特征提取或向量写入繁忙被拒绝时设置 Retry-After
+func setRetryAfter(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrExtractionOverloaded) || errors.Is(err, services.ErrInsertOverloaded) {
+		c.Header("Retry-After", strconv.Itoa(extractionRetryAfter))
+	}
+}
 
-	return fmt.Sprintf("%.1f%%", similarity)
+// calculateSimilarity 将[0,1]区间的相似度格式化为百分比
+func (h *ImageHandler) calculateSimilarity(similarity float32) string {
+	return fmt.Sprintf("%.1f%%", similarity*100)
 }
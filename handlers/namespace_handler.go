@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"image-search-go/auth"
+	"image-search-go/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TenantHeader 选择命名空间的请求头
+const TenantHeader = "X-Tenant-ID"
+
+// tenantContextKey gin上下文中保存当前命名空间的key
+const tenantContextKey = "tenant"
+
+// notReadyRetryAfter 向量存储尚未就绪时建议客户端重试的间隔（秒）
+const notReadyRetryAfter = 5
+
+// CreateNamespaceRequest 创建命名空间请求
+type CreateNamespaceRequest struct {
+	Name        string `json:"name" binding:"required"`
+	Extractor   string `json:"extractor"`
+	Dimension   int    `json:"dimension"`
+	IndexType   string `json:"index_type"`
+	MetricType  string `json:"metric_type"`
+	PartitionBy string `json:"partition_by"`
+	MaxImages   int64  `json:"max_images"`
+}
+
+// TenantMiddleware 解析请求的命名空间：路径参数 :namespace 优先，其次是 X-Tenant-ID 请求头，都没有时使用默认命名空间。
+// 调用方无权访问该命名空间时返回403（先于是否存在的检查，不泄露其他命名空间的存在）。需在认证中间件之后使用
+func (h *ImageHandler) TenantMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("namespace")
+		if name == "" {
+			name = c.GetHeader(TenantHeader)
+		}
+		if name == "" {
+			name = services.DefaultNamespace
+		}
+		if !auth.RequireNamespace(c, name) {
+			return
+		}
+
+		namespaces := h.namespaces()
+		if namespaces == nil {
+			abortNotReady(c)
+			return
+		}
+
+		tenant, err := namespaces.Get(name)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"success": false,
+				"message": fmt.Sprintf("命名空间 %s 不存在", name),
+			})
+			return
+		}
+
+		c.Set(tenantContextKey, tenant)
+		c.Next()
+	}
+}
+
+// tenant 返回中间件解析出的命名空间
+func (h *ImageHandler) tenant(c *gin.Context) *services.Tenant {
+	if tenant, ok := c.Get(tenantContextKey); ok {
+		return tenant.(*services.Tenant)
+	}
+	return h.namespaces().Default()
+}
+
+// namespaces 返回命名空间管理器，向量存储尚未就绪时返回nil
+func (h *ImageHandler) namespaces() *services.NamespaceManager {
+	return h.backend.Namespaces()
+}
+
+// ReadyMiddleware 向量存储尚未就绪时返回503
+func (h *ImageHandler) ReadyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !h.backend.Ready() {
+			abortNotReady(c)
+			return
+		}
+		c.Next()
+	}
+}
+
+// abortNotReady 返回503并提示客户端稍后重试
+func abortNotReady(c *gin.Context) {
+	c.Header("Retry-After", strconv.Itoa(notReadyRetryAfter))
+	c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+		"success": false,
+		"message": services.ErrNotReady.Error(),
+	})
+}
+
+// ListNamespaces 列出命名空间API
+func (h *ImageHandler) ListNamespaces(c *gin.Context) {
+	namespaces := h.namespaces().List()
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"namespaces": namespaces,
+		"total":      len(namespaces),
+	})
+}
+
+// CreateNamespace 创建命名空间API
+func (h *ImageHandler) CreateNamespace(c *gin.Context) {
+	var req CreateNamespaceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": fmt.Sprintf("无效的请求参数: %v", err),
+		})
+		return
+	}
+
+	ctx, cancel := operationContext(c, h.config.Timeouts.Admin)
+	defer cancel()
+
+	tenant, err := h.namespaces().Create(ctx, &services.Namespace{
+		Name:        req.Name,
+		Extractor:   req.Extractor,
+		Dimension:   req.Dimension,
+		IndexType:   req.IndexType,
+		MetricType:  req.MetricType,
+		PartitionBy: req.PartitionBy,
+		MaxImages:   req.MaxImages,
+	})
+	if err != nil {
+		status := errorStatus(ctx, err, http.StatusBadRequest)
+		if errors.Is(err, services.ErrNamespaceExists) {
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success":   true,
+		"message":   "命名空间创建成功",
+		"namespace": tenant.Namespace,
+	})
+}
+
+// DropNamespace 删除命名空间API：删除其collection、元数据和图像文件
+func (h *ImageHandler) DropNamespace(c *gin.Context) {
+	ctx, cancel := operationContext(c, h.config.Timeouts.Admin)
+	defer cancel()
+
+	result, err := h.namespaces().Drop(ctx, c.Param("name"))
+	if err != nil {
+		status := errorStatus(ctx, err, http.StatusInternalServerError)
+		if errors.Is(err, services.ErrNamespaceNotFound) {
+			status = http.StatusNotFound
+		} else if c.Param("name") == services.DefaultNamespace {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"message": err.Error(),
+			"result":  result,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "命名空间删除成功",
+		"result":  result,
+	})
+}
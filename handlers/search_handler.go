@@ -0,0 +1,478 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"image-search-go/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// streamChunkSize 流式输出时每批从Milvus取回并补充元数据的结果数
+const streamChunkSize = 100
+
+// RangeSearchResponse 范围搜索响应
+type RangeSearchResponse struct {
+	Success  bool                      `json:"success"`
+	Message  string                    `json:"message"`
+	Results  []SearchResultWithDetails `json:"results,omitempty"`
+	Total    int                       `json:"total"` // 本页的结果数，是否还有下一页见 HasMore
+	Page     int                       `json:"page"`
+	PageSize int                       `json:"page_size"`
+	HasMore  bool                      `json:"has_more"`
+}
+
+// RangeSearchImage 范围搜索API：返回相似度不低于阈值的全部图像，支持分页和流式输出
+func (h *ImageHandler) RangeSearchImage(c *gin.Context) {
+	// 相似度阈值，范围搜索必须指定
+	minSimilarity, err := strconv.ParseFloat(c.Query("min_similarity"), 32)
+	if err != nil || minSimilarity <= 0 || minSimilarity > 1 {
+		c.JSON(http.StatusBadRequest, RangeSearchResponse{
+			Success: false,
+			Message: "无效的min_similarity参数 (0-1]",
+		})
+		return
+	}
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page <= 0 {
+		c.JSON(http.StatusBadRequest, RangeSearchResponse{
+			Success: false,
+			Message: "无效的page参数",
+		})
+		return
+	}
+
+	pageSize, err := strconv.Atoi(c.DefaultQuery("page_size", "50"))
+	if err != nil || pageSize <= 0 || pageSize > 1000 {
+		c.JSON(http.StatusBadRequest, RangeSearchResponse{
+			Success: false,
+			Message: "无效的page_size参数 (1-1000)",
+		})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(services.MaxRangeResults)))
+	if err != nil || limit <= 0 || limit > services.MaxRangeResults {
+		c.JSON(http.StatusBadRequest, RangeSearchResponse{
+			Success: false,
+			Message: fmt.Sprintf("无效的limit参数 (1-%d)", services.MaxRangeResults),
+		})
+		return
+	}
+
+	// 获取上传的查询图像
+	file, err := c.FormFile("image")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, RangeSearchResponse{
+			Success: false,
+			Message: "没有找到查询图像文件",
+		})
+		return
+	}
+
+	ctx, cancel := operationContext(c, h.config.Timeouts.Search)
+	defer cancel()
+
+	tenant := h.tenant(c)
+	queryFeatures, status, err := h.queryFeatures(ctx, tenant, file)
+	if err != nil {
+		setRetryAfter(c, err)
+		c.JSON(status, RangeSearchResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	searchService, err := h.searchService(ctx, c, tenant)
+	if err != nil {
+		c.JSON(errorStatus(ctx, err, http.StatusBadRequest), RangeSearchResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	// 流式输出全部结果（NDJSON，每行一个结果），按批从Milvus取回并立即写出
+	if c.Query("stream") == "true" {
+		h.streamRangeResults(ctx, c, tenant, searchService, queryFeatures, float32(minSimilarity), limit)
+		return
+	}
+
+	// 分页由Milvus执行，多取一条判断是否还有下一页，页不超过limit
+	offset := (page - 1) * pageSize
+	fetch := min(pageSize+1, limit-offset)
+	var searchResults []*services.SearchResult
+	if fetch > 0 {
+		searchResults, err = searchService.RangeSearch(ctx, queryFeatures, float32(minSimilarity), offset, fetch)
+		if err != nil {
+			c.JSON(errorStatus(ctx, err, http.StatusInternalServerError), RangeSearchResponse{
+				Success: false,
+				Message: fmt.Sprintf("范围搜索失败: %v", err),
+			})
+			return
+		}
+	}
+	hasMore := len(searchResults) > pageSize
+	if hasMore {
+		searchResults = searchResults[:pageSize]
+	}
+
+	c.JSON(http.StatusOK, RangeSearchResponse{
+		Success:  true,
+		Message:  "搜索完成",
+		Results:  h.resultsWithDetails(ctx, tenant, searchResults, verifyFiles(c)),
+		Total:    len(searchResults),
+		Page:     page,
+		PageSize: pageSize,
+		HasMore:  hasMore,
+	})
+}
+
+// streamRangeResults 以NDJSON格式输出范围搜索结果：每次从Milvus取回一批，补充元数据后立即写出，
+// 直到不足一批或达到limit。开始输出后出错时以一行 {"success":false,"message":...} 结束
+func (h *ImageHandler) streamRangeResults(ctx context.Context, c *gin.Context, tenant *services.Tenant, searchService *services.MilvusService,
+	queryFeatures []float32, minSimilarity float32, limit int) {
+	encoder := json.NewEncoder(c.Writer)
+	for offset := 0; offset < limit; offset += streamChunkSize {
+		batch, err := searchService.RangeSearch(ctx, queryFeatures, minSimilarity, offset, min(streamChunkSize, limit-offset))
+		if err != nil {
+			if offset == 0 {
+				c.JSON(errorStatus(ctx, err, http.StatusInternalServerError), RangeSearchResponse{
+					Success: false,
+					Message: fmt.Sprintf("范围搜索失败: %v", err),
+				})
+				return
+			}
+			slog.WarnContext(ctx, "流式范围搜索中断", "offset", offset, "error", err)
+			encoder.Encode(gin.H{"success": false, "message": fmt.Sprintf("范围搜索失败: %v", err)})
+			return
+		}
+		if offset == 0 {
+			c.Header("Content-Type", "application/x-ndjson")
+			c.Status(http.StatusOK)
+		}
+
+		for _, result := range h.resultsWithDetails(ctx, tenant, batch, verifyFiles(c)) {
+			if err := encoder.Encode(result); err != nil {
+				// 客户端已断开
+				return
+			}
+		}
+		c.Writer.Flush()
+		if len(batch) < streamChunkSize {
+			return
+		}
+	}
+}
+
+// maxMultiQueryImages 多图查询时正例、负例各自允许的最大图像数
+const maxMultiQueryImages = 10
+
+// 多图查询模式
+const (
+	multiModeFused    = "fused"     // 融合为一个排名
+	multiModePerQuery = "per_query" // 每张查询图像各自返回结果
+)
+
+// MultiSearchResponse 多图查询响应
+type MultiSearchResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	Mode    string `json:"mode"`
+	Fusion  string `json:"fusion,omitempty"`
+	// Results 融合模式的结果
+	Results []SearchResultWithDetails `json:"results,omitempty"`
+	// ResultsPerQuery per_query模式下按查询图像顺序排列的结果
+	ResultsPerQuery [][]SearchResultWithDetails `json:"results_per_query,omitempty"`
+	Total           int                         `json:"total"`
+}
+
+// MultiSearchImage 多图查询API：上传多张参考图像（可附带负例），按查询分别返回或融合为一个排名
+func (h *ImageHandler) MultiSearchImage(c *gin.Context) {
+	mode := c.DefaultQuery("mode", multiModeFused)
+	if mode != multiModeFused && mode != multiModePerQuery {
+		c.JSON(http.StatusBadRequest, MultiSearchResponse{
+			Success: false,
+			Message: "无效的mode参数 (fused, per_query)",
+		})
+		return
+	}
+
+	fusion := c.DefaultQuery("fusion", services.FusionRRF)
+	if fusion != services.FusionAverage && fusion != services.FusionRRF && fusion != services.FusionMin {
+		c.JSON(http.StatusBadRequest, MultiSearchResponse{
+			Success: false,
+			Message: "无效的fusion参数 (average, rrf, min)",
+		})
+		return
+	}
+
+	topK, err := strconv.Atoi(c.DefaultQuery("top_k", "10"))
+	if err != nil || topK <= 0 || topK > 100 {
+		c.JSON(http.StatusBadRequest, MultiSearchResponse{
+			Success: false,
+			Message: "无效的top_k参数 (1-100)",
+		})
+		return
+	}
+
+	minSimilarity, err := strconv.ParseFloat(c.DefaultQuery("min_similarity", "0"), 32)
+	if err != nil || minSimilarity < 0 || minSimilarity > 1 {
+		c.JSON(http.StatusBadRequest, MultiSearchResponse{
+			Success: false,
+			Message: "无效的min_similarity参数 (0-1)",
+		})
+		return
+	}
+
+	negativeWeight, err := strconv.ParseFloat(c.DefaultQuery("negative_weight", "1"), 32)
+	if err != nil || negativeWeight <= 0 || negativeWeight > 10 {
+		c.JSON(http.StatusBadRequest, MultiSearchResponse{
+			Success: false,
+			Message: "无效的negative_weight参数 (0-10]",
+		})
+		return
+	}
+
+	form, err := c.MultipartForm()
+	if err != nil || len(form.File["image"]) == 0 {
+		c.JSON(http.StatusBadRequest, MultiSearchResponse{
+			Success: false,
+			Message: "没有找到查询图像文件",
+		})
+		return
+	}
+	positiveFiles, negativeFiles := form.File["image"], form.File["negative"]
+	if len(positiveFiles) > maxMultiQueryImages || len(negativeFiles) > maxMultiQueryImages {
+		c.JSON(http.StatusBadRequest, MultiSearchResponse{
+			Success: false,
+			Message: fmt.Sprintf("查询图像和负例图像各自最多 %d 张", maxMultiQueryImages),
+		})
+		return
+	}
+	if mode == multiModePerQuery && len(negativeFiles) > 0 {
+		c.JSON(http.StatusBadRequest, MultiSearchResponse{
+			Success: false,
+			Message: "per_query模式不支持负例图像",
+		})
+		return
+	}
+
+	ctx, cancel := operationContext(c, h.config.Timeouts.Search)
+	defer cancel()
+
+	// 提取全部查询图像特征
+	tenant := h.tenant(c)
+	positives, status, err := h.queryFeaturesMany(ctx, tenant, positiveFiles)
+	if err != nil {
+		setRetryAfter(c, err)
+		c.JSON(status, MultiSearchResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	negatives, status, err := h.queryFeaturesMany(ctx, tenant, negativeFiles)
+	if err != nil {
+		setRetryAfter(c, err)
+		c.JSON(status, MultiSearchResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	searchService, err := h.searchService(ctx, c, tenant)
+	if err != nil {
+		c.JSON(errorStatus(ctx, err, http.StatusBadRequest), MultiSearchResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if mode == multiModePerQuery {
+		lists, err := searchService.SearchSimilarBatch(ctx, positives, topK)
+		if err != nil {
+			c.JSON(errorStatus(ctx, err, http.StatusInternalServerError), MultiSearchResponse{
+				Success: false,
+				Message: fmt.Sprintf("搜索失败: %v", err),
+			})
+			return
+		}
+
+		response := MultiSearchResponse{
+			Success: true,
+			Message: "搜索完成",
+			Mode:    mode,
+		}
+		for _, list := range lists {
+			results := h.resultsWithDetails(ctx, tenant, services.FilterBySimilarity(list, float32(minSimilarity)), verifyFiles(c))
+			response.ResultsPerQuery = append(response.ResultsPerQuery, results)
+			response.Total += len(results)
+		}
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	searchResults, err := searchService.SearchFused(ctx, &services.FusionQuery{
+		Positives:      positives,
+		Negatives:      negatives,
+		Method:         fusion,
+		TopK:           topK,
+		NegativeWeight: float32(negativeWeight),
+	})
+	if err != nil {
+		c.JSON(errorStatus(ctx, err, http.StatusInternalServerError), MultiSearchResponse{
+			Success: false,
+			Message: fmt.Sprintf("搜索失败: %v", err),
+		})
+		return
+	}
+
+	results := h.resultsWithDetails(ctx, tenant, services.FilterBySimilarity(searchResults, float32(minSimilarity)), verifyFiles(c))
+	c.JSON(http.StatusOK, MultiSearchResponse{
+		Success: true,
+		Message: "搜索完成",
+		Mode:    mode,
+		Fusion:  fusion,
+		Results: results,
+		Total:   len(results),
+	})
+}
+
+// queryFeaturesMany 依次提取多张查询图像的特征
+func (h *ImageHandler) queryFeaturesMany(ctx context.Context, tenant *services.Tenant, files []*multipart.FileHeader) ([][]float32, int, error) {
+	features := make([][]float32, 0, len(files))
+	for _, file := range files {
+		vector, status, err := h.queryFeatures(ctx, tenant, file)
+		if err != nil {
+			return nil, status, fmt.Errorf("%s: %w", file.Filename, err)
+		}
+		features = append(features, vector)
+	}
+	return features, http.StatusOK, nil
+}
+
+// HybridSearchImage 混合搜索API：同时使用查询图像和文本，按加权分数排序并返回分数构成
+func (h *ImageHandler) HybridSearchImage(c *gin.Context) {
+	topK, err := strconv.Atoi(c.DefaultQuery("top_k", "10"))
+	if err != nil || topK <= 0 || topK > 100 {
+		c.JSON(http.StatusBadRequest, SearchImageResponse{
+			Success: false,
+			Message: "无效的top_k参数 (1-100)",
+		})
+		return
+	}
+
+	vectorWeight, err := strconv.ParseFloat(c.DefaultQuery("vector_weight", strconv.FormatFloat(services.DefaultVectorWeight, 'f', -1, 64)), 32)
+	if err != nil || vectorWeight < 0 || vectorWeight > 1 {
+		c.JSON(http.StatusBadRequest, SearchImageResponse{
+			Success: false,
+			Message: "无效的vector_weight参数 (0-1)",
+		})
+		return
+	}
+
+	textWeight, err := strconv.ParseFloat(c.DefaultQuery("text_weight", strconv.FormatFloat(services.DefaultTextWeight, 'f', -1, 64)), 32)
+	if err != nil || textWeight < 0 || textWeight > 1 || vectorWeight+textWeight == 0 {
+		c.JSON(http.StatusBadRequest, SearchImageResponse{
+			Success: false,
+			Message: "无效的text_weight参数 (0-1，且与vector_weight不能同时为0)",
+		})
+		return
+	}
+
+	text := strings.TrimSpace(c.PostForm("text"))
+	if text == "" {
+		c.JSON(http.StatusBadRequest, SearchImageResponse{
+			Success: false,
+			Message: "查询文本不能为空",
+		})
+		return
+	}
+
+	file, err := c.FormFile("image")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, SearchImageResponse{
+			Success: false,
+			Message: "没有找到查询图像文件",
+		})
+		return
+	}
+
+	ctx, cancel := operationContext(c, h.config.Timeouts.Search)
+	defer cancel()
+
+	tenant := h.tenant(c)
+	queryFeatures, status, err := h.queryFeatures(ctx, tenant, file)
+	if err != nil {
+		setRetryAfter(c, err)
+		c.JSON(status, SearchImageResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	searchService, err := h.searchService(ctx, c, tenant)
+	if err != nil {
+		c.JSON(errorStatus(ctx, err, http.StatusBadRequest), SearchImageResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	searchResults, err := services.HybridSearch(ctx, searchService, h.textIndex, &services.HybridQuery{
+		Namespace:    tenant.Namespace.Name,
+		Vector:       queryFeatures,
+		Text:         text,
+		TopK:         topK,
+		VectorWeight: float32(vectorWeight),
+		TextWeight:   float32(textWeight),
+	})
+	if err != nil {
+		c.JSON(errorStatus(ctx, err, http.StatusInternalServerError), SearchImageResponse{
+			Success: false,
+			Message: fmt.Sprintf("混合搜索失败: %v", err),
+		})
+		return
+	}
+
+	results := h.resultsWithDetails(ctx, tenant, searchResults, verifyFiles(c))
+	c.JSON(http.StatusOK, SearchImageResponse{
+		Success: true,
+		Message: "搜索完成",
+		Results: results,
+		Total:   len(results),
+	})
+}
+
+// searchService 按请求中的分区过滤条件（category、month，month可用逗号分隔多个）和一致性级别（consistency）返回搜索使用的服务实例
+func (h *ImageHandler) searchService(ctx context.Context, c *gin.Context, tenant *services.Tenant) (*services.MilvusService, error) {
+	filter := services.PartitionFilter{Category: strings.TrimSpace(c.Query("category"))}
+	for _, month := range strings.Split(c.Query("month"), ",") {
+		if month = strings.TrimSpace(month); month != "" {
+			filter.Months = append(filter.Months, month)
+		}
+	}
+	if filter.Category != "" && len(filter.Months) > 0 {
+		return nil, fmt.Errorf("category和month不能同时指定")
+	}
+
+	milvusService, err := tenant.Milvus.WithConsistency(c.Query("consistency"))
+	if err != nil {
+		return nil, err
+	}
+	return milvusService.WithPartitionFilter(ctx, filter)
+}
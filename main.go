@@ -1,14 +1,25 @@
 package main
 
 import (
+	"context"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"image-search-go/auth"
+	"image-search-go/commands"
 	"image-search-go/config"
 	"image-search-go/handlers"
-	"image-search-go/models"
+	"image-search-go/logging"
+	"image-search-go/metrics"
+	"image-search-go/ratelimit"
 	"image-search-go/services"
+	"image-search-go/storage"
+	"image-search-go/tracing"
 
 	"github.com/gin-gonic/gin"
 )
@@ -16,26 +27,68 @@ import (
 func main() {
 	// 加载配置
 	cfg := config.LoadConfig()
-	log.Printf("配置加载完成: %+v", cfg)
 
-	// 确保上传目录存在
-	if err := os.MkdirAll(cfg.Server.UploadPath, 0755); err != nil {
-		log.Fatalf("创建上传目录失败: %v", err)
+	// 子命令
+	if len(os.Args) > 1 {
+		runCommand(cfg, os.Args[1], os.Args[2:])
+		return
 	}
 
-	// 初始化特征提取器
-	featureExtractor := models.NewSimpleFeatureExtractor()
-	log.Printf("特征提取器初始化完成，维度: %d", featureExtractor.GetDimension())
+	// 初始化结构化日志（标准库log的输出同样按配置的格式输出）
+	if err := logging.Setup(&cfg.Logging); err != nil {
+		log.Fatalf("日志初始化失败: %v", err)
+	}
+	slog.Info("配置加载完成", "config", cfg)
+
+	// 初始化链路追踪（始终从请求头传播trace上下文，配置导出方式后记录span）
+	shutdownTracing, err := tracing.Setup(context.Background(), &cfg.Tracing)
+	if err != nil {
+		fatal("链路追踪初始化失败", err)
+	}
+
+	// 初始化图像文件存储（S3存储时检查bucket，限定等待时间）
+	storageCtx, cancelStorage := context.WithTimeout(context.Background(), 30*time.Second)
+	blobStore, err := storage.NewBlobStore(storageCtx, cfg)
+	cancelStorage()
+	if err != nil {
+		fatal("图像存储初始化失败", err)
+	}
+	slog.Info("图像存储初始化完成", "backend", cfg.Storage.Backend)
+
+	// 初始化元数据存储
+	metadataStore, err := services.NewMetadataStore(cfg.Server.MetadataPath)
+	if err != nil {
+		fatal("元数据存储初始化失败", err)
+	}
+	defer metadataStore.Close()
+
+	// 启动时及每小时清理过期的上传幂等记录
+	stopSweep := metadataStore.StartIdempotencySweep(cfg.Server.IdempotencyTTL, cfg.Server.IdempotencyLease, time.Hour)
+	defer stopSweep()
+
+	// 构建描述和标签的文本索引
+	textIndex, err := metadataStore.BuildTextIndex()
+	if err != nil {
+		fatal("文本索引构建失败", err)
+	}
+	slog.Info("文本索引构建完成", "images", textIndex.Len())
+
+	// 在后台连接Milvus并初始化命名空间（每个命名空间有独立的collection、特征提取器和上传流程），
+	// Milvus不可用时不退出，以降级模式启动并持续重试，就绪前依赖向量存储的请求返回503
+	backend := services.StartBackend(cfg, metadataStore, blobStore)
 
-	// 初始化Milvus服务
-	milvusService, err := services.NewMilvusService(&cfg.Milvus)
+	// 初始化认证（静态key来自配置，通过接口创建的key保存在元数据库中）
+	authenticator, err := auth.New(&cfg.Auth, metadataStore)
 	if err != nil {
-		log.Fatalf("Milvus服务初始化失败: %v", err)
+		fatal("认证初始化失败", err)
+	}
+	if !authenticator.Enabled() {
+		slog.Warn("未启用认证，所有接口均可匿名访问（设置 AUTH_ENABLED=true 启用）")
 	}
-	defer milvusService.Close()
 
 	// 初始化处理器
-	imageHandler := handlers.NewImageHandler(milvusService, featureExtractor, cfg)
+	imageHandler := handlers.NewImageHandler(backend, blobStore, metadataStore, textIndex, cfg)
+	authHandler := handlers.NewAuthHandler(authenticator)
 
 	// 设置Gin模式
 	if os.Getenv("GIN_MODE") != "debug" {
@@ -43,41 +96,56 @@ func main() {
 	}
 
 	// 创建路由器
-	router := gin.Default()
+	router := gin.New()
+	router.Use(logging.GinMiddleware(), logging.Recovery())
+	router.Use(metrics.GinMiddleware())
+	router.Use(tracing.GinMiddleware())
+	router.Use(handlers.CORSMiddleware(cfg.Auth.CORSOrigins))
 
-	// 设置静态文件服务（用于显示上传的图片）
-	router.Static("/uploads", cfg.Server.UploadPath)
+	// Prometheus指标
+	metrics.SetVectorsStored(backend.VectorCounts)
+	router.GET("/metrics", gin.WrapH(metrics.Handler()))
 
-	// 添加CORS中间件
-	router.Use(func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Origin, Authorization, Content-Type")
+	// 存活和就绪检查
+	router.GET("/livez", imageHandler.Livez)
+	router.GET("/readyz", imageHandler.Readyz)
 
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(http.StatusNoContent)
-			return
-		}
+	// 认证：先校验凭证（401），再按路由检查权限（403）
+	authenticate := authenticator.Middleware()
 
-		c.Next()
-	})
+	// 按客户端限流（上传、搜索、删除各自独立的令牌桶），超出时返回429
+	limits := ratelimit.New(&cfg.RateLimit)
+
+	// 图像文件访问（通过存储后端读取，支持多副本部署），只能读取 X-Tenant-ID 选择的命名空间的文件
+	uploads := router.Group("/uploads", authenticate, auth.Require(auth.ScopeRead), imageHandler.TenantMiddleware())
+	uploads.GET("/*key", imageHandler.ServeImage)
+	uploads.HEAD("/*key", imageHandler.ServeImage)
 
 	// API路由组
 	v1 := router.Group("/api/v1")
 	{
-		// 图像相关API
-		images := v1.Group("/images")
-		{
-			images.POST("/upload", imageHandler.UploadImage) // 上传图像
-			images.POST("/search", imageHandler.SearchImage) // 搜索相似图像
-			images.DELETE("/:id", imageHandler.DeleteImage)  // 删除图像
-		}
+		// 图像相关API，命名空间由 X-Tenant-ID 请求头或路径选择
+		registerImageRoutes(v1.Group("/images", authenticate, imageHandler.TenantMiddleware()), imageHandler, limits)
+		registerImageRoutes(v1.Group("/namespaces/:namespace/images", authenticate, imageHandler.TenantMiddleware()), imageHandler, limits)
 
 		// 系统API
 		system := v1.Group("/system")
 		{
-			system.GET("/stats", imageHandler.GetStats)     // 获取统计信息
-			system.GET("/health", imageHandler.HealthCheck) // 健康检查
+			system.GET("/stats", authenticate, auth.Require(auth.ScopeRead), imageHandler.TenantMiddleware(), imageHandler.GetStats) // 获取统计信息
+			system.GET("/health", imageHandler.HealthCheck)                                                                          // 健康检查
+		}
+
+		// 管理API，需要admin权限
+		admin := v1.Group("/admin", authenticate, auth.Require(auth.ScopeAdmin))
+		{
+			namespaces := admin.Group("/namespaces", imageHandler.ReadyMiddleware())
+			namespaces.GET("", imageHandler.ListNamespaces)         // 列出命名空间
+			namespaces.POST("", imageHandler.CreateNamespace)       // 创建命名空间
+			namespaces.DELETE("/:name", imageHandler.DropNamespace) // 删除命名空间
+
+			admin.GET("/keys", authHandler.ListKeys)           // 列出API key
+			admin.POST("/keys", authHandler.CreateKey)         // 创建API key
+			admin.DELETE("/keys/:name", authHandler.DeleteKey) // 吊销API key
 		}
 	}
 
@@ -87,12 +155,22 @@ func main() {
 			"message": "图像搜索服务",
 			"version": "1.0.0",
 			"endpoints": gin.H{
-				"upload": "POST /api/v1/images/upload",
-				"search": "POST /api/v1/images/search",
-				"delete": "DELETE /api/v1/images/:id",
-				"stats":  "GET /api/v1/system/stats",
-				"health": "GET /api/v1/system/health",
+				"upload":  "POST /api/v1/images/upload",
+				"search":  "POST /api/v1/images/search",
+				"range":   "POST /api/v1/images/search/range",
+				"multi":   "POST /api/v1/images/search/multi",
+				"hybrid":  "POST /api/v1/images/search/hybrid",
+				"delete":  "DELETE /api/v1/images/:id",
+				"stats":   "GET /api/v1/system/stats",
+				"health":  "GET /api/v1/system/health",
+				"livez":   "GET /livez",
+				"readyz":  "GET /readyz",
+				"metrics": "GET /metrics",
+				"admin":   "GET|POST /api/v1/admin/namespaces, DELETE /api/v1/admin/namespaces/:name",
+				"keys":    "GET|POST /api/v1/admin/keys, DELETE /api/v1/admin/keys/:name",
 			},
+			"namespaces": "X-Tenant-ID 请求头或 /api/v1/namespaces/:namespace/images/... 路径选择命名空间",
+			"auth":       "启用认证时通过 X-API-Key 请求头或 Authorization: Bearer <API key或JWT> 认证",
 		})
 	})
 
@@ -107,13 +185,31 @@ func main() {
 					"path":        "/api/v1/images/upload",
 					"method":      "POST",
 					"description": "上传图像并提取特征存储到向量数据库",
-					"parameters":  "image (multipart file)",
+					"parameters":  "image (multipart file), description (form), tags (form, comma separated), category (form), consistency (query parameter, strong|session|bounded|eventually)",
 				},
 				{
 					"path":        "/api/v1/images/search",
 					"method":      "POST",
 					"description": "搜索相似图像",
-					"parameters":  "image (multipart file), top_k (query parameter, default: 10)",
+					"parameters":  "image (multipart file), top_k (query parameter, default: 10), min_similarity (query parameter, 0-1, default: 0), category or month (query parameter, partition filter), consistency (query parameter), verify_files (query parameter, true 时检查文件是否存在)",
+				},
+				{
+					"path":        "/api/v1/images/search/range",
+					"method":      "POST",
+					"description": "范围搜索：返回相似度不低于阈值的全部图像",
+					"parameters":  "image (multipart file), min_similarity (query parameter, required), page, page_size, limit, stream (query parameters), verify_files (query parameter, true 时检查文件是否存在)",
+				},
+				{
+					"path":        "/api/v1/images/search/multi",
+					"method":      "POST",
+					"description": "多图查询：多张参考图像分别搜索或融合为一个排名，支持负例",
+					"parameters":  "image (multipart files), negative (multipart files), mode (fused|per_query), fusion (average|rrf|min), top_k, min_similarity, negative_weight (query parameters), verify_files (query parameter, true 时检查文件是否存在)",
+				},
+				{
+					"path":        "/api/v1/images/search/hybrid",
+					"method":      "POST",
+					"description": "混合搜索：按图像相似度和描述/标签BM25相关度的加权和排序",
+					"parameters":  "image (multipart file), text (form), top_k, vector_weight, text_weight (query parameters), verify_files (query parameter, true 时检查文件是否存在)",
 				},
 				{
 					"path":        "/api/v1/images/:id",
@@ -130,8 +226,32 @@ func main() {
 				{
 					"path":        "/api/v1/system/health",
 					"method":      "GET",
-					"description": "健康检查",
-					"parameters":  "none",
+					"description": "健康检查：向量存储、Milvus连接、collection加载进度、上传目录和特征提取器",
+					"parameters":  "verbose (query parameter, 1 返回各检查项的状态、耗时和详细信息)",
+				},
+				{
+					"path":        "/api/v1/admin/namespaces",
+					"method":      "GET, POST",
+					"description": "列出或创建命名空间（独立的collection、特征提取器、维度、索引类型和配额）",
+					"parameters":  "name, extractor, dimension, index_type, metric_type, partition_by, max_images (JSON body)",
+				},
+				{
+					"path":        "/api/v1/admin/namespaces/:name",
+					"method":      "DELETE",
+					"description": "删除命名空间及其向量、元数据和图像文件",
+					"parameters":  "name (path parameter)",
+				},
+				{
+					"path":        "/api/v1/admin/keys",
+					"method":      "GET, POST",
+					"description": "列出或创建API key（key只在创建时返回一次）",
+					"parameters":  "name, scopes (read|write|admin), namespaces (JSON body)",
+				},
+				{
+					"path":        "/api/v1/admin/keys/:name",
+					"method":      "DELETE",
+					"description": "吊销通过接口创建的API key",
+					"parameters":  "name (path parameter)",
 				},
 			},
 		})
@@ -139,11 +259,72 @@ func main() {
 
 	// 启动服务器
 	address := cfg.Server.Host + ":" + cfg.Server.Port
-	log.Printf("服务器启动在: http://%s", address)
-	log.Printf("API文档: http://%s/api", address)
-	log.Printf("上传目录: %s", cfg.Server.UploadPath)
+	slog.Info("服务器启动", "address", "http://"+address, "api_docs", "http://"+address+"/api", "upload_path", cfg.Server.UploadPath)
 
-	if err := router.Run(address); err != nil {
-		log.Fatalf("服务器启动失败: %v", err)
+	server := &http.Server{Addr: address, Handler: router}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			fatal("服务器启动失败", err)
+		}
+	}()
+
+	// 收到退出信号后停止接收请求，等待处理中的请求完成，再写入缓冲中的向量
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	slog.Info("正在关闭服务器")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Error("关闭服务器失败", "error", err)
+	}
+	backend.Close()
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error("导出剩余span失败", "error", err)
+	}
+	slog.Info("服务器已关闭")
+}
+
+// fatal 记录错误日志并退出
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// registerImageRoutes 注册图像相关API
+func registerImageRoutes(images *gin.RouterGroup, imageHandler *handlers.ImageHandler, limits *ratelimit.Limits) {
+	read, write := auth.Require(auth.ScopeRead), auth.Require(auth.ScopeWrite)
+	search := limits.Middleware(ratelimit.OpSearch)
+	images.POST("/upload", write, limits.Middleware(ratelimit.OpUpload), imageHandler.UploadImage) // 上传图像
+	images.POST("/search", read, search, imageHandler.SearchImage)                                 // 搜索相似图像
+	images.POST("/search/range", read, search, imageHandler.RangeSearchImage)                      // 范围搜索
+	images.POST("/search/multi", read, search, imageHandler.MultiSearchImage)                      // 多图查询
+	images.POST("/search/hybrid", read, search, imageHandler.HybridSearchImage)                    // 图像+文本混合搜索
+	images.DELETE("/:id", write, limits.Middleware(ratelimit.OpDelete), imageHandler.DeleteImage)  // 删除图像
+}
+
+// runCommand 执行运维子命令
+func runCommand(cfg *config.Config, name string, args []string) {
+	// 收到中断信号时取消正在进行的Milvus调用
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var err error
+	switch name {
+	case "reconcile":
+		err = commands.RunReconcile(ctx, cfg, args)
+	case "migrate":
+		err = commands.RunMigrate(ctx, cfg, args)
+	case "export":
+		err = commands.RunExport(ctx, cfg, args)
+	case "import":
+		err = commands.RunImport(ctx, cfg, args)
+	default:
+		log.Fatalf("未知的子命令: %s（可用: reconcile, migrate, export, import）", name)
+	}
+
+	if err != nil {
+		log.Fatalf("%s 执行失败: %v", name, err)
 	}
 }
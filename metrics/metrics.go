@@ -0,0 +1,240 @@
+package metrics
+
+import (
+	"context"
+	"image"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"image-search-go/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricPrefix 指标名前缀
+const metricPrefix = "image_search"
+
+// vectorsStoredTimeout 采集向量数量时查询Milvus的超时时间
+const vectorsStoredTimeout = 5 * time.Second
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricPrefix,
+		Name:      "http_requests_total",
+		Help:      "HTTP请求数，按路由、方法和状态码统计",
+	}, []string{"route", "method", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricPrefix,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP请求处理耗时",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	httpInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricPrefix,
+		Name:      "http_requests_in_flight",
+		Help:      "正在处理的HTTP请求数",
+	})
+
+	extractionDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricPrefix,
+		Name:      "feature_extraction_duration_seconds",
+		Help:      "特征提取耗时，按命名空间和结果统计",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"namespace", "status"})
+
+	milvusDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricPrefix,
+		Name:      "milvus_request_duration_seconds",
+		Help:      "Milvus调用耗时（含重试），按操作和结果统计",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation", "status"})
+
+	vectorsInserted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricPrefix,
+		Name:      "vectors_inserted_total",
+		Help:      "写入Milvus的向量数",
+	}, []string{"collection"})
+
+	searches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricPrefix,
+		Name:      "searches_total",
+		Help:      "向量搜索请求数（一次批量搜索计为一次）",
+	}, []string{"collection"})
+
+	uploadBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricPrefix,
+		Name:      "upload_bytes_total",
+		Help:      "成功上传的图像字节数",
+	}, []string{"namespace"})
+
+	extractionQueued = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricPrefix,
+		Name:      "extraction_queue_depth",
+		Help:      "等待特征提取的请求数",
+	})
+
+	extractionActive = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricPrefix,
+		Name:      "extraction_active",
+		Help:      "正在执行的特征提取数",
+	})
+
+	extractionWait = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricPrefix,
+		Name:      "extraction_queue_wait_seconds",
+		Help:      "特征提取排队等待时间（含被拒绝的请求）",
+		Buckets:   []float64{.001, .005, .01, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	})
+
+	extractionRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricPrefix,
+		Name:      "extraction_rejected_total",
+		Help:      "因特征提取或图像处理队列已满、排队超时被拒绝的请求数",
+	}, []string{"reason"})
+
+	rateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricPrefix,
+		Name:      "rate_limited_total",
+		Help:      "因限流或每日配额被拒绝的请求数",
+	}, []string{"operation", "reason"})
+)
+
+// Handler 以Prometheus文本格式输出指标
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// GinMiddleware 统计请求数、耗时和正在处理的请求数。路由使用注册时的路径模板，未匹配的请求记为unmatched
+func GinMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		httpInFlight.Inc()
+		start := time.Now()
+		defer httpInFlight.Dec()
+
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(c.Writer.Status())
+		httpRequests.WithLabelValues(route, c.Request.Method, status).Inc()
+		httpDuration.WithLabelValues(route, c.Request.Method, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// resultStatus 指标的结果标签
+func resultStatus(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// ObserveMilvus 记录一次Milvus调用的耗时
+func ObserveMilvus(operation string, start time.Time, err error) {
+	milvusDuration.WithLabelValues(operation, resultStatus(err)).Observe(time.Since(start).Seconds())
+}
+
+// AddVectorsInserted 记录写入的向量数
+func AddVectorsInserted(collection string, n int) {
+	vectorsInserted.WithLabelValues(collection).Add(float64(n))
+}
+
+// IncSearches 记录一次向量搜索
+func IncSearches(collection string) {
+	searches.WithLabelValues(collection).Inc()
+}
+
+// AddUploadBytes 记录上传的字节数
+func AddUploadBytes(namespace string, n int64) {
+	uploadBytes.WithLabelValues(namespace).Add(float64(n))
+}
+
+// IncRateLimited 记录一次被限流或超出配额的请求，reason为rate或daily_quota
+func IncRateLimited(operation, reason string) {
+	rateLimited.WithLabelValues(operation, reason).Inc()
+}
+
+// SetExtractionLoad 更新特征提取的排队数和执行数
+func SetExtractionLoad(queued, active int) {
+	extractionQueued.Set(float64(queued))
+	extractionActive.Set(float64(active))
+}
+
+// ObserveExtractionWait 记录一次特征提取的排队等待时间
+func ObserveExtractionWait(wait time.Duration) {
+	extractionWait.Observe(wait.Seconds())
+}
+
+// IncExtractionRejected 记录一次被拒绝的特征提取，reason为queue_full、queue_timeout、image_queue_full或image_queue_timeout
+func IncExtractionRejected(reason string) {
+	extractionRejected.WithLabelValues(reason).Inc()
+}
+
+// instrumentedExtractor 记录特征提取耗时的特征提取器
+type instrumentedExtractor struct {
+	models.FeatureExtractor
+	namespace string
+}
+
+// InstrumentExtractor 包装特征提取器，记录每次提取的耗时
+func InstrumentExtractor(namespace string, extractor models.FeatureExtractor) models.FeatureExtractor {
+	return &instrumentedExtractor{FeatureExtractor: extractor, namespace: namespace}
+}
+
+func (e *instrumentedExtractor) ExtractFeatures(ctx context.Context, img image.Image) ([]float32, error) {
+	start := time.Now()
+	features, err := e.FeatureExtractor.ExtractFeatures(ctx, img)
+	extractionDuration.WithLabelValues(e.namespace, resultStatus(err)).Observe(time.Since(start).Seconds())
+	return features, err
+}
+
+// vectorsStoredCollector 采集时查询各命名空间的向量数量
+type vectorsStoredCollector struct {
+	desc *prometheus.Desc
+
+	mu    sync.Mutex
+	count func(ctx context.Context) map[string]int64
+}
+
+var vectorsStored = &vectorsStoredCollector{
+	desc: prometheus.NewDesc(metricPrefix+"_vectors_stored", "各命名空间存储的向量数（含写入缓冲中的记录）", []string{"namespace"}, nil),
+}
+
+func init() {
+	prometheus.MustRegister(vectorsStored)
+}
+
+// SetVectorsStored 设置采集向量数量的函数，返回命名空间到向量数的映射（向量存储尚未就绪时返回nil）
+func SetVectorsStored(count func(ctx context.Context) map[string]int64) {
+	vectorsStored.mu.Lock()
+	defer vectorsStored.mu.Unlock()
+	vectorsStored.count = count
+}
+
+func (c *vectorsStoredCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *vectorsStoredCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	count := c.count
+	c.mu.Unlock()
+	if count == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), vectorsStoredTimeout)
+	defer cancel()
+	for name, n := range count(ctx) {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(n), name)
+	}
+}
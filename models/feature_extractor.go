@@ -1,18 +1,39 @@
 package models
 
 import (
+	"context"
 	"fmt"
 	"image"
+	"image-search-go/tracing"
 	"image-search-go/utils"
+	"log/slog"
 	"math"
+	"time"
 )
 
-// FeatureExtractor 图像特征提取器接口
+// FeatureExtractor 图像特征提取器接口。ctx取消或超时时返回ctx.Err()
 type FeatureExtractor interface {
-	ExtractFeatures(img image.Image) ([]float32, error)
+	ExtractFeatures(ctx context.Context, img image.Image) ([]float32, error)
 	GetDimension() int
 }
 
+// ExtractorSimple 简单特征提取器名称
+const ExtractorSimple = "simple"
+
+// NewFeatureExtractor 按名称和维度创建特征提取器
+func NewFeatureExtractor(name string, dimension int) (FeatureExtractor, error) {
+	switch name {
+	case ExtractorSimple, "":
+		extractor := NewSimpleFeatureExtractor()
+		if dimension > 0 {
+			extractor.Dimension = dimension
+		}
+		return extractor, nil
+	default:
+		return nil, fmt.Errorf("不支持的特征提取器: %s", name)
+	}
+}
+
 // SimpleFeatureExtractor 简单的特征提取器（基于颜色直方图和纹理特征）
 type SimpleFeatureExtractor struct {
 	Dimension int
@@ -26,18 +47,36 @@ func NewSimpleFeatureExtractor() *SimpleFeatureExtractor {
 }
 
 // ExtractFeatures 提取图像特征
-func (e *SimpleFeatureExtractor) ExtractFeatures(img image.Image) ([]float32, error) {
+func (e *SimpleFeatureExtractor) ExtractFeatures(ctx context.Context, img image.Image) ([]float32, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+
 	// 预处理图像
+	_, span := tracing.Start(ctx, "extract.preprocess")
 	processed := utils.PreprocessImage(img, 224)
+	span.End()
 
 	// 提取颜色直方图特征
+	_, span = tracing.Start(ctx, "extract.color_histogram")
 	colorFeatures := e.extractColorHistogram(processed)
+	span.End()
+
+	// 各阶段之间检查请求是否已取消
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
 	// 提取纹理特征
+	_, span = tracing.Start(ctx, "extract.texture")
 	textureFeatures := e.extractTextureFeatures(processed)
+	span.End()
 
 	// 提取空间特征
+	_, span = tracing.Start(ctx, "extract.spatial")
 	spatialFeatures := e.extractSpatialFeatures(processed)
+	span.End()
 
 	// 合并所有特征
 	features := append(colorFeatures, textureFeatures...)
@@ -52,6 +91,10 @@ func (e *SimpleFeatureExtractor) ExtractFeatures(img image.Image) ([]float32, er
 		features = append(features, padding...)
 	}
 
+	bounds := img.Bounds()
+	slog.DebugContext(ctx, "特征提取完成", "width", bounds.Dx(), "height", bounds.Dy(),
+		"dimension", e.Dimension, "duration_ms", float64(time.Since(start).Microseconds())/1000)
+
 	// L2归一化
 	return e.l2Normalize(features), nil
 }
@@ -267,13 +310,13 @@ func (e *SimpleFeatureExtractor) l2Normalize(features []float32) []float32 {
 }
 
 // BatchExtractFeatures 批量提取特征
-func (e *SimpleFeatureExtractor) BatchExtractFeatures(images []image.Image) ([][]float32, error) {
+func (e *SimpleFeatureExtractor) BatchExtractFeatures(ctx context.Context, images []image.Image) ([][]float32, error) {
 	features := make([][]float32, len(images))
 
 	for i, img := range images {
-		feature, err := e.ExtractFeatures(img)
+		feature, err := e.ExtractFeatures(ctx, img)
 		if err != nil {
-			return nil, fmt.Errorf("提取第%d张图像特征失败: %v", i, err)
+			return nil, fmt.Errorf("提取第%d张图像特征失败: %w", i, err)
 		}
 		features[i] = feature
 	}
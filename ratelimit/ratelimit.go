@@ -0,0 +1,155 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"image-search-go/auth"
+	"image-search-go/config"
+	"image-search-go/metrics"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+// 限流的操作，各自有独立的令牌桶
+const (
+	OpSearch = "search"
+	OpUpload = "upload"
+	OpDelete = "delete"
+)
+
+// minIdleTimeout 客户端超过该时间没有请求时回收其令牌桶（令牌桶补满所需时间更长时按补满时间）
+const minIdleTimeout = 10 * time.Minute
+
+// ClientKey 限流和配额使用的客户端标识：已认证时为调用方标识，否则为客户端IP
+func ClientKey(c *gin.Context) string {
+	if identity := auth.PrincipalFrom(c).Identity(); identity != "" {
+		return identity
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// AbortTooManyRequests 返回429和 Retry-After（向上取整到秒）
+func AbortTooManyRequests(c *gin.Context, retryAfter time.Duration, message string) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+		"success": false,
+		"message": message,
+	})
+}
+
+// client 单个客户端的令牌桶
+type client struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// Limiter 按客户端的令牌桶限流器
+type Limiter struct {
+	limit rate.Limit
+	burst int
+	idle  time.Duration
+
+	mu        sync.Mutex
+	clients   map[string]*client
+	lastSweep time.Time
+}
+
+// NewLimiter 创建限流器，Rate为0时返回nil（不限流）
+func NewLimiter(cfg config.RateLimit) *Limiter {
+	if cfg.Rate <= 0 {
+		return nil
+	}
+	burst := cfg.Burst
+	if burst < 1 {
+		burst = int(math.Ceil(cfg.Rate))
+	}
+
+	idle := time.Duration(float64(burst) / cfg.Rate * float64(time.Second))
+	if idle < minIdleTimeout {
+		idle = minIdleTimeout
+	}
+	return &Limiter{
+		limit:   rate.Limit(cfg.Rate),
+		burst:   burst,
+		idle:    idle,
+		clients: map[string]*client{},
+	}
+}
+
+// Allow 消耗客户端的一个令牌；令牌不足时不消耗，返回需要等待的时间
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	now := time.Now()
+
+	l.mu.Lock()
+	l.sweepLocked(now)
+	cl, ok := l.clients[key]
+	if !ok {
+		cl = &client{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[key] = cl
+	}
+	cl.lastSeen = now
+	l.mu.Unlock()
+
+	r := cl.limiter.ReserveN(now, 1)
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// sweepLocked 回收空闲客户端的令牌桶（此时令牌已补满，回收不影响限流），需持有锁
+func (l *Limiter) sweepLocked(now time.Time) {
+	if now.Sub(l.lastSweep) < l.idle {
+		return
+	}
+	l.lastSweep = now
+	for key, cl := range l.clients {
+		if now.Sub(cl.lastSeen) >= l.idle {
+			delete(l.clients, key)
+		}
+	}
+}
+
+// Limits 各操作的限流器
+type Limits struct {
+	limiters map[string]*Limiter
+	rates    map[string]config.RateLimit
+}
+
+// New 按配置创建各操作的限流器
+func New(cfg *config.RateLimitConfig) *Limits {
+	rates := map[string]config.RateLimit{
+		OpSearch: cfg.Search,
+		OpUpload: cfg.Upload,
+		OpDelete: cfg.Delete,
+	}
+	l := &Limits{limiters: map[string]*Limiter{}, rates: rates}
+	for op, r := range rates {
+		l.limiters[op] = NewLimiter(r)
+	}
+	return l
+}
+
+// Middleware 按客户端限制操作的请求速率，超出时返回429。需在认证中间件之后使用
+func (l *Limits) Middleware(op string) gin.HandlerFunc {
+	limiter := l.limiters[op]
+	return func(c *gin.Context) {
+		if limiter == nil {
+			c.Next()
+			return
+		}
+		if ok, retryAfter := limiter.Allow(ClientKey(c)); !ok {
+			metrics.IncRateLimited(op, "rate")
+			AbortTooManyRequests(c, retryAfter, fmt.Sprintf("%s 请求过于频繁（每秒 %g 次，突发 %d 次），请稍后重试", op, l.rates[op].Rate, limiter.burst))
+			return
+		}
+		c.Next()
+	}
+}
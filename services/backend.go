@@ -0,0 +1,171 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"image-search-go/config"
+	"image-search-go/storage"
+)
+
+// ErrNotReady 向量存储尚未初始化完成（Milvus启动中或不可用）
+var ErrNotReady = errors.New("向量存储尚未就绪，请稍后重试")
+
+// startupAttemptTimeout 单次初始化（连接Milvus、初始化collection、打开命名空间）的超时时间
+const startupAttemptTimeout = 30 * time.Second
+
+// Backend 向量存储：在后台连接Milvus并打开命名空间，失败时按指数退避重试。
+// 初始化完成前服务以降级模式运行，依赖向量存储的请求返回503
+type Backend struct {
+	cfg           *config.Config
+	metadataStore *MetadataStore
+	blobStore     storage.BlobStore
+
+	mu         sync.RWMutex
+	milvus     *MilvusService
+	namespaces *NamespaceManager
+	attempts   int
+	lastError  string
+	startedAt  time.Time
+	readyAt    time.Time
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// StartBackend 启动后台初始化，立即返回
+func StartBackend(cfg *config.Config, metadataStore *MetadataStore, blobStore storage.BlobStore) *Backend {
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &Backend{
+		cfg:           cfg,
+		metadataStore: metadataStore,
+		blobStore:     blobStore,
+		startedAt:     time.Now(),
+		cancel:        cancel,
+		done:          make(chan struct{}),
+	}
+	go b.run(ctx)
+	return b
+}
+
+// run 重试初始化直到成功或被关闭
+func (b *Backend) run(ctx context.Context) {
+	defer close(b.done)
+
+	for attempt := 0; ; attempt++ {
+		err := b.init(ctx)
+		if err == nil {
+			return
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		wait := backoff(time.Second, b.cfg.Milvus.MaxBackoff, attempt)
+		b.mu.Lock()
+		b.attempts = attempt + 1
+		b.lastError = err.Error()
+		b.mu.Unlock()
+		slog.Warn("向量存储初始化失败，稍后重试", "attempt", attempt+1, "retry_in", wait.String(), "error", err)
+
+		if sleepErr := sleepContext(ctx, wait); sleepErr != nil {
+			return
+		}
+	}
+}
+
+// init 连接Milvus并打开全部命名空间
+func (b *Backend) init(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, startupAttemptTimeout)
+	defer cancel()
+
+	milvusService, err := NewMilvusService(ctx, &b.cfg.Milvus)
+	if err != nil {
+		return err
+	}
+	namespaces, err := NewNamespaceManager(ctx, milvusService, b.cfg, b.metadataStore, b.blobStore)
+	if err != nil {
+		milvusService.Close()
+		return err
+	}
+
+	b.mu.Lock()
+	b.milvus = milvusService
+	b.namespaces = namespaces
+	b.attempts++
+	b.lastError = ""
+	b.readyAt = time.Now()
+	b.mu.Unlock()
+
+	slog.Info("向量存储已就绪", "dimension", namespaces.Default().Extractor.GetDimension(), "elapsed", time.Since(b.startedAt).Round(time.Millisecond).String())
+	return nil
+}
+
+// Namespaces 返回命名空间管理器，尚未就绪时返回nil
+func (b *Backend) Namespaces() *NamespaceManager {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.namespaces
+}
+
+// Ready 是否已初始化完成
+func (b *Backend) Ready() bool {
+	return b.Namespaces() != nil
+}
+
+// VectorCounts 各命名空间的向量数量，尚未就绪时返回nil，查询失败的命名空间不返回
+func (b *Backend) VectorCounts(ctx context.Context) map[string]int64 {
+	namespaces := b.Namespaces()
+	if namespaces == nil {
+		return nil
+	}
+
+	counts := map[string]int64{}
+	for _, ns := range namespaces.List() {
+		tenant, err := namespaces.Get(ns.Name)
+		if err != nil {
+			continue
+		}
+		if count, err := tenant.Milvus.RowCount(ctx); err == nil {
+			counts[ns.Name] = count
+		}
+	}
+	return counts
+}
+
+// Status 初始化状态
+func (b *Backend) Status() map[string]interface{} {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	status := map[string]interface{}{
+		"ready":      b.namespaces != nil,
+		"attempts":   b.attempts,
+		"started_at": b.startedAt,
+	}
+	if !b.readyAt.IsZero() {
+		status["ready_at"] = b.readyAt
+	}
+	if b.lastError != "" {
+		status["last_error"] = b.lastError
+	}
+	return status
+}
+
+// Close 停止初始化，写入各命名空间缓冲中的记录并关闭连接
+func (b *Backend) Close() {
+	b.cancel()
+	<-b.done
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.namespaces != nil {
+		b.namespaces.Close()
+	}
+	if b.milvus != nil {
+		b.milvus.Close()
+	}
+}
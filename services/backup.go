@@ -0,0 +1,415 @@
+package services
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 备份格式
+const (
+	BackupFormatJSONL = "jsonl" // 向量写在每行记录中
+	BackupFormatNPY   = "npy"   // 向量写入 vectors.npy（float32，N×D），记录按行对应
+)
+
+// BackupFormatVersion 备份目录布局的版本
+const BackupFormatVersion = 1
+
+// 备份目录中的文件
+const (
+	backupManifestFile = "manifest.json"
+	backupRowsFile     = "rows.jsonl"
+	backupVectorsFile  = "vectors.npy"
+	backupFilesDir     = "files"
+)
+
+// BackupManifest 备份清单，最后写入，存在即表示备份完整
+type BackupManifest struct {
+	FormatVersion int       `json:"format_version"`
+	Format        string    `json:"format"`
+	CreatedAt     time.Time `json:"created_at"`
+	Namespace     string    `json:"namespace"`
+	Collection    string    `json:"collection"`
+	SchemaVersion int       `json:"schema_version"`
+	Extractor     string    `json:"extractor"`
+	Dimension     int       `json:"dimension"`
+	IndexType     string    `json:"index_type"`
+	MetricType    string    `json:"metric_type"`
+	PartitionBy   string    `json:"partition_by,omitempty"`
+	RowCount      int64     `json:"row_count"`
+	IncludesFiles bool      `json:"includes_files"`
+	FileCount     int       `json:"file_count"`
+}
+
+// BackupRow 备份中的一条记录
+type BackupRow struct {
+	ImageID   string         `json:"image_id"`
+	Vector    []float32      `json:"vector,omitempty"` // npy格式时为空
+	Timestamp int64          `json:"timestamp"`
+	ObjectKey string         `json:"object_key,omitempty"`
+	MimeType  string         `json:"mime_type,omitempty"`
+	FileSize  int64          `json:"file_size,omitempty"`
+	Metadata  *ImageMetadata `json:"metadata,omitempty"`
+}
+
+// BackupWriter 按备份目录布局流式写入记录和图像文件
+type BackupWriter struct {
+	dir      string
+	manifest *BackupManifest
+	rowsFile *os.File
+	rows     *bufio.Writer
+	encoder  *json.Encoder
+	vectors  *npyWriter
+}
+
+// CreateBackup 创建备份目录，目录已存在且包含备份时返回错误
+func CreateBackup(dir string, manifest *BackupManifest) (*BackupWriter, error) {
+	if manifest.Format != BackupFormatJSONL && manifest.Format != BackupFormatNPY {
+		return nil, fmt.Errorf("不支持的备份格式: %s", manifest.Format)
+	}
+	if _, err := os.Stat(filepath.Join(dir, backupManifestFile)); err == nil {
+		return nil, fmt.Errorf("目录 %s 中已有备份", dir)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("创建备份目录失败: %v", err)
+	}
+
+	rowsFile, err := os.Create(filepath.Join(dir, backupRowsFile))
+	if err != nil {
+		return nil, fmt.Errorf("创建备份文件失败: %v", err)
+	}
+
+	w := &BackupWriter{
+		dir:      dir,
+		manifest: manifest,
+		rowsFile: rowsFile,
+		rows:     bufio.NewWriter(rowsFile),
+	}
+	w.encoder = json.NewEncoder(w.rows)
+
+	if manifest.Format == BackupFormatNPY {
+		if w.vectors, err = newNPYWriter(filepath.Join(dir, backupVectorsFile), manifest.Dimension); err != nil {
+			rowsFile.Close()
+			return nil, err
+		}
+	}
+
+	manifest.FormatVersion = BackupFormatVersion
+	manifest.CreatedAt = time.Now()
+	return w, nil
+}
+
+// WriteRow 写入一条记录
+func (w *BackupWriter) WriteRow(row *BackupRow) error {
+	if len(row.Vector) != w.manifest.Dimension {
+		return fmt.Errorf("记录 %s 的向量维度 %d 与清单 %d 不一致", row.ImageID, len(row.Vector), w.manifest.Dimension)
+	}
+
+	if w.vectors != nil {
+		if err := w.vectors.write(row.Vector); err != nil {
+			return err
+		}
+		copied := *row
+		copied.Vector = nil
+		row = &copied
+	}
+
+	if err := w.encoder.Encode(row); err != nil {
+		return fmt.Errorf("写入备份记录失败: %v", err)
+	}
+	w.manifest.RowCount++
+	return nil
+}
+
+// WriteFile 写入一个图像文件
+func (w *BackupWriter) WriteFile(key string, r io.Reader) error {
+	path, err := backupFilePath(w.dir, key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("创建备份目录失败: %v", err)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("创建备份文件失败: %v", err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, r); err != nil {
+		return fmt.Errorf("写入图像文件 %s 失败: %v", key, err)
+	}
+	w.manifest.IncludesFiles = true
+	w.manifest.FileCount++
+	return nil
+}
+
+// Close 完成备份：补全向量文件头并写入清单
+func (w *BackupWriter) Close() error {
+	if err := w.rows.Flush(); err != nil {
+		w.rowsFile.Close()
+		return fmt.Errorf("写入备份记录失败: %v", err)
+	}
+	if err := w.rowsFile.Close(); err != nil {
+		return err
+	}
+	if w.vectors != nil {
+		if err := w.vectors.close(); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.MarshalIndent(w.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(w.dir, backupManifestFile), data, 0644); err != nil {
+		return fmt.Errorf("写入备份清单失败: %v", err)
+	}
+	return nil
+}
+
+// BackupReader 流式读取备份目录
+type BackupReader struct {
+	dir      string
+	manifest *BackupManifest
+	rowsFile *os.File
+	decoder  *json.Decoder
+	vectors  *npyReader
+}
+
+// OpenBackup 打开备份目录，没有清单（备份未完成）时返回错误
+func OpenBackup(dir string) (*BackupReader, error) {
+	data, err := os.ReadFile(filepath.Join(dir, backupManifestFile))
+	if err != nil {
+		return nil, fmt.Errorf("读取备份清单失败（备份可能未完成）: %v", err)
+	}
+	manifest := &BackupManifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("解析备份清单失败: %v", err)
+	}
+	if manifest.FormatVersion > BackupFormatVersion {
+		return nil, fmt.Errorf("备份格式版本 %d 高于当前支持的版本 %d", manifest.FormatVersion, BackupFormatVersion)
+	}
+
+	rowsFile, err := os.Open(filepath.Join(dir, backupRowsFile))
+	if err != nil {
+		return nil, fmt.Errorf("打开备份记录失败: %v", err)
+	}
+
+	r := &BackupReader{
+		dir:      dir,
+		manifest: manifest,
+		rowsFile: rowsFile,
+		decoder:  json.NewDecoder(bufio.NewReader(rowsFile)),
+	}
+	if manifest.Format == BackupFormatNPY {
+		if r.vectors, err = openNPYReader(filepath.Join(dir, backupVectorsFile), manifest.Dimension); err != nil {
+			rowsFile.Close()
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Manifest 返回备份清单
+func (r *BackupReader) Manifest() *BackupManifest {
+	return r.manifest
+}
+
+// Next 读取下一条记录，读完时返回io.EOF
+func (r *BackupReader) Next() (*BackupRow, error) {
+	row := &BackupRow{}
+	if err := r.decoder.Decode(row); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("解析备份记录失败: %v", err)
+	}
+
+	if r.vectors != nil {
+		vector, err := r.vectors.read()
+		if err != nil {
+			return nil, fmt.Errorf("读取记录 %s 的向量失败: %v", row.ImageID, err)
+		}
+		row.Vector = vector
+	}
+	if len(row.Vector) != r.manifest.Dimension {
+		return nil, fmt.Errorf("记录 %s 的向量维度 %d 与清单 %d 不一致", row.ImageID, len(row.Vector), r.manifest.Dimension)
+	}
+	return row, nil
+}
+
+// OpenFile 打开备份中的图像文件，不存在时返回os.ErrNotExist
+func (r *BackupReader) OpenFile(key string) (*os.File, int64, error) {
+	path, err := backupFilePath(r.dir, key)
+	if err != nil {
+		return nil, 0, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, info.Size(), nil
+}
+
+// Close 关闭备份文件
+func (r *BackupReader) Close() {
+	r.rowsFile.Close()
+	if r.vectors != nil {
+		r.vectors.file.Close()
+	}
+}
+
+// backupFilePath 图像文件在备份目录中的路径，拒绝跳出备份目录的key
+func backupFilePath(dir, key string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(key))
+	if key == "" || filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("无效的文件key: %s", key)
+	}
+	return filepath.Join(dir, backupFilesDir, clean), nil
+}
+
+// npyHeaderLen npy文件头长度（含魔数），行数在关闭时回填，因此使用固定宽度
+const npyHeaderLen = 128
+
+// npyWriter 写入float32二维数组的.npy文件
+type npyWriter struct {
+	file      *os.File
+	buf       *bufio.Writer
+	dimension int
+	rows      int64
+}
+
+func newNPYWriter(path string, dimension int) (*npyWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("创建向量文件失败: %v", err)
+	}
+
+	w := &npyWriter{file: f, buf: bufio.NewWriter(f), dimension: dimension}
+	if _, err := w.buf.Write(npyHeader(0, dimension)); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// npyHeader 生成固定长度的.npy v1.0文件头
+func npyHeader(rows int64, dimension int) []byte {
+	dict := fmt.Sprintf("{'descr': '<f4', 'fortran_order': False, 'shape': (%20d, %d), }", rows, dimension)
+	header := make([]byte, 0, npyHeaderLen)
+	header = append(header, "\x93NUMPY\x01\x00"...)
+	header = binary.LittleEndian.AppendUint16(header, uint16(npyHeaderLen-10))
+	header = append(header, dict...)
+	for len(header) < npyHeaderLen-1 {
+		header = append(header, ' ')
+	}
+	return append(header, '\n')
+}
+
+func (w *npyWriter) write(vector []float32) error {
+	var b [4]byte
+	for _, v := range vector {
+		binary.LittleEndian.PutUint32(b[:], math.Float32bits(v))
+		if _, err := w.buf.Write(b[:]); err != nil {
+			return fmt.Errorf("写入向量失败: %v", err)
+		}
+	}
+	w.rows++
+	return nil
+}
+
+// close 回填行数并关闭文件
+func (w *npyWriter) close() error {
+	if err := w.buf.Flush(); err != nil {
+		w.file.Close()
+		return fmt.Errorf("写入向量失败: %v", err)
+	}
+	if _, err := w.file.WriteAt(npyHeader(w.rows, w.dimension), 0); err != nil {
+		w.file.Close()
+		return fmt.Errorf("写入向量文件头失败: %v", err)
+	}
+	return w.file.Close()
+}
+
+// npyShapePattern 从.npy文件头中解析二维shape
+var npyShapePattern = regexp.MustCompile(`'shape':\s*\(\s*(\d+)\s*,\s*(\d+)\s*,?\s*\)`)
+
+// npyReader 读取float32二维数组的.npy文件
+type npyReader struct {
+	file      *os.File
+	buf       *bufio.Reader
+	dimension int
+}
+
+func openNPYReader(path string, dimension int) (*npyReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("打开向量文件失败: %v", err)
+	}
+	buf := bufio.NewReader(f)
+
+	prefix := make([]byte, 10)
+	if _, err := io.ReadFull(buf, prefix); err != nil || string(prefix[:6]) != "\x93NUMPY" {
+		f.Close()
+		return nil, fmt.Errorf("无效的.npy文件: %s", path)
+	}
+
+	// v1.0文件头长度为2字节，v2.0及以上为4字节
+	headerLen := int(binary.LittleEndian.Uint16(prefix[8:10]))
+	if prefix[6] >= 2 {
+		extra := make([]byte, 2)
+		if _, err := io.ReadFull(buf, extra); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("无效的.npy文件: %s", path)
+		}
+		headerLen = int(binary.LittleEndian.Uint32(append(prefix[8:10], extra...)))
+	}
+	header := make([]byte, headerLen)
+	if _, err := io.ReadFull(buf, header); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("无效的.npy文件头: %v", err)
+	}
+
+	dict := string(header)
+	match := npyShapePattern.FindStringSubmatch(dict)
+	if !strings.Contains(dict, "'<f4'") || strings.Contains(dict, "'fortran_order': True") || match == nil {
+		f.Close()
+		return nil, fmt.Errorf("只支持C顺序的float32二维数组: %s", strings.TrimSpace(dict))
+	}
+	if cols, _ := strconv.Atoi(match[2]); cols != dimension {
+		f.Close()
+		return nil, fmt.Errorf("向量文件维度 %d 与清单 %d 不一致", cols, dimension)
+	}
+
+	return &npyReader{file: f, buf: buf, dimension: dimension}, nil
+}
+
+func (r *npyReader) read() ([]float32, error) {
+	data := make([]byte, 4*r.dimension)
+	if _, err := io.ReadFull(r.buf, data); err != nil {
+		return nil, err
+	}
+
+	vector := make([]float32, r.dimension)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:]))
+	}
+	return vector, nil
+}
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"image-search-go/config"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ErrDailyQuotaExceeded 客户端今天上传的图像数或字节数达到配额
+var ErrDailyQuotaExceeded = errors.New("已达到今日上传配额")
+
+// dailyUsageBucket 各客户端每天的上传用量，key为 日期/客户端
+var dailyUsageBucket = []byte("daily_usage")
+
+// dayLayout 用量记录的日期格式（UTC）
+const dayLayout = "2006-01-02"
+
+// DailyUsage 客户端一天的上传用量
+type DailyUsage struct {
+	Images int64 `json:"images"`
+	Bytes  int64 `json:"bytes"`
+}
+
+// QuotaCharge 一次上传计入的用量，保存在图像元数据中，当天删除图像时据此归还
+type QuotaCharge struct {
+	Day    string `json:"day"`
+	Client string `json:"client"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// DailyQuota 按客户端限制每天（UTC）存入的图像数和字节数，用量保存在元数据库中，重启后不重置。
+// 当天上传后又删除的图像归还用量，之前日期上传的图像删除时不影响当天用量
+type DailyQuota struct {
+	store     *MetadataStore
+	maxImages int64
+	maxBytes  int64
+
+	mu        sync.Mutex
+	lastPrune string
+}
+
+// NewDailyQuota 创建每日配额，图像数和字节数都不限制时返回nil
+func NewDailyQuota(store *MetadataStore, cfg *config.RateLimitConfig) *DailyQuota {
+	if cfg.DailyImages <= 0 && cfg.DailyBytes <= 0 {
+		return nil
+	}
+	return &DailyQuota{store: store, maxImages: cfg.DailyImages, maxBytes: cfg.DailyBytes}
+}
+
+// Reserve 为一次上传预占用量，超出配额时返回 ErrDailyQuotaExceeded。
+// 返回的charge随元数据保存；上传失败或未写入新图像（幂等重放）时调用返回的release归还用量
+func (q *DailyQuota) Reserve(client string, size int64) (charge *QuotaCharge, release func(), err error) {
+	if q == nil {
+		return nil, func() {}, nil
+	}
+
+	day := time.Now().UTC().Format(dayLayout)
+	q.prune(day)
+	if _, err := q.store.addDailyUsage(day, client, 1, size, q.maxImages, q.maxBytes); err != nil {
+		return nil, nil, err
+	}
+
+	charge = &QuotaCharge{Day: day, Client: client, Bytes: size}
+	var once sync.Once
+	return charge, func() {
+		once.Do(func() { q.Refund(charge) })
+	}, nil
+}
+
+// Refund 删除图像时归还其上传当天计入的用量，charge不是今天的记录时忽略
+func (q *DailyQuota) Refund(charge *QuotaCharge) {
+	if q == nil || charge == nil || charge.Day != time.Now().UTC().Format(dayLayout) {
+		return
+	}
+	if _, err := q.store.addDailyUsage(charge.Day, charge.Client, -1, -charge.Bytes, 0, 0); err != nil {
+		slog.Warn("归还上传用量失败", "client", charge.Client, "error", err)
+	}
+}
+
+// ResetAfter 距离配额重置（下一个UTC零点）的时间
+func (q *DailyQuota) ResetAfter() time.Duration {
+	now := time.Now().UTC()
+	return now.Truncate(24 * time.Hour).Add(24 * time.Hour).Sub(now)
+}
+
+// prune 每天第一次使用时删除之前日期的用量记录
+func (q *DailyQuota) prune(day string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.lastPrune == day {
+		return
+	}
+	if err := q.store.pruneDailyUsage(day); err == nil {
+		q.lastPrune = day
+	}
+}
+
+// addDailyUsage 在一个事务中检查并增加用量，maxImages、maxBytes大于0时增加后不能超过该值
+func (m *MetadataStore) addDailyUsage(day, client string, images, bytes, maxImages, maxBytes int64) (*DailyUsage, error) {
+	key := []byte(day + "/" + client)
+	usage := &DailyUsage{}
+	err := m.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(dailyUsageBucket)
+		if data := bucket.Get(key); data != nil {
+			if err := json.Unmarshal(data, usage); err != nil {
+				return fmt.Errorf("解析上传用量失败: %v", err)
+			}
+		}
+
+		if maxImages > 0 && usage.Images+images > maxImages {
+			return fmt.Errorf("%w: 今日已上传 %d 张图像，上限 %d 张", ErrDailyQuotaExceeded, usage.Images, maxImages)
+		}
+		if maxBytes > 0 && usage.Bytes+bytes > maxBytes {
+			return fmt.Errorf("%w: 今日已上传 %d 字节，本次 %d 字节，上限 %d 字节", ErrDailyQuotaExceeded, usage.Bytes, bytes, maxBytes)
+		}
+
+		usage.Images = max(usage.Images+images, 0)
+		usage.Bytes = max(usage.Bytes+bytes, 0)
+		data, err := json.Marshal(usage)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(key, data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+// pruneDailyUsage 删除day之前的用量记录
+func (m *MetadataStore) pruneDailyUsage(day string) error {
+	return m.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket(dailyUsageBucket).Cursor()
+		for k, _ := c.First(); k != nil && string(k) < day; k, _ = c.First() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,41 @@
+package services
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"image-search-go/config"
+)
+
+func TestDailyQuotaRefundOnDelete(t *testing.T) {
+	store, err := NewMetadataStore(filepath.Join(t.TempDir(), "metadata.db"))
+	if err != nil {
+		t.Fatalf("NewMetadataStore: %v", err)
+	}
+	defer store.Close()
+	quota := NewDailyQuota(store, &config.RateLimitConfig{DailyImages: 2, DailyBytes: 100})
+
+	first, _, err := quota.Reserve("api_key:ci", 40)
+	if err != nil {
+		t.Fatalf("第一次上传应在配额内: %v", err)
+	}
+	if _, _, err := quota.Reserve("api_key:ci", 40); err != nil {
+		t.Fatalf("第二次上传应在配额内: %v", err)
+	}
+	if _, _, err := quota.Reserve("api_key:ci", 10); !errors.Is(err, ErrDailyQuotaExceeded) {
+		t.Fatalf("超出图像数时应返回ErrDailyQuotaExceeded，实际 %v", err)
+	}
+
+	// 删除当天上传的图像后归还用量
+	quota.Refund(first)
+	if _, _, err := quota.Reserve("api_key:ci", 60); err != nil {
+		t.Fatalf("删除后应可再次上传: %v", err)
+	}
+
+	// 之前日期的用量已清理，不从当天用量中扣除
+	quota.Refund(&QuotaCharge{Day: "2000-01-01", Client: "api_key:ci", Bytes: 40})
+	if _, _, err := quota.Reserve("api_key:ci", 1); !errors.Is(err, ErrDailyQuotaExceeded) {
+		t.Fatalf("归还之前日期的用量不应影响当天配额，实际 %v", err)
+	}
+}
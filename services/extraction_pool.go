@@ -0,0 +1,228 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"image-search-go/config"
+	"image-search-go/metrics"
+	"image-search-go/models"
+)
+
+// ErrExtractionOverloaded 特征提取队列已满或排队超时，请求被拒绝
+var ErrExtractionOverloaded = errors.New("特征提取繁忙，请稍后重试")
+
+// ExtractionPool 限制同时执行的特征提取数，超出的请求排队等待；
+// 队列满或排队超时时返回 ErrExtractionOverloaded，避免CPU被打满后所有请求一起超时
+type ExtractionPool struct {
+	slots     chan struct{}
+	queueSize int
+	timeout   time.Duration
+
+	queued atomic.Int64
+
+	// images 读取、解码和等待提取的图像名额，见 AdmitImage
+	images       chan struct{}
+	imagesQueued atomic.Int64
+
+	mu        sync.Mutex
+	completed int64
+	rejected  int64
+	timedOut  int64
+	totalWait time.Duration
+	maxWait   time.Duration
+}
+
+// ExtractionStats 特征提取池的运行状态
+type ExtractionStats struct {
+	Workers        int     `json:"workers"`
+	QueueSize      int     `json:"queue_size"`
+	QueueTimeoutMs int64   `json:"queue_timeout_ms"`
+	Active         int     `json:"active"`
+	Queued         int64   `json:"queued"`
+	MaxImages      int     `json:"max_images"`
+	ImagesInFlight int     `json:"images_in_flight"`
+	ImagesQueued   int64   `json:"images_queued"`
+	Completed      int64   `json:"completed"`
+	Rejected       int64   `json:"rejected"`
+	TimedOut       int64   `json:"timed_out"`
+	AvgWaitMs      float64 `json:"avg_wait_ms"`
+	MaxWaitMs      float64 `json:"max_wait_ms"`
+}
+
+// NewExtractionPool 按配置创建特征提取池，Workers小于1时按1处理，MaxImages不小于Workers
+func NewExtractionPool(cfg *config.ExtractionConfig) *ExtractionPool {
+	workers := max(cfg.Workers, 1)
+	return &ExtractionPool{
+		slots:     make(chan struct{}, workers),
+		images:    make(chan struct{}, max(cfg.MaxImages, workers)),
+		queueSize: max(cfg.QueueSize, 0),
+		timeout:   cfg.QueueTimeout,
+	}
+}
+
+// acquire 占用一个执行位，返回排队等待的时间
+func (p *ExtractionPool) acquire(ctx context.Context) (time.Duration, error) {
+	select {
+	case p.slots <- struct{}{}:
+		p.recordWait(0)
+		return 0, nil
+	default:
+	}
+
+	if p.queued.Add(1) > int64(p.queueSize) {
+		p.queued.Add(-1)
+		p.reject("queue_full", 0)
+		return 0, fmt.Errorf("%w: 排队请求已达上限 %d", ErrExtractionOverloaded, p.queueSize)
+	}
+	p.updateLoad()
+	defer func() {
+		p.queued.Add(-1)
+		p.updateLoad()
+	}()
+
+	var timeout <-chan time.Time
+	if p.timeout > 0 {
+		timer := time.NewTimer(p.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	start := time.Now()
+	select {
+	case p.slots <- struct{}{}:
+		wait := time.Since(start)
+		p.recordWait(wait)
+		return wait, nil
+	case <-timeout:
+		p.reject("queue_timeout", time.Since(start))
+		return 0, fmt.Errorf("%w: 排队超过 %s", ErrExtractionOverloaded, p.timeout)
+	case <-ctx.Done():
+		metrics.ObserveExtractionWait(time.Since(start))
+		return 0, ctx.Err()
+	}
+}
+
+// AdmitImage 占用一个图像名额，需在读取上传内容和解码图像之前调用，特征提取完成、
+// 不再需要原始数据和解码后的图像时调用返回的release。限制同时在内存中的图像数，
+// 避免请求在排队等待提取前就各自读入和解码整张图像。排队规则与特征提取相同
+func (p *ExtractionPool) AdmitImage(ctx context.Context) (release func(), err error) {
+	release = func() { <-p.images }
+	select {
+	case p.images <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	if p.imagesQueued.Add(1) > int64(p.queueSize) {
+		p.imagesQueued.Add(-1)
+		p.reject("image_queue_full", 0)
+		return nil, fmt.Errorf("%w: 等待处理的图像已达上限 %d", ErrExtractionOverloaded, p.queueSize)
+	}
+	defer p.imagesQueued.Add(-1)
+
+	var timeout <-chan time.Time
+	if p.timeout > 0 {
+		timer := time.NewTimer(p.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	start := time.Now()
+	select {
+	case p.images <- struct{}{}:
+		return release, nil
+	case <-timeout:
+		p.reject("image_queue_timeout", time.Since(start))
+		return nil, fmt.Errorf("%w: 等待处理超过 %s", ErrExtractionOverloaded, p.timeout)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// release 归还执行位
+func (p *ExtractionPool) release() {
+	<-p.slots
+	p.mu.Lock()
+	p.completed++
+	p.mu.Unlock()
+	p.updateLoad()
+}
+
+// recordWait 记录一次成功占用执行位前的等待时间
+func (p *ExtractionPool) recordWait(wait time.Duration) {
+	p.mu.Lock()
+	p.totalWait += wait
+	p.maxWait = max(p.maxWait, wait)
+	p.mu.Unlock()
+	metrics.ObserveExtractionWait(wait)
+	p.updateLoad()
+}
+
+// reject 记录一次被拒绝的请求
+func (p *ExtractionPool) reject(reason string, wait time.Duration) {
+	p.mu.Lock()
+	p.rejected++
+	if reason == "queue_timeout" || reason == "image_queue_timeout" {
+		p.timedOut++
+	}
+	p.mu.Unlock()
+	metrics.IncExtractionRejected(reason)
+	if wait > 0 {
+		metrics.ObserveExtractionWait(wait)
+	}
+}
+
+// updateLoad 更新排队数和执行数指标
+func (p *ExtractionPool) updateLoad() {
+	metrics.SetExtractionLoad(int(p.queued.Load()), len(p.slots))
+}
+
+// Stats 返回特征提取池的运行状态
+func (p *ExtractionPool) Stats() *ExtractionStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := &ExtractionStats{
+		Workers:        cap(p.slots),
+		QueueSize:      p.queueSize,
+		QueueTimeoutMs: p.timeout.Milliseconds(),
+		Active:         len(p.slots),
+		Queued:         p.queued.Load(),
+		MaxImages:      cap(p.images),
+		ImagesInFlight: len(p.images),
+		ImagesQueued:   p.imagesQueued.Load(),
+		Completed:      p.completed,
+		Rejected:       p.rejected,
+		TimedOut:       p.timedOut,
+		MaxWaitMs:      float64(p.maxWait.Microseconds()) / 1000,
+	}
+	if acquired := p.completed + int64(stats.Active); acquired > 0 {
+		stats.AvgWaitMs = float64(p.totalWait.Microseconds()) / 1000 / float64(acquired)
+	}
+	return stats
+}
+
+// Wrap 包装特征提取器，使其提取在池中执行。所有命名空间共用同一个池
+func (p *ExtractionPool) Wrap(extractor models.FeatureExtractor) models.FeatureExtractor {
+	return &pooledExtractor{FeatureExtractor: extractor, pool: p}
+}
+
+// pooledExtractor 在特征提取池中执行提取的特征提取器
+type pooledExtractor struct {
+	models.FeatureExtractor
+	pool *ExtractionPool
+}
+
+func (e *pooledExtractor) ExtractFeatures(ctx context.Context, img image.Image) ([]float32, error) {
+	if _, err := e.pool.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer e.pool.release()
+	return e.FeatureExtractor.ExtractFeatures(ctx, img)
+}
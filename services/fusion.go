@@ -0,0 +1,185 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// 多向量融合方式
+const (
+	FusionAverage = "average" // 正例向量取平均并减去负例平均后搜索一次
+	FusionRRF     = "rrf"     // 倒数排名融合
+	FusionMin     = "min"     // 取与各正例的最小距离（最大相似度）
+)
+
+// rrfK 倒数排名融合的平滑常数
+const rrfK = 60
+
+// FusionQuery 多向量融合查询
+type FusionQuery struct {
+	Positives      [][]float32
+	Negatives      [][]float32
+	Method         string
+	TopK           int
+	NegativeWeight float32 // 负例权重，0表示使用默认值1
+}
+
+// SearchSimilarBatch 一次搜索多个查询向量，返回每个查询各自的结果列表
+func (s *MilvusService) SearchSimilarBatch(ctx context.Context, queryVectors [][]float32, topK int) ([][]*SearchResult, error) {
+	if len(queryVectors) == 0 {
+		return nil, fmt.Errorf("查询向量不能为空")
+	}
+
+	sp, _ := s.searchParam(topK)
+	return s.search(ctx, queryVectors, topK, sp)
+}
+
+// SearchFused 用多个正例和负例向量搜索，并把结果融合为一个排名
+func (s *MilvusService) SearchFused(ctx context.Context, query *FusionQuery) ([]*SearchResult, error) {
+	if len(query.Positives) == 0 {
+		return nil, fmt.Errorf("至少需要一个正例查询向量")
+	}
+	if query.NegativeWeight <= 0 {
+		query.NegativeWeight = 1
+	}
+
+	if query.Method == FusionAverage {
+		return s.SearchSimilar(ctx, AverageVector(query.Positives, query.Negatives, query.NegativeWeight), query.TopK)
+	}
+
+	// 多取一些候选结果，保证融合后仍有足够的结果
+	candidateK := query.TopK * 3
+	if candidateK > MaxRangeResults {
+		candidateK = MaxRangeResults
+	}
+
+	lists, err := s.SearchSimilarBatch(ctx, append(append([][]float32{}, query.Positives...), query.Negatives...), candidateK)
+	if err != nil {
+		return nil, err
+	}
+	positiveLists := lists[:len(query.Positives)]
+	negativeLists := lists[len(query.Positives):]
+
+	switch query.Method {
+	case FusionRRF:
+		return FuseRRF(positiveLists, negativeLists, query.NegativeWeight, query.TopK), nil
+	case FusionMin:
+		return FuseMinDistance(positiveLists, negativeLists, query.NegativeWeight, query.TopK), nil
+	default:
+		return nil, fmt.Errorf("不支持的融合方式: %s", query.Method)
+	}
+}
+
+// AverageVector 计算正例平均向量减去加权负例平均向量，并做L2归一化
+func AverageVector(positives, negatives [][]float32, negativeWeight float32) []float32 {
+	result := make([]float32, len(positives[0]))
+	for _, v := range positives {
+		for i := range result {
+			result[i] += v[i] / float32(len(positives))
+		}
+	}
+	for _, v := range negatives {
+		for i := range result {
+			result[i] -= negativeWeight * v[i] / float32(len(negatives))
+		}
+	}
+
+	var norm float64
+	for _, f := range result {
+		norm += float64(f * f)
+	}
+	norm = math.Sqrt(norm)
+	if norm > 0 {
+		for i := range result {
+			result[i] = float32(float64(result[i]) / norm)
+		}
+	}
+	return result
+}
+
+// FuseRRF 倒数排名融合：正例列表中的排名加分，负例列表中的排名按权重减分
+func FuseRRF(positiveLists, negativeLists [][]*SearchResult, negativeWeight float32, topK int) []*SearchResult {
+	candidates := map[string]*SearchResult{}
+	for _, list := range positiveLists {
+		for rank, result := range list {
+			candidate := bestCandidate(candidates, result)
+			candidate.FusionScore += 1 / float32(rrfK+rank+1)
+		}
+	}
+	for _, list := range negativeLists {
+		for rank, result := range list {
+			if candidate, ok := candidates[result.ImageID]; ok {
+				candidate.FusionScore -= negativeWeight / float32(rrfK+rank+1)
+			}
+		}
+	}
+
+	return rankCandidates(candidates, topK)
+}
+
+// FuseMinDistance 最小距离融合：取与各正例的最大相似度，减去与负例的最大相似度乘以权重。
+// 只在候选列表中出现的负例相似度才会被计入。
+func FuseMinDistance(positiveLists, negativeLists [][]*SearchResult, negativeWeight float32, topK int) []*SearchResult {
+	candidates := map[string]*SearchResult{}
+	for _, list := range positiveLists {
+		for _, result := range list {
+			candidate := bestCandidate(candidates, result)
+			candidate.FusionScore = candidate.Score
+		}
+	}
+
+	negativeSimilarity := map[string]float32{}
+	for _, list := range negativeLists {
+		for _, result := range list {
+			if result.Score > negativeSimilarity[result.ImageID] {
+				negativeSimilarity[result.ImageID] = result.Score
+			}
+		}
+	}
+	for imageID, candidate := range candidates {
+		candidate.FusionScore -= negativeWeight * negativeSimilarity[imageID]
+	}
+
+	return rankCandidates(candidates, topK)
+}
+
+// bestCandidate 合并同一图像在多个列表中的结果，保留相似度最高的一条
+func bestCandidate(candidates map[string]*SearchResult, result *SearchResult) *SearchResult {
+	candidate, ok := candidates[result.ImageID]
+	if !ok {
+		copied := *result
+		candidates[result.ImageID] = &copied
+		return &copied
+	}
+
+	if result.Score > candidate.Score {
+		fusionScore := candidate.FusionScore
+		*candidate = *result
+		candidate.FusionScore = fusionScore
+	}
+	return candidate
+}
+
+// rankCandidates 按融合分数从高到低排序，去掉分数不为正的结果并截取topK
+func rankCandidates(candidates map[string]*SearchResult, topK int) []*SearchResult {
+	ranked := make([]*SearchResult, 0, len(candidates))
+	for _, candidate := range candidates {
+		if candidate.FusionScore > 0 {
+			ranked = append(ranked, candidate)
+		}
+	}
+
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].FusionScore != ranked[j].FusionScore {
+			return ranked[i].FusionScore > ranked[j].FusionScore
+		}
+		return ranked[i].ImageID < ranked[j].ImageID
+	})
+
+	if len(ranked) > topK {
+		ranked = ranked[:topK]
+	}
+	return ranked
+}
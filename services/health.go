@@ -0,0 +1,341 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"image-search-go/config"
+	"image-search-go/utils"
+)
+
+// 检查项名称
+const (
+	CheckVectorStore      = "vector_store"      // 向量存储初始化完成
+	CheckMilvus           = "milvus"            // Milvus可以访问
+	CheckCollectionLoaded = "collection_loaded" // 各命名空间的collection已100%加载
+	CheckUploadDir        = "upload_dir"        // 上传目录可写且剩余空间充足
+	CheckExtractor        = "extractor"         // 特征提取器能处理合成图像
+)
+
+// ReadinessChecks 就绪检查和健康检查执行的检查项
+var ReadinessChecks = []string{CheckVectorStore, CheckMilvus, CheckCollectionLoaded, CheckUploadDir, CheckExtractor}
+
+// 检查结果状态
+const (
+	CheckOK      = "ok"
+	CheckFailed  = "fail"
+	CheckSkipped = "skipped" // 不适用或依赖的检查未通过
+)
+
+// selfTestSize 特征提取自检使用的合成图像边长
+const selfTestSize = 64
+
+// extractorCheckTTL 特征提取自检结果的缓存时间。就绪检查和健康检查可以匿名频繁调用，
+// 缓存期内直接返回上次的结果，不再为每个命名空间执行提取
+const extractorCheckTTL = time.Minute
+
+// CheckResult 单项检查结果
+type CheckResult struct {
+	Name      string                 `json:"name"`
+	Status    string                 `json:"status"`
+	LatencyMs float64                `json:"latency_ms"`
+	Message   string                 `json:"message,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+}
+
+// HealthReport 一组检查的结果，任一项失败时整体为fail
+type HealthReport struct {
+	Status string         `json:"status"`
+	Checks []*CheckResult `json:"checks"`
+}
+
+// OK 是否全部通过（跳过的检查不算失败）
+func (r *HealthReport) OK() bool {
+	return r.Status == CheckOK
+}
+
+// skippedError 检查不适用时返回，结果记为skipped
+type skippedError struct {
+	reason string
+}
+
+func (e *skippedError) Error() string {
+	return e.reason
+}
+
+func skip(reason string) error {
+	return &skippedError{reason: reason}
+}
+
+// checkFunc 执行一项检查，返回附加信息
+type checkFunc func(ctx context.Context) (map[string]interface{}, error)
+
+// HealthChecker 按名称执行健康检查
+type HealthChecker struct {
+	backend *Backend
+	config  *config.Config
+	checks  map[string]checkFunc
+
+	// extractorMu 保护特征提取自检的缓存结果，同一时间只执行一次自检
+	extractorMu      sync.Mutex
+	extractorResult  *selfTestResult
+	extractorRunning bool
+}
+
+// selfTestResult 缓存的特征提取自检结果
+type selfTestResult struct {
+	details   map[string]interface{}
+	err       error
+	checkedAt time.Time
+}
+
+// NewHealthChecker 创建健康检查器
+func NewHealthChecker(backend *Backend, cfg *config.Config) *HealthChecker {
+	h := &HealthChecker{backend: backend, config: cfg}
+	h.checks = map[string]checkFunc{
+		CheckVectorStore:      h.checkVectorStore,
+		CheckMilvus:           h.checkMilvus,
+		CheckCollectionLoaded: h.checkCollectionLoaded,
+		CheckUploadDir:        h.checkUploadDir,
+		CheckExtractor:        h.checkExtractor,
+	}
+	return h
+}
+
+// Run 并发执行指定的检查，按传入顺序返回结果
+func (h *HealthChecker) Run(ctx context.Context, names ...string) *HealthReport {
+	report := &HealthReport{Status: CheckOK, Checks: make([]*CheckResult, len(names))}
+
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			report.Checks[i] = h.run(ctx, name)
+		}(i, name)
+	}
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		if result.Status == CheckFailed {
+			report.Status = CheckFailed
+		}
+	}
+	return report
+}
+
+// run 执行一项检查并记录耗时
+func (h *HealthChecker) run(ctx context.Context, name string) *CheckResult {
+	result := &CheckResult{Name: name, Status: CheckOK}
+	check, ok := h.checks[name]
+	if !ok {
+		result.Status = CheckFailed
+		result.Message = fmt.Sprintf("未知的检查项: %s", name)
+		return result
+	}
+
+	start := time.Now()
+	details, err := check(ctx)
+	result.LatencyMs = float64(time.Since(start).Microseconds()) / 1000
+	result.Details = details
+
+	var skipped *skippedError
+	switch {
+	case errors.As(err, &skipped):
+		result.Status = CheckSkipped
+		result.Message = err.Error()
+	case err != nil:
+		result.Status = CheckFailed
+		result.Message = err.Error()
+	}
+	return result
+}
+
+// tenants 已就绪时返回全部命名空间，否则返回skipped错误
+func (h *HealthChecker) tenants() ([]*Tenant, error) {
+	namespaces := h.backend.Namespaces()
+	if namespaces == nil {
+		return nil, skip(ErrNotReady.Error())
+	}
+
+	var tenants []*Tenant
+	for _, ns := range namespaces.List() {
+		if tenant, err := namespaces.Get(ns.Name); err == nil {
+			tenants = append(tenants, tenant)
+		}
+	}
+	return tenants, nil
+}
+
+func (h *HealthChecker) checkVectorStore(ctx context.Context) (map[string]interface{}, error) {
+	status := h.backend.Status()
+	if !h.backend.Ready() {
+		return status, ErrNotReady
+	}
+	return status, nil
+}
+
+func (h *HealthChecker) checkMilvus(ctx context.Context) (map[string]interface{}, error) {
+	namespaces := h.backend.Namespaces()
+	if namespaces == nil {
+		return nil, skip(ErrNotReady.Error())
+	}
+	milvus := namespaces.Default().Milvus
+	err := milvus.HealthCheck(ctx)
+	return milvus.ConnectionStats(), err
+}
+
+func (h *HealthChecker) checkCollectionLoaded(ctx context.Context) (map[string]interface{}, error) {
+	tenants, err := h.tenants()
+	if err != nil {
+		return nil, err
+	}
+
+	progress := map[string]interface{}{}
+	var notLoaded []string
+	for _, tenant := range tenants {
+		percent, err := tenant.Milvus.LoadingProgress(ctx)
+		if err != nil {
+			return progress, fmt.Errorf("命名空间 %s: %w", tenant.Namespace.Name, err)
+		}
+		progress[tenant.Namespace.Name] = percent
+		if percent < 100 {
+			notLoaded = append(notLoaded, fmt.Sprintf("%s(%d%%)", tenant.Namespace.Name, percent))
+		}
+	}
+	if len(notLoaded) > 0 {
+		return progress, fmt.Errorf("collection尚未加载完成: %v", notLoaded)
+	}
+	return progress, nil
+}
+
+func (h *HealthChecker) checkUploadDir(ctx context.Context) (map[string]interface{}, error) {
+	if backend := h.config.Storage.Backend; backend != "" && backend != "local" {
+		return nil, skip(fmt.Sprintf("存储后端为 %s，不使用本地目录", backend))
+	}
+
+	dir := h.config.Server.UploadPath
+	details := map[string]interface{}{
+		"path":          dir,
+		"min_free_disk": h.config.Server.MinFreeDisk,
+	}
+
+	// 写入并删除一个临时文件，确认目录可写
+	f, err := os.CreateTemp(dir, ".healthcheck-*")
+	if err != nil {
+		return details, fmt.Errorf("上传目录不可写: %w", err)
+	}
+	_, writeErr := f.Write([]byte("ok"))
+	closeErr := f.Close()
+	os.Remove(f.Name())
+	if writeErr != nil {
+		return details, fmt.Errorf("上传目录不可写: %w", writeErr)
+	}
+	if closeErr != nil {
+		return details, fmt.Errorf("上传目录不可写: %w", closeErr)
+	}
+
+	free, err := utils.DiskFree(dir)
+	if errors.Is(err, utils.ErrDiskFreeUnsupported) {
+		return details, nil
+	}
+	if err != nil {
+		return details, fmt.Errorf("获取磁盘剩余空间失败: %w", err)
+	}
+	details["free_bytes"] = free
+	if free < h.config.Server.MinFreeDisk {
+		return details, fmt.Errorf("磁盘剩余空间不足: %d 字节，最少需要 %d 字节", free, h.config.Server.MinFreeDisk)
+	}
+	return details, nil
+}
+
+// checkExtractor 返回缓存的特征提取自检结果，超过 extractorCheckTTL 时重新自检。
+// 已有自检在执行时返回上次的结果，没有结果时记为skipped
+func (h *HealthChecker) checkExtractor(ctx context.Context) (map[string]interface{}, error) {
+	h.extractorMu.Lock()
+	cached := h.extractorResult
+	if cached != nil && (h.extractorRunning || time.Since(cached.checkedAt) < extractorCheckTTL) {
+		h.extractorMu.Unlock()
+		return cached.details, cached.err
+	}
+	if h.extractorRunning {
+		h.extractorMu.Unlock()
+		return nil, skip("特征提取自检进行中")
+	}
+	h.extractorRunning = true
+	h.extractorMu.Unlock()
+
+	dimensions, err := h.selfTestExtractors(ctx)
+	now := time.Now()
+	details := map[string]interface{}{"dimensions": dimensions, "checked_at": now}
+
+	h.extractorMu.Lock()
+	defer h.extractorMu.Unlock()
+	h.extractorRunning = false
+	// 跳过（尚未就绪、提取繁忙）和请求取消不代表提取器的状态，不缓存
+	var skipped *skippedError
+	if !errors.As(err, &skipped) && ctx.Err() == nil {
+		h.extractorResult = &selfTestResult{details: details, err: err, checkedAt: now}
+	}
+	return details, err
+}
+
+// selfTestExtractors 用合成图像检查各命名空间的特征提取器，返回各命名空间的特征维度
+func (h *HealthChecker) selfTestExtractors(ctx context.Context) (map[string]interface{}, error) {
+	tenants, err := h.tenants()
+	if err != nil {
+		return nil, err
+	}
+
+	img := selfTestImage()
+	dimensions := map[string]interface{}{}
+	for _, tenant := range tenants {
+		name := tenant.Namespace.Name
+		features, err := tenant.Extractor.ExtractFeatures(ctx, img)
+		if errors.Is(err, ErrExtractionOverloaded) {
+			// 提取繁忙不代表提取器故障，避免就绪状态在高负载时来回切换
+			return dimensions, skip(err.Error())
+		}
+		if err != nil {
+			return dimensions, fmt.Errorf("命名空间 %s 特征提取失败: %w", name, err)
+		}
+		if len(features) != tenant.Extractor.GetDimension() {
+			return dimensions, fmt.Errorf("命名空间 %s 特征维度为 %d，应为 %d", name, len(features), tenant.Extractor.GetDimension())
+		}
+
+		var norm float64
+		for _, v := range features {
+			if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+				return dimensions, fmt.Errorf("命名空间 %s 特征包含非法值", name)
+			}
+			norm += float64(v) * float64(v)
+		}
+		if norm == 0 {
+			return dimensions, fmt.Errorf("命名空间 %s 特征全为0", name)
+		}
+		dimensions[name] = len(features)
+	}
+	return dimensions, nil
+}
+
+// selfTestImage 生成带颜色渐变和边缘的合成图像，用于特征提取自检
+func selfTestImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, selfTestSize, selfTestSize))
+	for y := 0; y < selfTestSize; y++ {
+		for x := 0; x < selfTestSize; x++ {
+			c := color.RGBA{R: uint8(x * 4), G: uint8(y * 4), B: 128, A: 255}
+			if x > selfTestSize/2 && y > selfTestSize/2 {
+				c.B = 255
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
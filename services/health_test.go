@@ -0,0 +1,99 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"image"
+	"sync"
+	"testing"
+
+	"image-search-go/config"
+)
+
+// countingExtractor 记录调用次数的特征提取器，err不为nil时提取失败
+type countingExtractor struct {
+	mu    sync.Mutex
+	calls int
+	err   error
+}
+
+func (e *countingExtractor) ExtractFeatures(ctx context.Context, img image.Image) ([]float32, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.calls++
+	if e.err != nil {
+		return nil, e.err
+	}
+	return []float32{1, 0, 0, 0}, nil
+}
+
+func (e *countingExtractor) GetDimension() int {
+	return 4
+}
+
+func (e *countingExtractor) callCount() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.calls
+}
+
+func (e *countingExtractor) fail(err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.err = err
+}
+
+func TestExtractorCheckIsCached(t *testing.T) {
+	extractor := &countingExtractor{}
+	backend := &Backend{namespaces: &NamespaceManager{tenants: map[string]*Tenant{
+		DefaultNamespace: {Namespace: &Namespace{Name: DefaultNamespace}, Extractor: extractor},
+		"team_a":         {Namespace: &Namespace{Name: "team_a"}, Extractor: extractor},
+	}}}
+	health := NewHealthChecker(backend, &config.Config{})
+
+	for i := 0; i < 5; i++ {
+		report := health.Run(context.Background(), CheckExtractor)
+		if !report.OK() {
+			t.Fatalf("第 %d 次检查应通过: %+v", i+1, report.Checks[0])
+		}
+	}
+	if calls := extractor.callCount(); calls != 2 {
+		t.Fatalf("缓存期内只应为每个命名空间自检一次，实际提取 %d 次", calls)
+	}
+
+	// 缓存过期后重新自检，失败结果同样缓存
+	extractor.fail(errors.New("model unavailable"))
+	health.extractorResult.checkedAt = health.extractorResult.checkedAt.Add(-extractorCheckTTL)
+	for i := 0; i < 3; i++ {
+		report := health.Run(context.Background(), CheckExtractor)
+		if report.Checks[0].Status != CheckFailed {
+			t.Fatalf("提取失败时检查应失败，实际 %s", report.Checks[0].Status)
+		}
+	}
+	if calls := extractor.callCount(); calls != 3 {
+		t.Fatalf("过期后应重新自检一次，实际共提取 %d 次", calls)
+	}
+}
+
+func TestExtractorCheckDoesNotCacheOverload(t *testing.T) {
+	extractor := &countingExtractor{err: ErrExtractionOverloaded}
+	backend := &Backend{namespaces: &NamespaceManager{tenants: map[string]*Tenant{
+		DefaultNamespace: {Namespace: &Namespace{Name: DefaultNamespace}, Extractor: extractor},
+	}}}
+	health := NewHealthChecker(backend, &config.Config{})
+
+	report := health.Run(context.Background(), CheckExtractor)
+	if report.Checks[0].Status != CheckSkipped {
+		t.Fatalf("提取繁忙时应跳过，实际 %s", report.Checks[0].Status)
+	}
+
+	// 繁忙不缓存，下次检查重新自检
+	extractor.fail(nil)
+	report = health.Run(context.Background(), CheckExtractor)
+	if !report.OK() {
+		t.Fatalf("提取恢复后检查应通过: %+v", report.Checks[0])
+	}
+	if calls := extractor.callCount(); calls != 2 {
+		t.Fatalf("应自检2次，实际 %d 次", calls)
+	}
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// 混合搜索默认权重
+const (
+	DefaultVectorWeight = 0.7
+	DefaultTextWeight   = 0.3
+)
+
+// ScoreBreakdown 混合搜索分数构成
+type ScoreBreakdown struct {
+	Total            float32 `json:"total"`             // 加权后的总分
+	VectorSimilarity float32 `json:"vector_similarity"` // 向量相似度 [0,1]
+	TextScore        float32 `json:"text_score"`        // 归一化后的BM25分数 [0,1]
+	TextScoreRaw     float32 `json:"text_score_raw"`    // 原始BM25分数
+	VectorWeight     float32 `json:"vector_weight"`
+	TextWeight       float32 `json:"text_weight"`
+}
+
+// HybridQuery 混合搜索查询
+type HybridQuery struct {
+	Namespace    string
+	Vector       []float32
+	Text         string
+	TopK         int
+	VectorWeight float32
+	TextWeight   float32
+}
+
+// HybridSearch 混合搜索：按向量相似度和描述/标签BM25相关度的加权和排序。
+// 候选集为向量搜索结果和文本检索结果的并集；只由文本召回、不在向量候选中的图像向量相似度记为0。
+func HybridSearch(ctx context.Context, milvusService *MilvusService, textIndex *TextIndex, query *HybridQuery) ([]*SearchResult, error) {
+	if query.VectorWeight < 0 || query.TextWeight < 0 || query.VectorWeight+query.TextWeight == 0 {
+		return nil, fmt.Errorf("权重必须非负且不能同时为0")
+	}
+
+	// 多取一些候选，保证加权重排后仍有足够的结果
+	candidateK := query.TopK * 5
+	if candidateK < 100 {
+		candidateK = 100
+	}
+	if candidateK > MaxRangeResults {
+		candidateK = MaxRangeResults
+	}
+
+	vectorResults, err := milvusService.SearchSimilar(ctx, query.Vector, candidateK)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := map[string]*SearchResult{}
+	for _, result := range vectorResults {
+		if _, ok := candidates[result.ImageID]; !ok {
+			candidates[result.ImageID] = result
+		}
+	}
+	// 搜索固定了分区时，文本召回的图像无法确认所在分区，只使用向量候选
+	if !milvusService.scoped {
+		for _, match := range textIndex.Search(query.Text, query.Namespace, candidateK) {
+			if _, ok := candidates[match.ImageID]; !ok {
+				candidates[match.ImageID] = &SearchResult{ImageID: match.ImageID}
+			}
+		}
+	}
+
+	only := make(map[string]bool, len(candidates))
+	for imageID := range candidates {
+		only[imageID] = true
+	}
+	textScores := textIndex.Score(query.Text, only)
+
+	// BM25分数没有上界，按候选集中的最高分归一化到[0,1]
+	var maxTextScore float64
+	for _, score := range textScores {
+		if score > maxTextScore {
+			maxTextScore = score
+		}
+	}
+
+	results := make([]*SearchResult, 0, len(candidates))
+	for imageID, result := range candidates {
+		breakdown := &ScoreBreakdown{
+			VectorSimilarity: result.Score,
+			TextScoreRaw:     float32(textScores[imageID]),
+			VectorWeight:     query.VectorWeight,
+			TextWeight:       query.TextWeight,
+		}
+		if maxTextScore > 0 {
+			breakdown.TextScore = float32(textScores[imageID] / maxTextScore)
+		}
+		breakdown.Total = (query.VectorWeight*breakdown.VectorSimilarity + query.TextWeight*breakdown.TextScore) /
+			(query.VectorWeight + query.TextWeight)
+
+		result.Breakdown = breakdown
+		results = append(results, result)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Breakdown.Total != results[j].Breakdown.Total {
+			return results[i].Breakdown.Total > results[j].Breakdown.Total
+		}
+		return results[i].ImageID < results[j].ImageID
+	})
+	if len(results) > query.TopK {
+		results = results[:query.TopK]
+	}
+	return results, nil
+}
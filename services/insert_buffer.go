@@ -0,0 +1,335 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/milvus-io/milvus-sdk-go/v2/client"
+	"github.com/milvus-io/milvus-sdk-go/v2/entity"
+)
+
+// 一致性级别，对应Milvus的consistency level
+const (
+	ConsistencyStrong     = "strong"
+	ConsistencySession    = "session"
+	ConsistencyBounded    = "bounded"
+	ConsistencyEventually = "eventually"
+)
+
+// ParseConsistency 解析一致性级别，为空时返回ok为false（使用collection的默认级别）
+func ParseConsistency(value string) (level entity.ConsistencyLevel, ok bool, err error) {
+	switch strings.ToLower(value) {
+	case "":
+		return 0, false, nil
+	case ConsistencyStrong:
+		return entity.ClStrong, true, nil
+	case ConsistencySession:
+		return entity.ClSession, true, nil
+	case ConsistencyBounded:
+		return entity.ClBounded, true, nil
+	case ConsistencyEventually:
+		return entity.ClEventually, true, nil
+	}
+	return 0, false, fmt.Errorf("无效的一致性级别: %s（可选 strong, session, bounded, eventually）", value)
+}
+
+// ErrInsertOverloaded 写入缓冲已满（Milvus写入持续失败或跟不上上传速度），请求被拒绝
+var ErrInsertOverloaded = errors.New("向量写入繁忙，请稍后重试")
+
+// insertBuffer 写入缓冲：累积记录，达到条数上限或间隔时间后批量写入Milvus，
+// 避免每次上传都写入并刷新产生大量小segment。同一collection的服务实例共享。
+//
+// 记录加入缓冲后上传即返回成功，进程异常退出（崩溃、被强制终止）时缓冲中尚未写入的记录会丢失，
+// 对应的图像文件和元数据仍然存在，需用 reconcile -dry-run=false -reingest 重新入库
+type insertBuffer struct {
+	mu      sync.Mutex
+	records []*ImageRecord
+	// inflight 正在写入的记录，写入失败时放回缓冲，计入容量
+	inflight []*ImageRecord
+	// writeMu 保证批次按顺序写入，删除时也持有，避免删除先于正在写入的记录到达Milvus
+	writeMu sync.Mutex
+
+	size       int
+	maxPending int
+	interval   time.Duration
+	stop       chan struct{}
+	done       chan struct{}
+	closed     bool
+
+	flushedBatches int64
+	flushedRows    int64
+	lastFlush      time.Time
+	lastError      string
+}
+
+// bufferWriteTimeout 定时写入和关闭时写入缓冲记录的超时时间
+const bufferWriteTimeout = 30 * time.Second
+
+func newInsertBuffer(size, maxPending int, interval time.Duration) *insertBuffer {
+	if maxPending < size {
+		maxPending = size
+	}
+	return &insertBuffer{
+		size:       size,
+		maxPending: maxPending,
+		interval:   interval,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+// buffered 是否启用了写入缓冲
+func (s *MilvusService) buffered() bool {
+	return s.buffer != nil
+}
+
+// startBuffer 按配置创建写入缓冲并启动定时写入，缓冲条数不大于1时直接写入
+func (s *MilvusService) startBuffer() {
+	if s.config.InsertBufferSize <= 1 {
+		return
+	}
+	interval := s.config.InsertFlushInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	s.buffer = newInsertBuffer(s.config.InsertBufferSize, s.config.InsertBufferMaxPending, interval)
+	go s.flushLoop()
+}
+
+// flushLoop 定时写入缓冲中的记录
+func (s *MilvusService) flushLoop() {
+	defer close(s.buffer.done)
+
+	ticker := time.NewTicker(s.buffer.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.buffer.stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), bufferWriteTimeout)
+			if err := s.FlushBuffer(ctx); err != nil {
+				slog.Warn("定时写入缓冲记录失败，下次重试", "collection", s.collection, "error", err)
+			}
+			cancel()
+		}
+	}
+}
+
+// enqueue 把记录加入缓冲，达到条数上限时立即写入。
+// 缓冲中待写入的记录超过 maxPending 时返回 ErrInsertOverloaded；单次超过容量的批量写入直接写入
+func (s *MilvusService) enqueue(ctx context.Context, records []*ImageRecord) error {
+	b := s.buffer
+	b.mu.Lock()
+	if b.closed || len(records) > b.maxPending {
+		b.mu.Unlock()
+		return s.writeRecords(ctx, records)
+	}
+	if pending := len(b.records) + len(b.inflight); pending+len(records) > b.maxPending {
+		b.mu.Unlock()
+		return fmt.Errorf("%w: 待写入记录已达上限 %d", ErrInsertOverloaded, b.maxPending)
+	}
+	b.records = append(b.records, records...)
+	full := len(b.records) >= b.size
+	b.mu.Unlock()
+
+	if full {
+		return s.FlushBuffer(ctx)
+	}
+	return nil
+}
+
+// FlushBuffer 把缓冲中的记录写入Milvus（不触发Milvus的flush，数据写入后即可按一致性级别被搜索到）。
+// 写入失败的记录放回缓冲等待重试，写入期间这些记录仍计入缓冲容量，放回后不会超过 maxPending
+func (s *MilvusService) FlushBuffer(ctx context.Context) error {
+	if !s.buffered() {
+		return nil
+	}
+	b := s.buffer
+
+	b.writeMu.Lock()
+	defer b.writeMu.Unlock()
+
+	b.mu.Lock()
+	records := b.records
+	b.records = nil
+	b.inflight = records
+	b.mu.Unlock()
+	if len(records) == 0 {
+		return nil
+	}
+
+	err := s.writeRecords(ctx, records)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.inflight = nil
+	if err != nil {
+		b.records = append(records, b.records...)
+		b.lastError = err.Error()
+		return err
+	}
+	b.flushedBatches++
+	b.flushedRows += int64(len(records))
+	b.lastFlush = time.Now()
+	b.lastError = ""
+	return nil
+}
+
+// closeBuffer 停止定时写入。flush为true时写入剩余记录并刷新Milvus，否则丢弃（collection已删除时）
+func (s *MilvusService) closeBuffer(flush bool) {
+	if !s.buffered() {
+		return
+	}
+	b := s.buffer
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return
+	}
+	b.closed = true
+	b.mu.Unlock()
+
+	close(b.stop)
+	<-b.done
+
+	if !flush {
+		b.mu.Lock()
+		if len(b.records) > 0 {
+			slog.Warn("丢弃缓冲记录", "collection", s.collection, "records", len(b.records))
+		}
+		b.records = nil
+		b.mu.Unlock()
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), bufferWriteTimeout)
+	defer cancel()
+	if err := s.FlushBuffer(ctx); err != nil {
+		slog.Error("关闭时写入缓冲记录失败", "collection", s.collection, "error", err)
+		return
+	}
+	if err := s.client.Flush(ctx, s.collection, false); err != nil {
+		slog.Error("刷新数据失败", "collection", s.collection, "error", err)
+	}
+}
+
+// lockWrites 等待正在进行的批量写入完成并阻止新的写入，返回解锁函数。
+// 删除时持有，使删除在缓冲记录写入Milvus之后执行（或在写入前从缓冲中移除记录）
+func (s *MilvusService) lockWrites() (unlock func()) {
+	if !s.buffered() {
+		return func() {}
+	}
+	s.buffer.writeMu.Lock()
+	return s.buffer.writeMu.Unlock
+}
+
+// removeBuffered 从缓冲中删除图像的记录，调用方需持有 lockWrites，此时没有正在写入的记录
+func (s *MilvusService) removeBuffered(imageID string) {
+	if !s.buffered() {
+		return
+	}
+	b := s.buffer
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	kept := b.records[:0]
+	for _, record := range b.records {
+		if record.ImageID != imageID {
+			kept = append(kept, record)
+		}
+	}
+	b.records = kept
+}
+
+// getBuffered 在缓冲和正在写入的记录中查找图像的记录，不存在时返回nil
+func (s *MilvusService) getBuffered(imageID string) *ImageRecord {
+	if !s.buffered() {
+		return nil
+	}
+	b := s.buffer
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, records := range [][]*ImageRecord{b.records, b.inflight} {
+		for _, record := range records {
+			if record.ImageID == imageID {
+				return record
+			}
+		}
+	}
+	return nil
+}
+
+// pendingRows 缓冲中尚未写入的记录数
+func (s *MilvusService) pendingRows() int {
+	if !s.buffered() {
+		return 0
+	}
+	s.buffer.mu.Lock()
+	defer s.buffer.mu.Unlock()
+	return len(s.buffer.records)
+}
+
+// BufferStats 返回写入缓冲的状态
+func (s *MilvusService) BufferStats() map[string]interface{} {
+	if !s.buffered() {
+		return map[string]interface{}{"enabled": false}
+	}
+	b := s.buffer
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	stats := map[string]interface{}{
+		"enabled":         true,
+		"size":            b.size,
+		"max_pending":     b.maxPending,
+		"flush_interval":  b.interval.String(),
+		"pending_rows":    len(b.records),
+		"flushed_batches": b.flushedBatches,
+		"flushed_rows":    b.flushedRows,
+	}
+	if !b.lastFlush.IsZero() {
+		stats["last_flush"] = b.lastFlush
+	}
+	if b.lastError != "" {
+		stats["last_error"] = b.lastError
+	}
+	return stats
+}
+
+// WithConsistency 返回按指定一致性级别读写的服务实例，级别为空时返回自身。
+// strong和session级别在读写前先写入缓冲中的记录，保证读到自己的写入；写入时不经过缓冲
+func (s *MilvusService) WithConsistency(value string) (*MilvusService, error) {
+	level, ok, err := ParseConsistency(value)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return s, nil
+	}
+
+	scoped := *s
+	scoped.consistency = level
+	scoped.hasConsistency = true
+	return &scoped, nil
+}
+
+// readYourWrites 是否需要读到之前的写入
+func (s *MilvusService) readYourWrites() bool {
+	return s.hasConsistency && (s.consistency == entity.ClStrong || s.consistency == entity.ClSession)
+}
+
+// queryOptions 搜索和查询的选项
+func (s *MilvusService) queryOptions(opts ...client.SearchQueryOptionFunc) []client.SearchQueryOptionFunc {
+	if s.hasConsistency {
+		opts = append(opts, client.WithSearchQueryConsistencyLevel(s.consistency))
+	}
+	return opts
+}
@@ -0,0 +1,117 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestInsertBufferRejectsWhenFull(t *testing.T) {
+	cfg := testMilvusConfig()
+	cfg.InsertBufferSize = 2
+	cfg.InsertBufferMaxPending = 4
+	cfg.InsertFlushInterval = time.Hour
+	dialer := &fakeDialer{}
+	ctx := context.Background()
+	service, err := newMilvusService(ctx, cfg, dialer.dial)
+	if err != nil {
+		t.Fatalf("newMilvusService: %v", err)
+	}
+	defer service.Close()
+	conn := dialer.conn(0)
+
+	record := func(i int) []*ImageRecord {
+		return []*ImageRecord{{ImageID: fmt.Sprintf("img-%d", i), Vector: make([]float32, cfg.Dimension)}}
+	}
+
+	// Milvus写入持续失败：达到批次大小时写入失败，记录放回缓冲
+	insertErr := errors.New("insert rejected")
+	conn.script(insertErr, insertErr, insertErr)
+	for i := 0; i < 4; i++ {
+		err := service.InsertImages(ctx, record(i))
+		if i > 0 && !errors.Is(err, insertErr) {
+			t.Fatalf("第 %d 次插入应返回写入错误，实际 %v", i+1, err)
+		}
+	}
+	if n := service.pendingRows(); n != 4 {
+		t.Fatalf("缓冲中应有4条记录，实际 %d 条", n)
+	}
+
+	// 缓冲已满，拒绝新的写入，不再增长
+	if err := service.InsertImages(ctx, record(4)); !errors.Is(err, ErrInsertOverloaded) {
+		t.Fatalf("缓冲已满时应返回ErrInsertOverloaded，实际 %v", err)
+	}
+	if n := service.pendingRows(); n != 4 {
+		t.Errorf("拒绝后缓冲中应仍为4条记录，实际 %d 条", n)
+	}
+
+	// Milvus恢复后写入缓冲中的记录，可以继续写入
+	if err := service.FlushBuffer(ctx); err != nil {
+		t.Fatalf("Milvus恢复后写入应成功: %v", err)
+	}
+	if n := service.pendingRows(); n != 0 {
+		t.Errorf("写入后缓冲应为空，实际 %d 条", n)
+	}
+	conn.mu.Lock()
+	inserted := conn.inserted
+	conn.mu.Unlock()
+	if inserted != 4 {
+		t.Errorf("应写入4条记录，实际 %d 条", inserted)
+	}
+	if err := service.InsertImages(ctx, record(5)); err != nil {
+		t.Errorf("恢复后插入应成功: %v", err)
+	}
+}
+
+func TestDeleteWaitsForInflightFlush(t *testing.T) {
+	cfg := testMilvusConfig()
+	cfg.InsertBufferSize = 10
+	cfg.InsertFlushInterval = time.Hour
+	dialer := &fakeDialer{}
+	ctx := context.Background()
+	service, err := newMilvusService(ctx, cfg, dialer.dial)
+	if err != nil {
+		t.Fatalf("newMilvusService: %v", err)
+	}
+	defer service.Close()
+	conn := dialer.conn(0)
+
+	record := &ImageRecord{ImageID: "img-1", Vector: make([]float32, cfg.Dimension)}
+	if err := service.InsertImages(ctx, []*ImageRecord{record}); err != nil {
+		t.Fatalf("插入应进入缓冲: %v", err)
+	}
+
+	// 阻塞批量写入，使记录处于正在写入状态
+	started, gate := make(chan struct{}), make(chan struct{})
+	conn.mu.Lock()
+	conn.insertStarted, conn.insertGate = started, gate
+	conn.mu.Unlock()
+	flushed := make(chan error, 1)
+	go func() { flushed <- service.FlushBuffer(ctx) }()
+	<-started
+
+	if got, err := service.GetImage(ctx, "img-1"); err != nil || got == nil {
+		t.Fatalf("正在写入的记录应能查到，实际 %v, %v", got, err)
+	}
+
+	deleted := make(chan error, 1)
+	go func() { deleted <- service.DeleteVector(ctx, "img-1") }()
+	select {
+	case err := <-deleted:
+		t.Fatalf("删除应等待正在进行的写入完成，实际已返回 %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(gate)
+	if err := <-flushed; err != nil {
+		t.Fatalf("写入应成功: %v", err)
+	}
+	if err := <-deleted; err != nil {
+		t.Fatalf("删除应成功: %v", err)
+	}
+	if writes := conn.writeLog(); len(writes) != 2 || writes[0] != "insert" || writes[1] != "delete" {
+		t.Errorf("删除应在写入之后到达Milvus，实际顺序 %v", writes)
+	}
+}
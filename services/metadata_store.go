@@ -0,0 +1,486 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"image-search-go/auth"
+	"image-search-go/utils"
+
+	"github.com/google/uuid"
+	bolt "go.etcd.io/bbolt"
+)
+
+// imagesBucket 图像元数据bucket
+var imagesBucket = []byte("images")
+
+// ImageMetadata 图像元数据记录（除向量外的全部信息）
+type ImageMetadata struct {
+	ImageID          string          `json:"image_id"`
+	ObjectKey        string          `json:"object_key"`
+	MimeType         string          `json:"mime_type"`
+	FileSize         int64           `json:"file_size"`
+	Width            int             `json:"width"`
+	Height           int             `json:"height"`
+	Format           string          `json:"format"`
+	Namespace        string          `json:"namespace,omitempty"` // 为空表示默认命名空间
+	OriginalFilename string          `json:"original_filename,omitempty"`
+	Description      string          `json:"description,omitempty"`
+	Tags             []string        `json:"tags,omitempty"`
+	Category         string          `json:"category,omitempty"`
+	Exif             *utils.ExifData `json:"exif,omitempty"`
+	ClientIP         string          `json:"client_ip,omitempty"`
+	UploadedBy       string          `json:"uploaded_by,omitempty"`  // 上传者标识，如 api_key:ci、jwt:alice
+	QuotaCharge      *QuotaCharge    `json:"quota_charge,omitempty"` // 计入的每日配额用量，未启用配额时为空
+	CreatedAt        time.Time       `json:"created_at"`
+	UpdatedAt        time.Time       `json:"updated_at"`
+}
+
+// InNamespace 判断元数据是否属于指定命名空间
+func (meta *ImageMetadata) InNamespace(namespace string) bool {
+	return sameNamespace(meta.Namespace, namespace)
+}
+
+// sameNamespace 比较命名空间，空字符串等同于默认命名空间
+func sameNamespace(a, b string) bool {
+	if a == "" {
+		a = DefaultNamespace
+	}
+	if b == "" {
+		b = DefaultNamespace
+	}
+	return a == b
+}
+
+// MetadataStore 基于BoltDB的本地元数据存储，以image_id为key
+type MetadataStore struct {
+	db *bolt.DB
+	// textIndex 启用后随元数据写入和删除同步更新
+	textIndex *TextIndex
+}
+
+// NewMetadataStore 打开（或创建）元数据库文件
+func NewMetadataStore(path string) (*MetadataStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("创建元数据目录失败: %v", err)
+	}
+
+	// 数据库文件被其他进程占用时不无限等待
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 3 * time.Second})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("打开元数据库失败: %s 被其他进程锁定，运行中的服务会独占元数据库，请先停止服务", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("打开元数据库失败: %v", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{imagesBucket, idempotencyBucket, namespacesBucket, apiKeysBucket, dailyUsageBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("初始化元数据库失败: %v", err)
+	}
+
+	slog.Info("元数据库已打开", "path", path)
+	return &MetadataStore{db: db}, nil
+}
+
+// Put 写入或覆盖图像元数据
+func (m *MetadataStore) Put(meta *ImageMetadata) error {
+	now := time.Now()
+	if meta.CreatedAt.IsZero() {
+		meta.CreatedAt = now
+	}
+	meta.UpdatedAt = now
+
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("序列化元数据失败: %v", err)
+	}
+
+	err = m.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(imagesBucket).Put([]byte(meta.ImageID), data)
+	})
+	if err != nil {
+		return fmt.Errorf("写入元数据失败: %v", err)
+	}
+
+	if m.textIndex != nil {
+		m.textIndex.Index(meta)
+	}
+	return nil
+}
+
+// Get 查询单个图像元数据，不存在时返回nil
+func (m *MetadataStore) Get(imageID string) (*ImageMetadata, error) {
+	metas, err := m.GetMany([]string{imageID})
+	if err != nil {
+		return nil, err
+	}
+	return metas[imageID], nil
+}
+
+// GetMany 在一次读事务中批量查询元数据，返回以image_id为key的map
+func (m *MetadataStore) GetMany(imageIDs []string) (map[string]*ImageMetadata, error) {
+	metas := make(map[string]*ImageMetadata, len(imageIDs))
+
+	err := m.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(imagesBucket)
+		for _, imageID := range imageIDs {
+			data := bucket.Get([]byte(imageID))
+			if data == nil {
+				continue
+			}
+
+			meta := &ImageMetadata{}
+			if err := json.Unmarshal(data, meta); err != nil {
+				return fmt.Errorf("解析元数据 %s 失败: %v", imageID, err)
+			}
+			metas[imageID] = meta
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("查询元数据失败: %v", err)
+	}
+	return metas, nil
+}
+
+// Delete 删除图像元数据
+func (m *MetadataStore) Delete(imageID string) error {
+	err := m.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(imagesBucket).Delete([]byte(imageID))
+	})
+	if err != nil {
+		return fmt.Errorf("删除元数据失败: %v", err)
+	}
+
+	if m.textIndex != nil {
+		m.textIndex.Remove(imageID)
+	}
+	return nil
+}
+
+// ForEach 遍历全部图像元数据，fn返回错误时停止遍历
+func (m *MetadataStore) ForEach(fn func(meta *ImageMetadata) error) error {
+	return m.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(imagesBucket).ForEach(func(k, v []byte) error {
+			meta := &ImageMetadata{}
+			if err := json.Unmarshal(v, meta); err != nil {
+				return fmt.Errorf("解析元数据 %s 失败: %v", k, err)
+			}
+			return fn(meta)
+		})
+	})
+}
+
+// BuildTextIndex 用已有元数据构建文本索引，之后的写入和删除会同步更新索引
+func (m *MetadataStore) BuildTextIndex() (*TextIndex, error) {
+	index := NewTextIndex()
+	err := m.ForEach(func(meta *ImageMetadata) error {
+		index.Index(meta)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("构建文本索引失败: %v", err)
+	}
+
+	m.textIndex = index
+	return index, nil
+}
+
+// Count 返回元数据记录数
+func (m *MetadataStore) Count() (int, error) {
+	var count int
+	err := m.db.View(func(tx *bolt.Tx) error {
+		count = tx.Bucket(imagesBucket).Stats().KeyN
+		return nil
+	})
+	return count, err
+}
+
+// Close 关闭元数据库
+func (m *MetadataStore) Close() {
+	if err := m.db.Close(); err != nil {
+		slog.Error("关闭元数据库失败", "error", err)
+		return
+	}
+	slog.Info("元数据库已关闭")
+}
+
+// idempotencyBucket 上传幂等记录bucket
+var idempotencyBucket = []byte("idempotency")
+
+// 幂等记录状态
+const (
+	IdempotencyInProgress = "in_progress"
+	IdempotencyCompleted  = "completed"
+)
+
+// IdempotencyRecord 幂等键对应的上传记录
+type IdempotencyRecord struct {
+	Key    string          `json:"key"`
+	Status string          `json:"status"`
+	Result json.RawMessage `json:"result,omitempty"`
+	// Token 占用幂等键的上传的标识，进行中记录被接管后，原上传不能再完成或释放该键
+	Token     string    `json:"token,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// errIdempotencyTakenOver 进行中记录已过期并被其他上传接管
+var errIdempotencyTakenOver = errors.New("幂等键已被其他上传接管")
+
+// expired 记录是否已过期：进行中记录超过lease（上传进程可能已崩溃），已完成记录超过ttl
+func (r *IdempotencyRecord) expired(ttl, lease time.Duration) bool {
+	if r.Status == IdempotencyInProgress {
+		return time.Since(r.CreatedAt) >= lease
+	}
+	return time.Since(r.CreatedAt) >= ttl
+}
+
+// BeginIdempotent 占用幂等键。键已存在且未过期时返回已有记录，否则写入进行中记录并返回占用标识token。
+// 进行中记录只保留lease，超过后视为上传已中断，允许重试接管
+func (m *MetadataStore) BeginIdempotent(key string, ttl, lease time.Duration) (existing *IdempotencyRecord, token string, err error) {
+	token = uuid.New().String()
+	err = m.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(idempotencyBucket)
+		if data := bucket.Get([]byte(key)); data != nil {
+			record := &IdempotencyRecord{}
+			if err := json.Unmarshal(data, record); err != nil {
+				return fmt.Errorf("解析幂等记录失败: %v", err)
+			}
+			if !record.expired(ttl, lease) {
+				existing = record
+				return nil
+			}
+		}
+
+		data, err := json.Marshal(&IdempotencyRecord{
+			Key:       key,
+			Status:    IdempotencyInProgress,
+			Token:     token,
+			CreatedAt: time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(key), data)
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("写入幂等记录失败: %v", err)
+	}
+	if existing != nil {
+		return existing, "", nil
+	}
+	return nil, token, nil
+}
+
+// CompleteIdempotent 记录幂等键对应的上传结果，键已被其他上传接管时返回错误
+func (m *MetadataStore) CompleteIdempotent(key, token string, result interface{}) error {
+	resultData, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("序列化上传结果失败: %v", err)
+	}
+
+	data, err := json.Marshal(&IdempotencyRecord{
+		Key:       key,
+		Status:    IdempotencyCompleted,
+		Result:    resultData,
+		Token:     token,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return m.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(idempotencyBucket)
+		if !ownsIdempotent(bucket, key, token) {
+			return errIdempotencyTakenOver
+		}
+		return bucket.Put([]byte(key), data)
+	})
+}
+
+// ReleaseIdempotent 上传失败时释放幂等键，允许客户端重试。键已被其他上传接管时不做处理
+func (m *MetadataStore) ReleaseIdempotent(key, token string) error {
+	return m.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(idempotencyBucket)
+		if !ownsIdempotent(bucket, key, token) {
+			return nil
+		}
+		return bucket.Delete([]byte(key))
+	})
+}
+
+// ownsIdempotent 幂等键当前是否由token对应的上传占用
+func ownsIdempotent(bucket *bolt.Bucket, key, token string) bool {
+	data := bucket.Get([]byte(key))
+	if data == nil {
+		return false
+	}
+	record := &IdempotencyRecord{}
+	return json.Unmarshal(data, record) == nil && record.Token == token
+}
+
+// PruneIdempotent 删除已过期的幂等记录，返回删除的条数
+func (m *MetadataStore) PruneIdempotent(ttl, lease time.Duration) (int, error) {
+	pruned := 0
+	err := m.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket(idempotencyBucket).Cursor()
+		for k, v := c.First(); k != nil; {
+			record := &IdempotencyRecord{}
+			if json.Unmarshal(v, record) != nil || record.expired(ttl, lease) {
+				// 删除后游标指向下一条
+				if err := c.Delete(); err != nil {
+					return err
+				}
+				pruned++
+				k, v = c.Seek(k)
+				continue
+			}
+			k, v = c.Next()
+		}
+		return nil
+	})
+	return pruned, err
+}
+
+// StartIdempotencySweep 立即并每隔interval清理一次过期的幂等记录，返回停止函数
+func (m *MetadataStore) StartIdempotencySweep(ttl, lease, interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	sweep := func() {
+		if pruned, err := m.PruneIdempotent(ttl, lease); err != nil {
+			slog.Warn("清理过期幂等记录失败", "error", err)
+		} else if pruned > 0 {
+			slog.Info("已清理过期幂等记录", "count", pruned)
+		}
+	}
+
+	sweep()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				sweep()
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// namespacesBucket 命名空间配置bucket
+var namespacesBucket = []byte("namespaces")
+
+// PutNamespace 写入命名空间配置
+func (m *MetadataStore) PutNamespace(ns *Namespace) error {
+	data, err := json.Marshal(ns)
+	if err != nil {
+		return fmt.Errorf("序列化命名空间失败: %v", err)
+	}
+
+	err = m.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(namespacesBucket).Put([]byte(ns.Name), data)
+	})
+	if err != nil {
+		return fmt.Errorf("写入命名空间失败: %v", err)
+	}
+	return nil
+}
+
+// DeleteNamespace 删除命名空间配置
+func (m *MetadataStore) DeleteNamespace(name string) error {
+	err := m.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(namespacesBucket).Delete([]byte(name))
+	})
+	if err != nil {
+		return fmt.Errorf("删除命名空间失败: %v", err)
+	}
+	return nil
+}
+
+// ListNamespaces 返回全部已创建的命名空间配置
+func (m *MetadataStore) ListNamespaces() ([]*Namespace, error) {
+	var namespaces []*Namespace
+	err := m.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(namespacesBucket).ForEach(func(k, v []byte) error {
+			ns := &Namespace{}
+			if err := json.Unmarshal(v, ns); err != nil {
+				return fmt.Errorf("解析命名空间 %s 失败: %v", k, err)
+			}
+			namespaces = append(namespaces, ns)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("读取命名空间失败: %v", err)
+	}
+	return namespaces, nil
+}
+
+// apiKeysBucket 通过接口创建的API key（只保存哈希）
+var apiKeysBucket = []byte("api_keys")
+
+// PutAPIKey 写入API key
+func (m *MetadataStore) PutAPIKey(key *auth.APIKey) error {
+	data, err := json.Marshal(key)
+	if err != nil {
+		return fmt.Errorf("序列化API key失败: %v", err)
+	}
+
+	err = m.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(apiKeysBucket).Put([]byte(key.Name), data)
+	})
+	if err != nil {
+		return fmt.Errorf("写入API key失败: %v", err)
+	}
+	return nil
+}
+
+// DeleteAPIKey 删除API key
+func (m *MetadataStore) DeleteAPIKey(name string) error {
+	err := m.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(apiKeysBucket).Delete([]byte(name))
+	})
+	if err != nil {
+		return fmt.Errorf("删除API key失败: %v", err)
+	}
+	return nil
+}
+
+// ListAPIKeys 返回全部通过接口创建的API key
+func (m *MetadataStore) ListAPIKeys() ([]*auth.APIKey, error) {
+	var keys []*auth.APIKey
+	err := m.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(apiKeysBucket).ForEach(func(k, v []byte) error {
+			key := &auth.APIKey{}
+			if err := json.Unmarshal(v, key); err != nil {
+				return fmt.Errorf("解析API key %s 失败: %v", k, err)
+			}
+			keys = append(keys, key)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("读取API key失败: %v", err)
+	}
+	return keys, nil
+}
@@ -0,0 +1,312 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+
+	"image-search-go/config"
+
+	"github.com/milvus-io/milvus-sdk-go/v2/client"
+	"github.com/milvus-io/milvus-sdk-go/v2/entity"
+)
+
+// MigrationPlan 结构迁移计划
+type MigrationPlan struct {
+	Collection     string      `json:"collection"` // 服务使用的名称（collection名或别名）
+	Physical       string      `json:"physical"`   // 当前实际的collection
+	Target         string      `json:"target"`     // 迁移后的collection
+	CurrentVersion int         `json:"current_version"`
+	TargetVersion  int         `json:"target_version"`
+	Diff           *SchemaDiff `json:"diff"`
+	RowCount       int64       `json:"row_count"`
+	Partitions     []string    `json:"partitions"`
+	Steps          []string    `json:"steps,omitempty"`
+	UpToDate       bool        `json:"up_to_date"`
+	Blocked        string      `json:"blocked,omitempty"` // 无法自动迁移的原因
+}
+
+// Migrator collection结构迁移：把数据复制到新结构的collection，再把别名切换过去
+type Migrator struct {
+	client *resilientClient
+	config *config.MilvusConfig
+	// Backfill 复制每批记录前调用，用于补充旧结构中没有的字段（如从元数据库补充文件信息）
+	Backfill func(records []*ImageRecord)
+}
+
+// NewMigrator 连接Milvus并创建迁移器
+func NewMigrator(ctx context.Context, cfg *config.MilvusConfig) (*Migrator, error) {
+	milvusClient, err := newResilientClient(ctx, cfg, dialMilvus(cfg))
+	if err != nil {
+		return nil, fmt.Errorf("连接Milvus失败: %v", err)
+	}
+	return &Migrator{client: milvusClient, config: cfg}, nil
+}
+
+// Close 关闭连接
+func (m *Migrator) Close() {
+	m.client.Close()
+}
+
+// Plan 比较现有collection与最新结构，生成迁移计划
+func (m *Migrator) Plan(ctx context.Context) (*MigrationPlan, error) {
+	name := m.config.CollectionName
+
+	has, err := m.client.HasCollection(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("检查collection失败: %v", err)
+	}
+	if !has {
+		return nil, fmt.Errorf("collection %s 不存在，启动服务时会按最新结构创建", name)
+	}
+
+	coll, err := m.client.DescribeCollection(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("获取collection结构失败: %v", err)
+	}
+
+	diff := CompareSchema(coll.Schema, ExpectedSchema(name, m.config.Dimension))
+	plan := &MigrationPlan{
+		Collection:     name,
+		Physical:       coll.Name,
+		Target:         fmt.Sprintf("%s_v%d", name, LatestSchemaVersion),
+		CurrentVersion: diff.Version,
+		TargetVersion:  LatestSchemaVersion,
+		Diff:           diff,
+		UpToDate:       diff.UpToDate(),
+	}
+
+	stats, err := m.client.GetCollectionStatistics(ctx, coll.Name)
+	if err != nil {
+		return nil, fmt.Errorf("获取统计信息失败: %v", err)
+	}
+	plan.RowCount, _ = strconv.ParseInt(stats["row_count"], 10, 64)
+
+	partitions, err := m.client.ShowPartitions(ctx, coll.Name)
+	if err != nil {
+		return nil, fmt.Errorf("获取分区列表失败: %v", err)
+	}
+	for _, partition := range partitions {
+		plan.Partitions = append(plan.Partitions, partition.Name)
+	}
+	sort.Strings(plan.Partitions)
+
+	switch {
+	case plan.UpToDate:
+		return plan, nil
+	case diff.Dimension != diff.ExpectedDimension:
+		plan.Blocked = fmt.Sprintf("向量维度从 %d 变为 %d，需要重新提取全部图像特征，无法通过复制数据迁移", diff.Dimension, diff.ExpectedDimension)
+		return plan, nil
+	case len(diff.TypeMismatches) > 0:
+		plan.Blocked = "字段类型不一致，无法自动转换: " + diff.String()
+		return plan, nil
+	case diff.Version < 1:
+		plan.Blocked = "collection缺少基础字段，不是本服务创建的collection"
+		return plan, nil
+	case plan.Physical == plan.Target:
+		plan.Blocked = fmt.Sprintf("目标collection %s 正在使用中", plan.Target)
+		return plan, nil
+	}
+
+	for _, migration := range schemaMigrations {
+		if migration.Version > diff.Version {
+			plan.Steps = append(plan.Steps, fmt.Sprintf("v%d: %s（%v）", migration.Version, migration.Description, migration.Fields))
+		}
+	}
+	plan.Steps = append(plan.Steps,
+		fmt.Sprintf("按最新结构创建 %s 并建立索引", plan.Target),
+		fmt.Sprintf("按分区复制 %d 条记录（%d 个分区）", plan.RowCount, len(plan.Partitions)),
+	)
+	if plan.Physical == name {
+		plan.Steps = append(plan.Steps, fmt.Sprintf("将 %s 重命名为 %s_v%d，并创建别名 %s -> %s", name, name, diff.Version, name, plan.Target))
+	} else {
+		plan.Steps = append(plan.Steps, fmt.Sprintf("将别名 %s 切换到 %s", name, plan.Target))
+	}
+	plan.Steps = append(plan.Steps, "保留旧collection以便回滚（-drop-old 时删除）", "重启服务以使用新结构")
+	return plan, nil
+}
+
+// Apply 执行迁移计划，dropOld为true时切换后删除旧collection。progress在每复制一批记录后调用。
+// 复制期间不能有写入，调用方需确保服务已停止
+func (m *Migrator) Apply(ctx context.Context, plan *MigrationPlan, batchSize int, dropOld bool, progress func(copied int64)) error {
+	if plan.UpToDate {
+		return nil
+	}
+	if plan.Blocked != "" {
+		return fmt.Errorf("无法迁移: %s", plan.Blocked)
+	}
+
+	// 上次失败留下的目标collection没有被别名引用，删除后重新复制
+	if has, err := m.client.HasCollection(ctx, plan.Target); err != nil {
+		return fmt.Errorf("检查collection失败: %v", err)
+	} else if has {
+		slog.InfoContext(ctx, "删除上次未完成迁移留下的collection", "collection", plan.Target)
+		if err := m.client.DropCollection(ctx, plan.Target); err != nil {
+			return fmt.Errorf("删除collection %s 失败: %v", plan.Target, err)
+		}
+	}
+
+	// 按最新结构创建目标collection（全部加载，不限制分区）
+	targetConfig := *m.config
+	targetConfig.CollectionName = plan.Target
+	targetConfig.MaxLoadedPartitions = 0
+	target := &MilvusService{
+		client:         m.client,
+		config:         &targetConfig,
+		collection:     plan.Target,
+		sharedClient:   true,
+		partitionState: newPartitionState(),
+	}
+	if err := target.initCollection(ctx); err != nil {
+		return fmt.Errorf("创建collection %s 失败: %v", plan.Target, err)
+	}
+
+	// 按分区复制数据
+	if err := m.client.LoadCollection(ctx, plan.Physical, false); err != nil {
+		return fmt.Errorf("加载collection %s 失败: %v", plan.Physical, err)
+	}
+	var copied int64
+	for _, partition := range plan.Partitions {
+		if err := m.copyPartition(ctx, plan, partition, target, batchSize, func(n int) {
+			copied += int64(n)
+			if progress != nil {
+				progress(copied)
+			}
+		}); err != nil {
+			return err
+		}
+	}
+	if err := m.client.Flush(ctx, plan.Target, false); err != nil {
+		return fmt.Errorf("刷新collection %s 失败: %v", plan.Target, err)
+	}
+
+	// 旧collection的实际行数与新collection一致后才切换。两边都按强一致性统计，
+	// 迁移期间服务已停止，能发现复制过程中遗漏的记录
+	sourceCount, err := m.liveCount(ctx, plan.Physical)
+	if err != nil {
+		return err
+	}
+	targetCount, err := m.liveCount(ctx, plan.Target)
+	if err != nil {
+		return err
+	}
+	if sourceCount != targetCount {
+		return fmt.Errorf("复制后行数不一致：%s 中有 %d 条，%s 中有 %d 条（本次复制 %d 条），旧collection未改动",
+			plan.Physical, sourceCount, plan.Target, targetCount, copied)
+	}
+
+	oldName := plan.Physical
+	if plan.Physical == plan.Collection {
+		// 旧collection直接使用服务名称，先重命名腾出名称再创建别名
+		oldName = fmt.Sprintf("%s_v%d", plan.Collection, plan.CurrentVersion)
+		if err := m.client.ReleaseCollection(ctx, plan.Physical); err != nil {
+			return fmt.Errorf("释放collection %s 失败: %v", plan.Physical, err)
+		}
+		if err := m.client.RenameCollection(ctx, plan.Physical, oldName); err != nil {
+			return fmt.Errorf("重命名collection %s 失败: %v", plan.Physical, err)
+		}
+		if err := m.client.CreateAlias(ctx, plan.Target, plan.Collection); err != nil {
+			return fmt.Errorf("创建别名 %s 失败（旧数据在 %s 中）: %v", plan.Collection, oldName, err)
+		}
+	} else if err := m.client.AlterAlias(ctx, plan.Target, plan.Collection); err != nil {
+		return fmt.Errorf("切换别名 %s 失败: %v", plan.Collection, err)
+	}
+	slog.InfoContext(ctx, "别名已切换", "alias", plan.Collection, "collection", plan.Target)
+
+	if !dropOld {
+		slog.InfoContext(ctx, "旧collection已保留，确认无误后可手动删除", "collection", oldName)
+		return nil
+	}
+	if err := m.client.DropCollection(ctx, oldName); err != nil {
+		return fmt.Errorf("删除旧collection %s 失败: %v", oldName, err)
+	}
+	slog.InfoContext(ctx, "旧collection已删除", "collection", oldName)
+	return nil
+}
+
+// liveCount 按强一致性统计collection中未删除的记录数
+func (m *Migrator) liveCount(ctx context.Context, collection string) (int64, error) {
+	resultSet, err := m.client.Query(ctx, collection, nil, "", []string{"count(*)"},
+		client.WithSearchQueryConsistencyLevel(entity.ClStrong))
+	if err != nil {
+		return 0, fmt.Errorf("统计collection %s 行数失败: %v", collection, err)
+	}
+	column := resultSet.GetColumn("count(*)")
+	if column == nil || column.Len() == 0 {
+		return 0, fmt.Errorf("统计collection %s 行数失败: 结果中没有count(*)", collection)
+	}
+	return column.GetAsInt64(0)
+}
+
+// copyPartition 以主键为游标分批复制一个分区的数据
+func (m *Migrator) copyPartition(ctx context.Context, plan *MigrationPlan, partition string, target *MilvusService, batchSize int, done func(n int)) error {
+	fields := []string{"image_id", "vector", "timestamp"}
+	for _, name := range []string{fieldObjectKey, fieldMimeType, fieldFileSize} {
+		if !contains(plan.Diff.MissingFields, name) {
+			fields = append(fields, name)
+		}
+	}
+
+	if partition != defaultPartition {
+		if err := target.ensurePartition(ctx, partition); err != nil {
+			return err
+		}
+	}
+
+	var lastID int64 = -1
+	for {
+		expr := fmt.Sprintf("id > %d", lastID)
+		resultSet, err := m.client.Query(ctx, plan.Physical, []string{partition}, expr, fields, client.WithLimit(int64(batchSize)))
+		if err != nil {
+			return fmt.Errorf("读取分区 %s 失败: %v", partition, err)
+		}
+
+		idColumn := resultSet.GetColumn("id")
+		if idColumn == nil || idColumn.Len() == 0 {
+			return nil
+		}
+
+		records := make([]*ImageRecord, idColumn.Len())
+		for i := range records {
+			record := &ImageRecord{}
+			id, _ := idColumn.GetAsInt64(i)
+			if id > lastID {
+				lastID = id
+			}
+			record.ImageID, _ = resultSet.GetColumn("image_id").GetAsString(i)
+			record.Timestamp, _ = resultSet.GetColumn("timestamp").GetAsInt64(i)
+			if column, ok := resultSet.GetColumn("vector").(*entity.ColumnFloatVector); ok {
+				record.Vector = column.Data()[i]
+			}
+
+			result := &SearchResult{}
+			fillFileFields(result, resultSet, i)
+			record.ObjectKey, record.MimeType, record.FileSize = result.ObjectKey, result.MimeType, result.FileSize
+			records[i] = record
+		}
+
+		if m.Backfill != nil {
+			m.Backfill(records)
+		}
+		if err := target.insert(ctx, partition, records); err != nil {
+			return fmt.Errorf("写入分区 %s 失败: %v", partition, err)
+		}
+		done(len(records))
+
+		if len(records) < batchSize {
+			return nil
+		}
+	}
+}
+
+// contains 判断字符串是否在列表中
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
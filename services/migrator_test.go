@@ -0,0 +1,30 @@
+package services
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMigratorLiveCount(t *testing.T) {
+	dialer := &fakeDialer{}
+	ctx := context.Background()
+	milvusClient, err := newResilientClient(ctx, testMilvusConfig(), dialer.dial)
+	if err != nil {
+		t.Fatalf("newResilientClient: %v", err)
+	}
+	defer milvusClient.Close()
+	migrator := &Migrator{client: milvusClient, config: testMilvusConfig()}
+
+	conn := dialer.conn(0)
+	conn.mu.Lock()
+	conn.rowCounts = map[string]int64{"test_images": 42}
+	conn.mu.Unlock()
+
+	count, err := migrator.liveCount(ctx, "test_images")
+	if err != nil {
+		t.Fatalf("liveCount: %v", err)
+	}
+	if count != 42 {
+		t.Errorf("应统计到42条记录，实际 %d 条", count)
+	}
+}
@@ -2,11 +2,15 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"strconv"
+	"strings"
 	"time"
 
 	"image-search-go/config"
+	"image-search-go/metrics"
 
 	"github.com/milvus-io/milvus-sdk-go/v2/client"
 	"github.com/milvus-io/milvus-sdk-go/v2/entity"
@@ -14,275 +18,707 @@ import (
 
 // MilvusService Milvus向量数据库服务
 type MilvusService struct {
-	client     client.Client
+	// client 带重试、熔断和自动重连的连接
+	client     *resilientClient
 	config     *config.MilvusConfig
 	collection string
+	// hasFileFields 集合是否包含文件信息字段（旧集合没有这些字段）
+	hasFileFields bool
+	// sharedClient 为true时连接由其他实例持有，Close不关闭连接
+	sharedClient bool
+	// partitionState 分区状态，同一collection的实例共享
+	partitionState *partitionState
+	// scoped 为true时只在partitions中搜索（见WithPartitionFilter）
+	scoped     bool
+	partitions []string
+	// buffer 写入缓冲，为nil时直接写入（见InsertBufferSize）
+	buffer *insertBuffer
+	// consistency 读写的一致性级别（见WithConsistency），hasConsistency为false时使用collection的默认级别
+	consistency    entity.ConsistencyLevel
+	hasConsistency bool
+}
+
+// 支持的索引类型
+const (
+	IndexIVFFlat = "IVF_FLAT"
+	IndexHNSW    = "HNSW"
+	IndexFlat    = "FLAT"
+)
+
+// 文件信息字段
+const (
+	fieldObjectKey = "object_key"
+	fieldMimeType  = "mime_type"
+	fieldFileSize  = "file_size"
+)
+
+// ImageRecord 图像向量记录
+type ImageRecord struct {
+	ID        int64 // 主键，插入时由Milvus自动生成
+	ImageID   string
+	Vector    []float32
+	ObjectKey string // 图像文件在存储中的key
+	MimeType  string
+	FileSize  int64
+	Timestamp int64  // 插入时为0则使用当前时间
+	Category  string // 按分类分区时决定写入的分区，不存储在collection中
 }
 
 // SearchResult 搜索结果结构
 type SearchResult struct {
 	ID       int64   `json:"id"`
-	Score    float32 `json:"score"`
+	Score    float32 `json:"score"` // 相似度，[0,1]区间，越大越相似
 	ImageID  string  `json:"image_id"`
-	Distance float32 `json:"distance"`
+	Distance float32 `json:"distance"`  // 距离，越小越相似
+	RawScore float32 `json:"raw_score"` // Milvus返回的原始分数，含义取决于度量类型
+	// FusionScore 多向量融合查询的融合分数，越大越相关
+	FusionScore float32 `json:"fusion_score,omitempty"`
+	// Breakdown 混合搜索的分数构成
+	Breakdown *ScoreBreakdown `json:"score_breakdown,omitempty"`
+	ObjectKey string          `json:"object_key,omitempty"`
+	MimeType  string          `json:"mime_type,omitempty"`
+	FileSize  int64           `json:"file_size,omitempty"`
 }
 
 // NewMilvusService 创建Milvus服务实例
-func NewMilvusService(cfg *config.MilvusConfig) (*MilvusService, error) {
+func NewMilvusService(ctx context.Context, cfg *config.MilvusConfig) (*MilvusService, error) {
+	return newMilvusService(ctx, cfg, dialMilvus(cfg))
+}
+
+// newMilvusService 使用指定的连接方式创建服务实例（可传入注入故障的客户端）
+func newMilvusService(ctx context.Context, cfg *config.MilvusConfig, dial func(ctx context.Context) (milvusClient, error)) (*MilvusService, error) {
+	if !ValidPartitionBy(cfg.PartitionBy) {
+		return nil, fmt.Errorf("不支持的分区方式: %s", cfg.PartitionBy)
+	}
+
 	// 连接到Milvus
-	milvusClient, err := client.NewClient(context.Background(), client.Config{
-		Address: fmt.Sprintf("%s:%s", cfg.Host, cfg.Port),
-	})
+	milvusClient, err := newResilientClient(ctx, cfg, dial)
 	if err != nil {
-		return nil, fmt.Errorf("连接Milvus失败: %v", err)
+		return nil, fmt.Errorf("连接Milvus失败: %w", err)
 	}
 
 	service := &MilvusService{
-		client:     milvusClient,
-		config:     cfg,
-		collection: cfg.CollectionName,
+		client:         milvusClient,
+		config:         cfg,
+		collection:     cfg.CollectionName,
+		partitionState: newPartitionState(),
 	}
 
 	// 初始化collection
-	if err := service.initCollection(); err != nil {
-		return nil, fmt.Errorf("初始化collection失败: %v", err)
+	if err := service.initCollection(ctx); err != nil {
+		milvusClient.Close()
+		return nil, fmt.Errorf("初始化collection失败: %w", err)
 	}
+	service.startBuffer()
 
-	log.Printf("成功连接到Milvus，collection: %s", cfg.CollectionName)
+	slog.InfoContext(ctx, "成功连接到Milvus", "collection", cfg.CollectionName)
 	return service, nil
 }
 
-// initCollection 初始化collection
-func (s *MilvusService) initCollection() error {
-	ctx := context.Background()
+// WithCollection 复用当前连接，创建操作另一个collection的服务实例（不存在时创建）
+func (s *MilvusService) WithCollection(ctx context.Context, cfg *config.MilvusConfig) (*MilvusService, error) {
+	service := &MilvusService{
+		client:         s.client,
+		config:         cfg,
+		collection:     cfg.CollectionName,
+		sharedClient:   true,
+		partitionState: newPartitionState(),
+	}
+
+	if err := service.initCollection(ctx); err != nil {
+		return nil, fmt.Errorf("初始化collection %s 失败: %w", cfg.CollectionName, err)
+	}
+	service.startBuffer()
+	return service, nil
+}
+
+// DropCollection 删除collection及其全部向量。名称是别名（迁移后）时同时删除别名和实际的collection
+func (s *MilvusService) DropCollection(ctx context.Context) error {
+	coll, err := s.client.DescribeCollection(ctx, s.collection)
+	if err != nil {
+		return fmt.Errorf("获取collection %s 失败: %w", s.collection, err)
+	}
+	if coll.Name != s.collection {
+		if err := s.client.DropAlias(ctx, s.collection); err != nil {
+			return fmt.Errorf("删除别名 %s 失败: %w", s.collection, err)
+		}
+	}
 
+	if err := s.client.DropCollection(ctx, coll.Name); err != nil {
+		return fmt.Errorf("删除collection %s 失败: %w", coll.Name, err)
+	}
+
+	slog.InfoContext(ctx, "Collection已删除", "collection", s.collection)
+	return nil
+}
+
+// Recreate 删除collection后按配置重新创建collection和索引
+func (s *MilvusService) Recreate(ctx context.Context) error {
+	if err := s.DropCollection(ctx); err != nil {
+		return err
+	}
+	s.partitionState = newPartitionState()
+	return s.initCollection(ctx)
+}
+
+// initCollection 初始化collection
+func (s *MilvusService) initCollection(ctx context.Context) error {
 	// 检查collection是否存在
 	hasCollection, err := s.client.HasCollection(ctx, s.collection)
 	if err != nil {
-		return fmt.Errorf("检查collection失败: %v", err)
+		return fmt.Errorf("检查collection失败: %w", err)
 	}
 
 	if hasCollection {
-		log.Printf("Collection %s 已存在", s.collection)
-		return s.loadCollection()
+		slog.InfoContext(ctx, "Collection已存在", "collection", s.collection)
+		if err := s.checkSchema(ctx); err != nil {
+			return err
+		}
+		return s.loadCollection(ctx)
 	}
 
 	// 创建collection
-	log.Printf("创建新的collection: %s", s.collection)
+	slog.InfoContext(ctx, "创建新的collection", "collection", s.collection)
 
 	// 定义字段
-	schema := &entity.Schema{
-		CollectionName: s.collection,
-		Description:    "图像特征向量存储",
-		Fields: []*entity.Field{
-			{
-				Name:       "id",
-				DataType:   entity.FieldTypeInt64,
-				PrimaryKey: true,
-				AutoID:     true,
-			},
-			{
-				Name:     "image_id",
-				DataType: entity.FieldTypeVarChar,
-				TypeParams: map[string]string{
-					"max_length": "255",
-				},
-			},
-			{
-				Name:     "vector",
-				DataType: entity.FieldTypeFloatVector,
-				TypeParams: map[string]string{
-					"dim": fmt.Sprintf("%d", s.config.Dimension),
-				},
-			},
-			{
-				Name:     "timestamp",
-				DataType: entity.FieldTypeInt64,
-			},
-		},
-	}
+	schema := ExpectedSchema(s.collection, s.config.Dimension)
 
 	// 创建collection
 	err = s.client.CreateCollection(ctx, schema, entity.DefaultShardNumber)
 	if err != nil {
-		return fmt.Errorf("创建collection失败: %v", err)
+		return fmt.Errorf("创建collection失败: %w", err)
 	}
+	s.hasFileFields = true
 
 	// 创建索引
-	return s.createIndex()
+	return s.createIndex(ctx)
 }
 
 // createIndex 创建向量索引
-func (s *MilvusService) createIndex() error {
-	ctx := context.Background()
-
+func (s *MilvusService) createIndex(ctx context.Context) error {
 	// 定义索引参数
 	indexParams := map[string]string{
 		"index_type":  s.config.IndexType,
 		"metric_type": s.config.MetricType,
-		"params":      `{"nlist": 128}`, // IVF_FLAT参数
+	}
+	switch s.config.IndexType {
+	case IndexHNSW:
+		indexParams["params"] = `{"M": 16, "efConstruction": 200}`
+	case IndexFlat:
+	default:
+		indexParams["params"] = `{"nlist": 128}` // IVF_FLAT参数
 	}
 
 	// 创建索引
 	idx := entity.NewGenericIndex("vector_index", entity.IndexType(s.config.IndexType), indexParams)
 	err := s.client.CreateIndex(ctx, s.collection, "vector", idx, false)
 	if err != nil {
-		return fmt.Errorf("创建索引失败: %v", err)
+		return fmt.Errorf("创建索引失败: %w", err)
 	}
 
-	log.Printf("索引创建成功")
-	return s.loadCollection()
+	slog.InfoContext(ctx, "索引创建成功", "collection", s.collection)
+	return s.loadCollection(ctx)
 }
 
 // loadCollection 加载collection到内存
-func (s *MilvusService) loadCollection() error {
-	ctx := context.Background()
+func (s *MilvusService) loadCollection(ctx context.Context) error {
+	// 限制加载分区数时只加载最近的分区，其余在搜索固定到它们时按需加载
+	if s.limitedLoading() {
+		return s.loadRecentPartitions(ctx)
+	}
 
 	err := s.client.LoadCollection(ctx, s.collection, false)
 	if err != nil {
-		return fmt.Errorf("加载collection失败: %v", err)
+		return fmt.Errorf("加载collection失败: %w", err)
+	}
+
+	if s.partitioned() {
+		if err := s.refreshPartitions(ctx); err != nil {
+			return err
+		}
 	}
 
-	log.Printf("Collection %s 加载成功", s.collection)
+	slog.InfoContext(ctx, "Collection加载成功", "collection", s.collection)
 	return nil
 }
 
-// InsertVectors 插入向量数据
-func (s *MilvusService) InsertVectors(imageIDs []string, vectors [][]float32) error {
-	if len(imageIDs) != len(vectors) {
-		return fmt.Errorf("图片ID数量与向量数量不匹配")
+// InsertImages 插入图像向量及其文件信息。启用写入缓冲时记录先进入缓冲，达到条数上限或间隔时间后批量写入；
+// 以strong或session一致性级别写入时先写入缓冲中的记录，再直接写入本次的记录
+func (s *MilvusService) InsertImages(ctx context.Context, records []*ImageRecord) error {
+	if len(records) == 0 {
+		return nil
 	}
 
-	ctx := context.Background()
+	// 时间戳，按月分区时决定写入的分区
+	now := time.Now().Unix()
+	for _, record := range records {
+		if record.Timestamp <= 0 {
+			record.Timestamp = now
+		}
+	}
 
-	// 准备数据
-	imageIDColumn := entity.NewColumnVarChar("image_id", imageIDs)
+	if s.buffered() && !s.readYourWrites() {
+		return s.enqueue(ctx, records)
+	}
+	if err := s.FlushBuffer(ctx); err != nil {
+		return err
+	}
+	return s.writeRecords(ctx, records)
+}
 
-	// 转换向量数据
-	vectorData := make([][]float32, len(vectors))
-	for i, vec := range vectors {
-		vectorData[i] = vec
+// writeRecords 写入记录，启用分区时按分区分组写入（分区不存在时自动创建）
+func (s *MilvusService) writeRecords(ctx context.Context, records []*ImageRecord) error {
+	if !s.partitioned() {
+		if err := s.insert(ctx, "", records); err != nil {
+			return err
+		}
+	} else {
+		groups := map[string][]*ImageRecord{}
+		var names []string
+		for _, record := range records {
+			name := partitionName(s.config.PartitionBy, record.Category, record.Timestamp)
+			if _, ok := groups[name]; !ok {
+				names = append(names, name)
+			}
+			groups[name] = append(groups[name], record)
+		}
+
+		for _, name := range names {
+			if err := s.ensurePartition(ctx, name); err != nil {
+				return err
+			}
+			if err := s.insert(ctx, name, groups[name]); err != nil {
+				return err
+			}
+		}
 	}
+
+	// 不逐次调用Flush：Milvus按segment大小和时间自动落盘，关闭服务时统一刷新
+	slog.InfoContext(ctx, "成功插入向量", "collection", s.collection, "count", len(records))
+	return nil
+}
+
+// insert 向指定分区写入一批记录，分区为空时写入默认分区
+func (s *MilvusService) insert(ctx context.Context, partition string, records []*ImageRecord) error {
+	// 准备数据
+	imageIDs := make([]string, len(records))
+	vectorData := make([][]float32, len(records))
+	objectKeys := make([]string, len(records))
+	mimeTypes := make([]string, len(records))
+	fileSizes := make([]int64, len(records))
+	for i, record := range records {
+		imageIDs[i] = record.ImageID
+		vectorData[i] = record.Vector
+		objectKeys[i] = record.ObjectKey
+		mimeTypes[i] = record.MimeType
+		fileSizes[i] = record.FileSize
+	}
+	imageIDColumn := entity.NewColumnVarChar("image_id", imageIDs)
 	vectorColumn := entity.NewColumnFloatVector("vector", s.config.Dimension, vectorData)
 
 	// 时间戳
 	timestamps := make([]int64, len(imageIDs))
-	now := time.Now().Unix()
-	for i := range timestamps {
-		timestamps[i] = now
+	for i, record := range records {
+		timestamps[i] = record.Timestamp
 	}
 	timestampColumn := entity.NewColumnInt64("timestamp", timestamps)
 
+	columns := []entity.Column{imageIDColumn, vectorColumn, timestampColumn}
+	if s.hasFileFields {
+		columns = append(columns,
+			entity.NewColumnVarChar(fieldObjectKey, objectKeys),
+			entity.NewColumnVarChar(fieldMimeType, mimeTypes),
+			entity.NewColumnInt64(fieldFileSize, fileSizes),
+		)
+	}
+
 	// 执行插入
-	_, err := s.client.Insert(ctx, s.collection, "", imageIDColumn, vectorColumn, timestampColumn)
+	_, err := s.client.Insert(ctx, s.collection, partition, columns...)
 	if err != nil {
-		return fmt.Errorf("插入向量失败: %v", err)
+		return fmt.Errorf("插入向量失败: %w", err)
 	}
+	metrics.AddVectorsInserted(s.collection, len(records))
+	return nil
+}
+
+// outputFields 查询和搜索时返回的标量字段
+func (s *MilvusService) outputFields() []string {
+	fields := []string{"image_id"}
+	if s.hasFileFields {
+		fields = append(fields, fieldObjectKey, fieldMimeType, fieldFileSize)
+	}
+	return fields
+}
 
-	// 刷新数据
-	err = s.client.Flush(ctx, s.collection, false)
+// fillFileFields 从结果列中读取文件信息字段
+func fillFileFields(result *SearchResult, fields client.ResultSet, i int) {
+	if column := fields.GetColumn(fieldObjectKey); column != nil {
+		result.ObjectKey, _ = column.GetAsString(i)
+	}
+	if column := fields.GetColumn(fieldMimeType); column != nil {
+		result.MimeType, _ = column.GetAsString(i)
+	}
+	if column := fields.GetColumn(fieldFileSize); column != nil {
+		result.FileSize, _ = column.GetAsInt64(i)
+	}
+}
+
+// SearchSimilar 搜索相似向量，结果按相似度从高到低排列
+func (s *MilvusService) SearchSimilar(ctx context.Context, queryVector []float32, topK int) ([]*SearchResult, error) {
+	// 创建搜索参数
+	sp, _ := s.searchParam(topK)
+
+	results, err := s.search(ctx, [][]float32{queryVector}, topK, sp)
 	if err != nil {
-		return fmt.Errorf("刷新数据失败: %v", err)
+		return nil, err
 	}
+	return results[0], nil
+}
 
-	log.Printf("成功插入 %d 个向量", len(imageIDs))
-	return nil
+// RangeSearch 范围搜索：返回相似度不低于minSimilarity的结果，按相似度从高到低排列。
+// 分页由Milvus执行：跳过前offset条，最多返回limit条，offset+limit不超过MaxRangeResults。
+// Milvus不支持radius/range_filter参数时（如部分索引类型），回退为按同样的分页取结果后在内存中过滤；
+// 其他错误（超时、取消、熔断等）直接返回，不再执行第二次搜索
+func (s *MilvusService) RangeSearch(ctx context.Context, queryVector []float32, minSimilarity float32, offset, limit int) ([]*SearchResult, error) {
+	offset = max(offset, 0)
+	if limit <= 0 || offset+limit > MaxRangeResults {
+		limit = MaxRangeResults - offset
+	}
+	if limit <= 0 {
+		return nil, nil
+	}
+	page := client.WithOffset(int64(offset))
+
+	// L2：返回距离小于radius的结果；IP/COSINE：返回分数大于radius的结果
+	sp, _ := s.searchParam(offset + limit)
+	sp.AddRadius(float64(SimilarityToScore(s.config.MetricType, minSimilarity)))
+
+	results, err := s.search(ctx, [][]float32{queryVector}, limit, sp, page)
+	if err == nil {
+		// radius为开区间，边界上的结果按阈值再过滤一次
+		return FilterBySimilarity(results[0], minSimilarity), nil
+	}
+	if !rangeSearchUnsupported(ctx, err) {
+		return nil, err
+	}
+	slog.WarnContext(ctx, "不支持范围搜索，回退为内存过滤", "collection", s.collection, "error", err)
+
+	// 结果按相似度排列，过滤后不足limit条说明已到阈值
+	sp, _ = s.searchParam(offset + limit)
+	fallbackResults, err := s.search(ctx, [][]float32{queryVector}, limit, sp, page)
+	if err != nil {
+		return nil, err
+	}
+	return FilterBySimilarity(fallbackResults[0], minSimilarity), nil
 }
 
-// SearchSimilar 搜索相似向量
-func (s *MilvusService) SearchSimilar(queryVector []float32, topK int) ([]*SearchResult, error) {
-	ctx := context.Background()
+// rangeSearchUnsupported Milvus是否因不支持radius/range_filter参数拒绝了范围搜索。
+// 超时、取消、熔断和连接错误与参数无关，返回false
+func rangeSearchUnsupported(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) ||
+		errors.Is(err, ErrMilvusUnavailable) || isConnectionError(ctx, err) {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "radius") || strings.Contains(msg, "range_filter") || strings.Contains(msg, "range search")
+}
 
-	// 创建搜索参数
-	sp, _ := entity.NewIndexIvfFlatSearchParam(16)
+// searchParam 按索引类型创建搜索参数，分页时topK为offset+limit
+func (s *MilvusService) searchParam(topK int) (entity.SearchParam, error) {
+	switch s.config.IndexType {
+	case IndexHNSW:
+		// HNSW要求ef不小于topK
+		ef := 64
+		if topK > ef {
+			ef = topK
+		}
+		return entity.NewIndexHNSWSearchParam(ef)
+	case IndexFlat:
+		return entity.NewIndexFlatSearchParam()
+	default:
+		return entity.NewIndexIvfFlatSearchParam(16)
+	}
+}
+
+// search 执行向量搜索，返回每个查询向量各自的结果列表，opts为附加的搜索选项（如分页）
+func (s *MilvusService) search(ctx context.Context, queryVectors [][]float32, topK int, sp entity.SearchParam, opts ...client.SearchQueryOptionFunc) ([][]*SearchResult, error) {
+	if s.readYourWrites() {
+		if err := s.FlushBuffer(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	vectors := make([]entity.Vector, len(queryVectors))
+	for i, v := range queryVectors {
+		vectors[i] = entity.FloatVector(v)
+	}
+
+	// 确定搜索的分区，固定的分区不存在时返回空结果
+	partitions, release, empty, err := s.acquirePartitions(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	if empty {
+		return make([][]*SearchResult, len(queryVectors)), nil
+	}
 
 	// 执行搜索
+	metrics.IncSearches(s.collection)
 	result, err := s.client.Search(
 		ctx,
 		s.collection,
-		[]string{},           // 分区名称
-		"",                   // 表达式
-		[]string{"image_id"}, // 输出字段
-		[]entity.Vector{entity.FloatVector(queryVector)}, // 查询向量
+		partitions,                             // 分区名称
+		"",                                     // 表达式
+		s.outputFields(),                       // 输出字段
+		vectors,                                // 查询向量
 		"vector",                               // 向量字段名
 		entity.MetricType(s.config.MetricType), // 距离度量
 		topK,                                   // 返回数量
 		sp,
+		s.queryOptions(opts...)...,
 	)
 	if err != nil {
-		return nil, fmt.Errorf("搜索失败: %v", err)
+		return nil, fmt.Errorf("搜索失败: %w", err)
 	}
 
 	// 处理搜索结果
-	var searchResults []*SearchResult
-	for _, res := range result {
+	searchResults := make([][]*SearchResult, len(queryVectors))
+	for q, res := range result {
+		if q >= len(searchResults) {
+			break
+		}
+		if res.Err != nil {
+			return nil, fmt.Errorf("搜索失败: %w", res.Err)
+		}
+
 		for i := 0; i < res.ResultCount; i++ {
-			imageID, _ := res.Fields.GetColumn("image_id").Get(i)
+			imageID, _ := res.Fields.GetColumn("image_id").GetAsString(i)
 
 			searchResult := &SearchResult{
 				ID:       res.IDs.(*entity.ColumnInt64).Data()[i],
-				Score:    res.Scores[i],
-				ImageID:  imageID.(string),
-				Distance: res.Scores[i],
+				Score:    ScoreToSimilarity(s.config.MetricType, res.Scores[i]),
+				ImageID:  imageID,
+				Distance: ScoreToDistance(s.config.MetricType, res.Scores[i]),
+				RawScore: res.Scores[i],
 			}
-			searchResults = append(searchResults, searchResult)
+			fillFileFields(searchResult, res.Fields, i)
+			searchResults[q] = append(searchResults[q], searchResult)
 		}
 	}
 
 	return searchResults, nil
 }
 
+// GetImage 按图像ID查询已存储的记录，不存在时返回nil
+func (s *MilvusService) GetImage(ctx context.Context, imageID string) (*SearchResult, error) {
+	if record := s.getBuffered(imageID); record != nil {
+		return &SearchResult{
+			ImageID:   record.ImageID,
+			ObjectKey: record.ObjectKey,
+			MimeType:  record.MimeType,
+			FileSize:  record.FileSize,
+		}, nil
+	}
+
+	expr := imageIDExpr(imageID)
+	resultSet, err := s.client.Query(ctx, s.collection, []string{}, expr, s.outputFields(), s.queryOptions(client.WithLimit(1))...)
+	if err != nil {
+		return nil, fmt.Errorf("查询图像失败: %w", err)
+	}
+
+	idColumn := resultSet.GetColumn("id")
+	if idColumn == nil || idColumn.Len() == 0 {
+		return nil, nil
+	}
+
+	id, _ := idColumn.GetAsInt64(0)
+	result := &SearchResult{ID: id, ImageID: imageID}
+	fillFileFields(result, resultSet, 0)
+	return result, nil
+}
+
+// ScanImages 按主键顺序分批遍历集合中的全部记录，withVectors为false时不返回向量
+func (s *MilvusService) ScanImages(ctx context.Context, batchSize int, withVectors bool, fn func(batch []*ImageRecord) error) error {
+	fields := append(s.outputFields(), "timestamp")
+	if withVectors {
+		fields = append(fields, "vector")
+	}
+
+	// 遍历前写入缓冲中的记录
+	if err := s.FlushBuffer(ctx); err != nil {
+		return err
+	}
+
+	// 只加载部分分区时，遍历期间临时加载全部分区
+	partitions, release, empty, err := s.acquirePartitions(ctx, true)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if empty {
+		return nil
+	}
+
+	// 以主键作为游标分页，Milvus按主键顺序合并查询结果
+	var lastID int64 = -1
+	for {
+		expr := fmt.Sprintf("id > %d", lastID)
+		resultSet, err := s.client.Query(ctx, s.collection, partitions, expr, fields, client.WithLimit(int64(batchSize)))
+		if err != nil {
+			return fmt.Errorf("遍历集合失败: %w", err)
+		}
+
+		idColumn := resultSet.GetColumn("id")
+		if idColumn == nil || idColumn.Len() == 0 {
+			return nil
+		}
+
+		batch := make([]*ImageRecord, idColumn.Len())
+		for i := range batch {
+			record := &ImageRecord{}
+			record.ID, _ = idColumn.GetAsInt64(i)
+			if column := resultSet.GetColumn("image_id"); column != nil {
+				record.ImageID, _ = column.GetAsString(i)
+			}
+			if column := resultSet.GetColumn("timestamp"); column != nil {
+				record.Timestamp, _ = column.GetAsInt64(i)
+			}
+			if column, ok := resultSet.GetColumn("vector").(*entity.ColumnFloatVector); ok {
+				record.Vector = column.Data()[i]
+			}
+
+			result := &SearchResult{}
+			fillFileFields(result, resultSet, i)
+			record.ObjectKey, record.MimeType, record.FileSize = result.ObjectKey, result.MimeType, result.FileSize
+
+			if record.ID > lastID {
+				lastID = record.ID
+			}
+			batch[i] = record
+		}
+
+		if err := fn(batch); err != nil {
+			return err
+		}
+		if len(batch) < batchSize {
+			return nil
+		}
+	}
+}
+
+// DeleteByIDs 按主键删除记录
+func (s *MilvusService) DeleteByIDs(ctx context.Context, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	if err := s.client.DeleteByPks(ctx, s.collection, "", entity.NewColumnInt64("id", ids)); err != nil {
+		return fmt.Errorf("删除向量失败: %w", err)
+	}
+
+	slog.InfoContext(ctx, "成功删除向量记录", "collection", s.collection, "count", len(ids))
+	return nil
+}
+
+// imageIDExpr 构建按图像ID匹配的表达式，转义ID中的反斜杠和双引号，避免拼接出其他条件
+func imageIDExpr(imageID string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(imageID)
+	return fmt.Sprintf("image_id == \"%s\"", escaped)
+}
+
 // DeleteVector 删除向量
-func (s *MilvusService) DeleteVector(imageID string) error {
-	ctx := context.Background()
+func (s *MilvusService) DeleteVector(ctx context.Context, imageID string) error {
+	// 等待正在进行的批量写入完成，尚未写入的记录直接从缓冲中删除
+	unlock := s.lockWrites()
+	defer unlock()
+	s.removeBuffered(imageID)
 
 	// 构建删除表达式
-	expr := fmt.Sprintf("image_id == \"%s\"", imageID)
+	expr := imageIDExpr(imageID)
 
 	// 执行删除
 	err := s.client.Delete(ctx, s.collection, "", expr)
 	if err != nil {
-		return fmt.Errorf("删除向量失败: %v", err)
+		return fmt.Errorf("删除向量失败: %w", err)
 	}
 
-	log.Printf("成功删除图片 %s 的向量", imageID)
+	slog.InfoContext(ctx, "成功删除图片的向量", "collection", s.collection, "image_id", imageID)
 	return nil
 }
 
 // GetCollectionStats 获取collection统计信息
-func (s *MilvusService) GetCollectionStats() (map[string]interface{}, error) {
-	ctx := context.Background()
-
+func (s *MilvusService) GetCollectionStats(ctx context.Context) (map[string]interface{}, error) {
 	stats, err := s.client.GetCollectionStatistics(ctx, s.collection)
 	if err != nil {
-		return nil, fmt.Errorf("获取统计信息失败: %v", err)
+		return nil, fmt.Errorf("获取统计信息失败: %w", err)
 	}
 
 	// 解析统计信息
-	return map[string]interface{}{
+	info := map[string]interface{}{
 		"collection_stats": stats,
 		"collection_name":  s.collection,
-	}, nil
+	}
+	if s.partitioned() {
+		info["partitions"] = s.PartitionStats()
+	}
+	info["insert_buffer"] = s.BufferStats()
+	return info, nil
+}
+
+// RowCount 返回collection中的向量行数（含写入缓冲中尚未写入的记录）
+func (s *MilvusService) RowCount(ctx context.Context) (int64, error) {
+	stats, err := s.client.GetCollectionStatistics(ctx, s.collection)
+	if err != nil {
+		return 0, fmt.Errorf("获取统计信息失败: %w", err)
+	}
+
+	count, err := strconv.ParseInt(stats["row_count"], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("解析行数失败: %w", err)
+	}
+	return count + int64(s.pendingRows()), nil
 }
 
-// Close 关闭连接
+// Close 写入缓冲中的记录并刷新，然后关闭连接（连接由其他实例持有时不关闭）
 func (s *MilvusService) Close() {
-	if s.client != nil {
+	s.closeBuffer(true)
+	if s.client != nil && !s.sharedClient {
 		s.client.Close()
-		log.Println("Milvus连接已关闭")
+		slog.Info("Milvus连接已关闭")
 	}
 }
 
-// HealthCheck 健康检查
-func (s *MilvusService) HealthCheck() error {
-	ctx := context.Background()
+// ConnectionStats 连接状态：熔断器状态、重连次数和最近的连接错误
+func (s *MilvusService) ConnectionStats() map[string]interface{} {
+	return s.client.Stats()
+}
 
-	// 检查服务器状态
-	state, err := s.client.GetLoadingProgress(ctx, s.collection, []string{})
+// HealthCheck 检查Milvus是否可以访问且collection存在
+func (s *MilvusService) HealthCheck(ctx context.Context) error {
+	has, err := s.client.HasCollection(ctx, s.collection)
 	if err != nil {
-		return fmt.Errorf("健康检查失败: %v", err)
+		return fmt.Errorf("健康检查失败: %w", err)
+	}
+	if !has {
+		return fmt.Errorf("collection %s 不存在", s.collection)
 	}
-
-	log.Printf("Collection %s 加载进度: %d%%", s.collection, state)
 	return nil
 }
+
+// LoadingProgress collection加载到内存的进度（0-100）。只加载部分分区时返回已加载分区的进度
+func (s *MilvusService) LoadingProgress(ctx context.Context) (int64, error) {
+	var partitions []string
+	if s.limitedLoading() {
+		if partitions = s.loadedPartitions(); len(partitions) == 0 {
+			// 还没有分区（空collection），没有需要加载的数据
+			return 100, nil
+		}
+	}
+
+	progress, err := s.client.GetLoadingProgress(ctx, s.collection, partitions)
+	if err != nil {
+		return 0, fmt.Errorf("获取加载进度失败: %w", err)
+	}
+	return progress, nil
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestImageIDExpr(t *testing.T) {
+	cases := map[string]string{
+		"3f2b8c1e-8d4a-4b7e-9c1d-2a6f5e4d3c2b": `image_id == "3f2b8c1e-8d4a-4b7e-9c1d-2a6f5e4d3c2b"`,
+		`x" || image_id != "`:                  `image_id == "x\" || image_id != \""`,
+		`a\" or "1`:                            `image_id == "a\\\" or \"1"`,
+	}
+	for imageID, want := range cases {
+		if got := imageIDExpr(imageID); got != want {
+			t.Errorf("imageIDExpr(%q) 应为 %s，实际 %s", imageID, want, got)
+		}
+	}
+}
+
+func TestRangeSearchFallback(t *testing.T) {
+	cases := []struct {
+		name     string
+		fault    error
+		searches int
+		wantErr  bool
+	}{
+		{"不支持radius时回退", errors.New("radius is not supported for index type FLAT"), 2, false},
+		{"超时不回退", context.DeadlineExceeded, 1, true},
+		{"其他错误不回退", errors.New("collection not loaded"), 1, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dialer := &fakeDialer{}
+			ctx := context.Background()
+			service, err := newMilvusService(ctx, testMilvusConfig(), dialer.dial)
+			if err != nil {
+				t.Fatalf("newMilvusService: %v", err)
+			}
+			defer service.Close()
+			conn := dialer.conn(0)
+			conn.script(tc.fault)
+
+			_, err = service.RangeSearch(ctx, make([]float32, 8), 0.5, 0, 10)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("应返回错误: %v，实际 %v", tc.wantErr, err)
+			}
+			conn.mu.Lock()
+			defer conn.mu.Unlock()
+			if conn.searches != tc.searches {
+				t.Errorf("应搜索 %d 次，实际 %d 次", tc.searches, conn.searches)
+			}
+		})
+	}
+}
+
+func TestRangeSearchUnsupported(t *testing.T) {
+	ctx := context.Background()
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	if !rangeSearchUnsupported(ctx, errors.New("range_filter must be less than radius")) {
+		t.Error("range_filter参数错误应回退")
+	}
+	if rangeSearchUnsupported(ctx, ErrMilvusUnavailable) {
+		t.Error("熔断中不应回退")
+	}
+	if rangeSearchUnsupported(ctx, errDropped) {
+		t.Error("连接错误不应回退")
+	}
+	if rangeSearchUnsupported(canceled, errors.New("radius is not supported")) {
+		t.Error("请求已取消时不应回退")
+	}
+}
@@ -0,0 +1,409 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"image-search-go/auth"
+	"image-search-go/config"
+	"image-search-go/metrics"
+	"image-search-go/models"
+	"image-search-go/storage"
+)
+
+// DefaultNamespace 默认命名空间，对应配置中的collection
+const DefaultNamespace = auth.DefaultNamespace
+
+var (
+	// ErrNamespaceNotFound 命名空间不存在
+	ErrNamespaceNotFound = errors.New("命名空间不存在")
+	// ErrNamespaceExists 命名空间已存在
+	ErrNamespaceExists = errors.New("命名空间已存在")
+	// ErrQuotaExceeded 命名空间图像数量达到配额
+	ErrQuotaExceeded = errors.New("图像数量已达到命名空间配额")
+)
+
+// Namespace 租户命名空间配置
+type Namespace struct {
+	Name       string `json:"name"`
+	Collection string `json:"collection"`
+	Extractor  string `json:"extractor"`
+	Dimension  int    `json:"dimension"`
+	IndexType  string `json:"index_type"`
+	MetricType string `json:"metric_type"`
+	// PartitionBy 分区方式，见 MilvusConfig.PartitionBy
+	PartitionBy string    `json:"partition_by,omitempty"`
+	MaxImages   int64     `json:"max_images"` // 0表示不限制
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Tenant 一个命名空间的运行时资源
+type Tenant struct {
+	Namespace *Namespace
+	Milvus    *MilvusService
+	Extractor models.FeatureExtractor
+	Pipeline  *UploadPipeline
+}
+
+// CheckQuota 检查命名空间是否还能写入新图像
+func (t *Tenant) CheckQuota(ctx context.Context) error {
+	if t.Namespace.MaxImages <= 0 {
+		return nil
+	}
+
+	count, err := t.Milvus.RowCount(ctx)
+	if err != nil {
+		return err
+	}
+	if count >= t.Namespace.MaxImages {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// Owns 判断元数据是否属于该命名空间
+func (t *Tenant) Owns(meta *ImageMetadata) bool {
+	return meta != nil && meta.InNamespace(t.Namespace.Name)
+}
+
+// ObjectKeyPrefix 命名空间图像文件key的前缀，默认命名空间没有前缀
+func (t *Tenant) ObjectKeyPrefix() string {
+	return NamespaceKeyPrefix(t.Namespace.Name)
+}
+
+// OwnsObjectKey 判断图像文件key是否属于该命名空间：以命名空间的前缀开头且其后没有子目录
+// （默认命名空间没有前缀，其他命名空间的key含有子目录）
+func (t *Tenant) OwnsObjectKey(key string) bool {
+	rest, ok := strings.CutPrefix(key, t.ObjectKeyPrefix())
+	return ok && rest != "" && !strings.Contains(rest, "/")
+}
+
+// NamespaceKeyPrefix 命名空间图像文件key的前缀
+func NamespaceKeyPrefix(namespace string) string {
+	if namespace == DefaultNamespace || namespace == "" {
+		return ""
+	}
+	return namespace + "/"
+}
+
+// NamespaceManager 管理命名空间及其collection、特征提取器和上传流程
+type NamespaceManager struct {
+	mu sync.RWMutex
+	// adminMu 串行执行命名空间的创建和删除：检查是否存在到注册完成期间持有，
+	// 同名的并发创建只有一个成功；mu只保护tenants，不在访问Milvus期间持有
+	adminMu       sync.Mutex
+	base          *MilvusService
+	baseConfig    config.MilvusConfig
+	serverConfig  config.ServerConfig
+	metadataStore *MetadataStore
+	blobStore     storage.BlobStore
+	pool          *ExtractionPool
+	tenants       map[string]*Tenant
+}
+
+// NewNamespaceManager 创建命名空间管理器，注册默认命名空间并打开已创建的命名空间
+func NewNamespaceManager(ctx context.Context, base *MilvusService, cfg *config.Config, metadataStore *MetadataStore, blobStore storage.BlobStore) (*NamespaceManager, error) {
+	m := &NamespaceManager{
+		base:          base,
+		baseConfig:    cfg.Milvus,
+		serverConfig:  cfg.Server,
+		metadataStore: metadataStore,
+		blobStore:     blobStore,
+		pool:          NewExtractionPool(&cfg.Extraction),
+		tenants:       map[string]*Tenant{},
+	}
+
+	defaultNamespace := &Namespace{
+		Name:        DefaultNamespace,
+		Collection:  cfg.Milvus.CollectionName,
+		Extractor:   models.ExtractorSimple,
+		Dimension:   cfg.Milvus.Dimension,
+		IndexType:   cfg.Milvus.IndexType,
+		MetricType:  cfg.Milvus.MetricType,
+		PartitionBy: cfg.Milvus.PartitionBy,
+	}
+	if _, err := m.open(defaultNamespace, base); err != nil {
+		return nil, err
+	}
+
+	namespaces, err := metadataStore.ListNamespaces()
+	if err != nil {
+		return nil, err
+	}
+	for _, ns := range namespaces {
+		milvusService, err := base.WithCollection(ctx, m.milvusConfig(ns))
+		if err != nil {
+			// 关闭已打开的命名空间，调用方可以重试
+			m.Close()
+			return nil, fmt.Errorf("打开命名空间 %s 失败: %w", ns.Name, err)
+		}
+		if _, err := m.open(ns, milvusService); err != nil {
+			milvusService.Close()
+			m.Close()
+			return nil, err
+		}
+	}
+
+	slog.InfoContext(ctx, "命名空间加载完成", "count", len(m.tenants))
+	return m, nil
+}
+
+// open 创建命名空间的特征提取器和上传流程并注册
+func (m *NamespaceManager) open(ns *Namespace, milvusService *MilvusService) (*Tenant, error) {
+	extractor, err := models.NewFeatureExtractor(ns.Extractor, ns.Dimension)
+	if err != nil {
+		return nil, fmt.Errorf("命名空间 %s: %v", ns.Name, err)
+	}
+	extractor = m.pool.Wrap(metrics.InstrumentExtractor(ns.Name, extractor))
+
+	pipeline := NewUploadPipeline(m.blobStore, m.metadataStore, milvusService, extractor,
+		m.serverConfig.StripExif, m.serverConfig.IdempotencyTTL, m.serverConfig.IdempotencyLease)
+	pipeline.namespace = ns.Name
+
+	tenant := &Tenant{
+		Namespace: ns,
+		Milvus:    milvusService,
+		Extractor: extractor,
+		Pipeline:  pipeline,
+	}
+
+	m.mu.Lock()
+	m.tenants[ns.Name] = tenant
+	m.mu.Unlock()
+	return tenant, nil
+}
+
+// milvusConfig 命名空间对应的Milvus配置
+func (m *NamespaceManager) milvusConfig(ns *Namespace) *config.MilvusConfig {
+	return NamespaceMilvusConfig(m.baseConfig, ns)
+}
+
+// NamespaceMilvusConfig 在基础配置上应用命名空间的collection、维度、索引和分区设置
+func NamespaceMilvusConfig(base config.MilvusConfig, ns *Namespace) *config.MilvusConfig {
+	cfg := base
+	cfg.CollectionName = ns.Collection
+	cfg.Dimension = ns.Dimension
+	cfg.IndexType = ns.IndexType
+	cfg.MetricType = ns.MetricType
+	cfg.PartitionBy = ns.PartitionBy
+	return &cfg
+}
+
+// Get 获取命名空间，名称为空时返回默认命名空间
+func (m *NamespaceManager) Get(name string) (*Tenant, error) {
+	if name == "" {
+		name = DefaultNamespace
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	tenant, ok := m.tenants[name]
+	if !ok {
+		return nil, ErrNamespaceNotFound
+	}
+	return tenant, nil
+}
+
+// AdmitImage 在所有命名空间共用的特征提取池中占用一个图像名额，见 ExtractionPool.AdmitImage
+func (m *NamespaceManager) AdmitImage(ctx context.Context) (func(), error) {
+	return m.pool.AdmitImage(ctx)
+}
+
+// ExtractionStats 返回所有命名空间共用的特征提取池的状态
+func (m *NamespaceManager) ExtractionStats() *ExtractionStats {
+	return m.pool.Stats()
+}
+
+// Close 写入各命名空间缓冲中的记录并停止定时写入。默认命名空间的服务由调用方关闭
+func (m *NamespaceManager) Close() {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for name, tenant := range m.tenants {
+		if name != DefaultNamespace {
+			tenant.Milvus.Close()
+		}
+	}
+}
+
+// Default 返回默认命名空间
+func (m *NamespaceManager) Default() *Tenant {
+	tenant, _ := m.Get(DefaultNamespace)
+	return tenant
+}
+
+// List 按名称返回全部命名空间
+func (m *NamespaceManager) List() []*Namespace {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	namespaces := make([]*Namespace, 0, len(m.tenants))
+	for _, tenant := range m.tenants {
+		namespaces = append(namespaces, tenant.Namespace)
+	}
+	sort.Slice(namespaces, func(i, j int) bool {
+		return namespaces[i].Name < namespaces[j].Name
+	})
+	return namespaces
+}
+
+// Create 创建命名空间及其collection，未指定的配置沿用默认命名空间
+func (m *NamespaceManager) Create(ctx context.Context, ns *Namespace) (*Tenant, error) {
+	if !auth.ValidNamespace(ns.Name) || ns.Name == DefaultNamespace {
+		return nil, fmt.Errorf("无效的命名空间名称: %s（小写字母、数字和下划线，最长32个字符）", ns.Name)
+	}
+
+	m.adminMu.Lock()
+	defer m.adminMu.Unlock()
+	if _, err := m.Get(ns.Name); err == nil {
+		return nil, ErrNamespaceExists
+	}
+
+	ns.Collection = m.baseConfig.CollectionName + "_" + ns.Name
+	if ns.Extractor == "" {
+		ns.Extractor = models.ExtractorSimple
+	}
+	if ns.Dimension == 0 {
+		ns.Dimension = m.baseConfig.Dimension
+	}
+	if ns.IndexType == "" {
+		ns.IndexType = m.baseConfig.IndexType
+	}
+	if ns.MetricType == "" {
+		ns.MetricType = m.baseConfig.MetricType
+	}
+	if ns.PartitionBy == "" {
+		ns.PartitionBy = m.baseConfig.PartitionBy
+	}
+	ns.IndexType = strings.ToUpper(ns.IndexType)
+	ns.MetricType = strings.ToUpper(ns.MetricType)
+	ns.CreatedAt = time.Now()
+
+	if err := validateNamespace(ns); err != nil {
+		return nil, err
+	}
+
+	milvusService, err := m.base.WithCollection(ctx, m.milvusConfig(ns))
+	if err != nil {
+		return nil, err
+	}
+	if err := m.metadataStore.PutNamespace(ns); err != nil {
+		milvusService.closeBuffer(false)
+		if dropErr := milvusService.DropCollection(context.WithoutCancel(ctx)); dropErr != nil {
+			slog.ErrorContext(ctx, "回滚命名空间的collection失败", "namespace", ns.Name, "error", dropErr)
+		}
+		return nil, err
+	}
+
+	slog.InfoContext(ctx, "命名空间已创建", "namespace", ns.Name, "collection", ns.Collection)
+	return m.open(ns, milvusService)
+}
+
+// validateNamespace 检查命名空间配置
+func validateNamespace(ns *Namespace) error {
+	if ns.Dimension <= 0 || ns.Dimension > 32768 {
+		return fmt.Errorf("无效的维度: %d", ns.Dimension)
+	}
+	switch ns.IndexType {
+	case IndexIVFFlat, IndexHNSW, IndexFlat:
+	default:
+		return fmt.Errorf("不支持的索引类型: %s", ns.IndexType)
+	}
+	switch ns.MetricType {
+	case MetricL2, MetricIP, MetricCOSINE:
+	default:
+		return fmt.Errorf("不支持的度量类型: %s", ns.MetricType)
+	}
+	if !ValidPartitionBy(ns.PartitionBy) {
+		return fmt.Errorf("不支持的分区方式: %s", ns.PartitionBy)
+	}
+	if ns.MaxImages < 0 {
+		return fmt.Errorf("无效的配额: %d", ns.MaxImages)
+	}
+	if _, err := models.NewFeatureExtractor(ns.Extractor, ns.Dimension); err != nil {
+		return err
+	}
+	return nil
+}
+
+// DropResult 删除命名空间的统计
+type DropResult struct {
+	MetadataDeleted int `json:"metadata_deleted"`
+	FilesDeleted    int `json:"files_deleted"`
+}
+
+// Drop 删除命名空间：删除collection、元数据和图像文件
+func (m *NamespaceManager) Drop(ctx context.Context, name string) (*DropResult, error) {
+	if name == DefaultNamespace {
+		return nil, fmt.Errorf("不能删除默认命名空间")
+	}
+
+	// 删除元数据和文件期间不允许重新创建同名命名空间
+	m.adminMu.Lock()
+	defer m.adminMu.Unlock()
+
+	m.mu.Lock()
+	tenant, ok := m.tenants[name]
+	if ok {
+		delete(m.tenants, name)
+	}
+	m.mu.Unlock()
+	if !ok {
+		return nil, ErrNamespaceNotFound
+	}
+
+	if err := tenant.Milvus.DropCollection(ctx); err != nil {
+		// collection未删除，恢复注册以便重试
+		m.mu.Lock()
+		m.tenants[name] = tenant
+		m.mu.Unlock()
+		return nil, err
+	}
+	tenant.Milvus.closeBuffer(false)
+	if err := m.metadataStore.DeleteNamespace(name); err != nil {
+		return nil, err
+	}
+
+	result := &DropResult{}
+
+	var imageIDs []string
+	err := m.metadataStore.ForEach(func(meta *ImageMetadata) error {
+		if tenant.Owns(meta) {
+			imageIDs = append(imageIDs, meta.ImageID)
+		}
+		return nil
+	})
+	if err != nil {
+		return result, fmt.Errorf("遍历元数据失败: %v", err)
+	}
+	for _, imageID := range imageIDs {
+		if err := m.metadataStore.Delete(imageID); err != nil {
+			return result, err
+		}
+		result.MetadataDeleted++
+	}
+
+	var keys []string
+	err = m.blobStore.List(ctx, tenant.ObjectKeyPrefix(), func(info *storage.ObjectInfo) error {
+		keys = append(keys, info.Key)
+		return nil
+	})
+	if err != nil {
+		return result, fmt.Errorf("遍历图像文件失败: %v", err)
+	}
+	for _, key := range keys {
+		if err := m.blobStore.Delete(ctx, key); err != nil {
+			return result, err
+		}
+		result.FilesDeleted++
+	}
+
+	slog.InfoContext(ctx, "命名空间已删除", "namespace", name, "metadata_deleted", result.MetadataDeleted, "files_deleted", result.FilesDeleted)
+	return result, nil
+}
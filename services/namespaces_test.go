@@ -0,0 +1,65 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"image-search-go/config"
+)
+
+func TestNamespaceCreateConcurrent(t *testing.T) {
+	dialer := &fakeDialer{}
+	ctx := context.Background()
+	cfg := &config.Config{Milvus: *testMilvusConfig()}
+	base, err := newMilvusService(ctx, &cfg.Milvus, dialer.dial)
+	if err != nil {
+		t.Fatalf("newMilvusService: %v", err)
+	}
+	defer base.Close()
+	store, err := NewMetadataStore(filepath.Join(t.TempDir(), "metadata.db"))
+	if err != nil {
+		t.Fatalf("NewMetadataStore: %v", err)
+	}
+	defer store.Close()
+
+	manager, err := NewNamespaceManager(ctx, base, cfg, store, &fakeBlobStore{objects: map[string][]byte{}})
+	if err != nil {
+		t.Fatalf("NewNamespaceManager: %v", err)
+	}
+	defer manager.Close()
+
+	// 创建collection时加延迟，使并发的创建在检查是否存在之后重叠
+	conn := dialer.conn(0)
+	conn.mu.Lock()
+	conn.latency = 10 * time.Millisecond
+	conn.mu.Unlock()
+
+	const creators = 4
+	errs := make([]error, creators)
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = manager.Create(ctx, &Namespace{Name: "team_a"})
+		}(i)
+	}
+	wg.Wait()
+
+	created := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			created++
+		case !errors.Is(err, ErrNamespaceExists):
+			t.Errorf("重复创建应返回ErrNamespaceExists，实际 %v", err)
+		}
+	}
+	if created != 1 {
+		t.Errorf("同名的并发创建应只有1个成功，实际 %d 个", created)
+	}
+}
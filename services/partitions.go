@@ -0,0 +1,407 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 分区方式
+const (
+	PartitionByMonth    = "month"
+	PartitionByCategory = "category"
+)
+
+// defaultPartition Milvus每个collection自带的默认分区，没有分类的记录写入该分区
+const defaultPartition = "_default"
+
+// PartitionFilter 搜索时固定分区键的过滤条件
+type PartitionFilter struct {
+	Category string
+	Months   []string // 格式 2006-01
+}
+
+// IsEmpty 是否没有任何过滤条件
+func (f PartitionFilter) IsEmpty() bool {
+	return f.Category == "" && len(f.Months) == 0
+}
+
+// partitionState 分区缓存和加载状态，同一collection的服务实例共享
+type partitionState struct {
+	mu     sync.Mutex
+	known  map[string]bool      // 已存在的分区
+	loaded map[string]time.Time // 已加载的分区 -> 最近使用时间
+	inUse  map[string]int       // 正在被搜索使用的分区，不能释放
+}
+
+func newPartitionState() *partitionState {
+	return &partitionState{
+		known:  map[string]bool{},
+		loaded: map[string]time.Time{},
+		inUse:  map[string]int{},
+	}
+}
+
+// ValidPartitionBy 检查分区方式是否有效
+func ValidPartitionBy(partitionBy string) bool {
+	switch partitionBy {
+	case "", PartitionByMonth, PartitionByCategory:
+		return true
+	}
+	return false
+}
+
+// partitionName 计算记录所属的分区名，不分区时返回空字符串
+func partitionName(partitionBy, category string, timestamp int64) string {
+	switch partitionBy {
+	case PartitionByMonth:
+		return "m" + time.Unix(timestamp, 0).UTC().Format("200601")
+	case PartitionByCategory:
+		return categoryPartition(category)
+	default:
+		return ""
+	}
+}
+
+// categoryPartition 分类对应的分区名。分区名只能包含字母、数字和下划线，
+// 含有其他字符的分类替换后追加哈希，避免不同分类映射到同一分区
+func categoryPartition(category string) string {
+	category = strings.ToLower(strings.TrimSpace(category))
+	if category == "" {
+		return defaultPartition
+	}
+
+	var b strings.Builder
+	replaced := false
+	for _, r := range category {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '_' {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+			replaced = true
+		}
+	}
+
+	name := b.String()
+	if len(name) > 64 {
+		name = name[:64]
+		replaced = true
+	}
+	if replaced {
+		h := fnv.New32a()
+		h.Write([]byte(category))
+		name = fmt.Sprintf("%s_%08x", name, h.Sum32())
+	}
+	return "c_" + name
+}
+
+// monthPartition 将 2006-01 格式的月份转换为分区名
+func monthPartition(month string) (string, error) {
+	t, err := time.Parse("2006-01", month)
+	if err != nil {
+		return "", fmt.Errorf("无效的月份: %s（格式 2006-01）", month)
+	}
+	return "m" + t.Format("200601"), nil
+}
+
+// partitioned 是否启用了分区
+func (s *MilvusService) partitioned() bool {
+	return s.config.PartitionBy != ""
+}
+
+// limitedLoading 是否只加载部分分区
+func (s *MilvusService) limitedLoading() bool {
+	return s.partitioned() && s.config.MaxLoadedPartitions > 0
+}
+
+// refreshPartitions 从Milvus读取分区列表和加载状态
+func (s *MilvusService) refreshPartitions(ctx context.Context) error {
+	partitions, err := s.client.ShowPartitions(ctx, s.collection)
+	if err != nil {
+		return fmt.Errorf("获取分区列表失败: %w", err)
+	}
+
+	s.partitionState.mu.Lock()
+	defer s.partitionState.mu.Unlock()
+	for _, partition := range partitions {
+		s.partitionState.known[partition.Name] = true
+		if partition.Loaded {
+			if _, ok := s.partitionState.loaded[partition.Name]; !ok {
+				s.partitionState.loaded[partition.Name] = time.Now()
+			}
+		}
+	}
+	return nil
+}
+
+// loadRecentPartitions 只加载最近的分区（按分区名倒序，按月分区时即最近的月份）
+func (s *MilvusService) loadRecentPartitions(ctx context.Context) error {
+	if err := s.refreshPartitions(ctx); err != nil {
+		return err
+	}
+
+	s.partitionState.mu.Lock()
+	defer s.partitionState.mu.Unlock()
+
+	var names []string
+	for name := range s.partitionState.known {
+		names = append(names, name)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+	if len(names) > s.config.MaxLoadedPartitions {
+		names = names[:s.config.MaxLoadedPartitions]
+	}
+
+	if err := s.client.LoadPartitions(ctx, s.collection, names, false); err != nil {
+		return fmt.Errorf("加载分区失败: %w", err)
+	}
+	for _, name := range names {
+		s.partitionState.loaded[name] = time.Now()
+	}
+
+	slog.InfoContext(ctx, "已加载分区", "collection", s.collection, "partitions", names)
+	return nil
+}
+
+// ensurePartition 插入前确保分区存在，不存在时创建
+func (s *MilvusService) ensurePartition(ctx context.Context, name string) error {
+	s.partitionState.mu.Lock()
+	defer s.partitionState.mu.Unlock()
+
+	if s.partitionState.known[name] {
+		return nil
+	}
+
+	has, err := s.client.HasPartition(ctx, s.collection, name)
+	if err != nil {
+		return fmt.Errorf("检查分区失败: %w", err)
+	}
+	if !has {
+		if err := s.client.CreatePartition(ctx, s.collection, name); err != nil {
+			return fmt.Errorf("创建分区 %s 失败: %w", name, err)
+		}
+		slog.InfoContext(ctx, "创建分区", "collection", s.collection, "partition", name)
+	}
+	s.partitionState.known[name] = true
+
+	// 只加载部分分区时，新分区是最新的数据，立即加载使其可被搜索
+	if s.limitedLoading() {
+		if err := s.loadPartitionsLocked(ctx, []string{name}); err != nil {
+			return err
+		}
+		s.evictPartitionsLocked(ctx)
+	}
+	return nil
+}
+
+// WithPartitionFilter 返回只在过滤条件固定的分区中搜索的服务实例。
+// 过滤的分区都不存在时，搜索返回空结果
+func (s *MilvusService) WithPartitionFilter(ctx context.Context, filter PartitionFilter) (*MilvusService, error) {
+	if filter.IsEmpty() {
+		return s, nil
+	}
+
+	var names []string
+	switch {
+	case filter.Category != "":
+		if s.config.PartitionBy != PartitionByCategory {
+			return nil, fmt.Errorf("collection %s 未按分类分区", s.collection)
+		}
+		names = []string{categoryPartition(filter.Category)}
+	default:
+		if s.config.PartitionBy != PartitionByMonth {
+			return nil, fmt.Errorf("collection %s 未按月份分区", s.collection)
+		}
+		for _, month := range filter.Months {
+			name, err := monthPartition(month)
+			if err != nil {
+				return nil, err
+			}
+			names = append(names, name)
+		}
+	}
+
+	// 其他副本可能创建了新分区，本地未知时刷新一次
+	if !s.knowsAll(names) {
+		if err := s.refreshPartitions(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	scoped := *s
+	scoped.partitions = []string{}
+	scoped.scoped = true
+	s.partitionState.mu.Lock()
+	for _, name := range names {
+		if s.partitionState.known[name] {
+			scoped.partitions = append(scoped.partitions, name)
+		}
+	}
+	s.partitionState.mu.Unlock()
+	return &scoped, nil
+}
+
+// knowsAll 分区是否都已知
+func (s *MilvusService) knowsAll(names []string) bool {
+	s.partitionState.mu.Lock()
+	defer s.partitionState.mu.Unlock()
+	for _, name := range names {
+		if !s.partitionState.known[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// acquirePartitions 确定本次搜索的分区并标记为使用中，按需加载被固定的分区。
+// 返回的release在搜索结束后调用；empty为true表示过滤的分区都不存在
+func (s *MilvusService) acquirePartitions(ctx context.Context, all bool) (names []string, release func(), empty bool, err error) {
+	noop := func() {}
+	if s.scoped && len(s.partitions) == 0 {
+		return nil, noop, true, nil
+	}
+	if !s.limitedLoading() {
+		if s.scoped {
+			return s.partitions, noop, false, nil
+		}
+		return []string{}, noop, false, nil
+	}
+
+	state := s.partitionState
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	switch {
+	case s.scoped:
+		names = s.partitions
+	case all:
+		for name := range state.known {
+			names = append(names, name)
+		}
+	default:
+		// 未固定分区时只搜索已加载的（最近的）分区
+		for name := range state.loaded {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, noop, true, nil
+	}
+
+	if err := s.loadPartitionsLocked(ctx, names); err != nil {
+		return nil, noop, false, err
+	}
+	for _, name := range names {
+		state.inUse[name]++
+		state.loaded[name] = time.Now()
+	}
+
+	release = func() {
+		state.mu.Lock()
+		defer state.mu.Unlock()
+		for _, name := range names {
+			if state.inUse[name]--; state.inUse[name] <= 0 {
+				delete(state.inUse, name)
+			}
+		}
+		s.evictPartitionsLocked(context.Background())
+	}
+	return names, release, false, nil
+}
+
+// loadPartitionsLocked 加载尚未加载的分区，调用方需持有锁
+func (s *MilvusService) loadPartitionsLocked(ctx context.Context, names []string) error {
+	var missing []string
+	for _, name := range names {
+		if _, ok := s.partitionState.loaded[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	if err := s.client.LoadPartitions(ctx, s.collection, missing, false); err != nil {
+		return fmt.Errorf("加载分区失败: %w", err)
+	}
+	for _, name := range missing {
+		s.partitionState.loaded[name] = time.Now()
+	}
+	slog.InfoContext(ctx, "按需加载分区", "collection", s.collection, "partitions", missing)
+	return nil
+}
+
+// evictPartitionsLocked 已加载分区超过上限时释放最久未使用的分区，调用方需持有锁
+func (s *MilvusService) evictPartitionsLocked(ctx context.Context) {
+	state := s.partitionState
+	excess := len(state.loaded) - s.config.MaxLoadedPartitions
+	if excess <= 0 {
+		return
+	}
+
+	var idle []string
+	for name := range state.loaded {
+		if state.inUse[name] == 0 {
+			idle = append(idle, name)
+		}
+	}
+	sort.Slice(idle, func(i, j int) bool {
+		return state.loaded[idle[i]].Before(state.loaded[idle[j]])
+	})
+	if len(idle) > excess {
+		idle = idle[:excess]
+	}
+	if len(idle) == 0 {
+		return
+	}
+
+	if err := s.client.ReleasePartitions(ctx, s.collection, idle); err != nil {
+		slog.WarnContext(ctx, "释放分区失败", "collection", s.collection, "error", err)
+		return
+	}
+	for _, name := range idle {
+		delete(state.loaded, name)
+	}
+	slog.InfoContext(ctx, "释放分区", "collection", s.collection, "partitions", idle)
+}
+
+// loadedPartitions 已加载的分区
+func (s *MilvusService) loadedPartitions() []string {
+	s.partitionState.mu.Lock()
+	defer s.partitionState.mu.Unlock()
+
+	names := make([]string, 0, len(s.partitionState.loaded))
+	for name := range s.partitionState.loaded {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PartitionStats 返回分区列表及加载状态
+func (s *MilvusService) PartitionStats() map[string]interface{} {
+	s.partitionState.mu.Lock()
+	defer s.partitionState.mu.Unlock()
+
+	var known, loaded []string
+	for name := range s.partitionState.known {
+		known = append(known, name)
+	}
+	for name := range s.partitionState.loaded {
+		loaded = append(loaded, name)
+	}
+	sort.Strings(known)
+	sort.Strings(loaded)
+
+	return map[string]interface{}{
+		"partition_by":          s.config.PartitionBy,
+		"max_loaded_partitions": s.config.MaxLoadedPartitions,
+		"partitions":            known,
+		"loaded_partitions":     loaded,
+	}
+}
@@ -0,0 +1,566 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"sync"
+	"time"
+
+	"image-search-go/config"
+	"image-search-go/metrics"
+	"image-search-go/tracing"
+
+	"github.com/milvus-io/milvus-sdk-go/v2/client"
+	"github.com/milvus-io/milvus-sdk-go/v2/entity"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrMilvusUnavailable Milvus连接不可用（熔断中），请求直接失败而不等待超时
+var ErrMilvusUnavailable = errors.New("Milvus暂时不可用")
+
+// reconnectDialTimeout 重连时单次建立连接的超时时间
+const reconnectDialTimeout = 10 * time.Second
+
+// milvusClient 服务使用的Milvus客户端方法（client.Client的子集），可替换为注入故障的客户端
+type milvusClient interface {
+	Close() error
+	CreateCollection(ctx context.Context, schema *entity.Schema, shardsNum int32, opts ...client.CreateCollectionOption) error
+	DescribeCollection(ctx context.Context, collName string) (*entity.Collection, error)
+	DropCollection(ctx context.Context, collName string, opts ...client.DropCollectionOption) error
+	GetCollectionStatistics(ctx context.Context, collName string) (map[string]string, error)
+	LoadCollection(ctx context.Context, collName string, async bool, opts ...client.LoadCollectionOption) error
+	ReleaseCollection(ctx context.Context, collName string, opts ...client.ReleaseCollectionOption) error
+	HasCollection(ctx context.Context, collName string) (bool, error)
+	RenameCollection(ctx context.Context, collName, newName string) error
+	CreateAlias(ctx context.Context, collName string, alias string) error
+	DropAlias(ctx context.Context, alias string) error
+	AlterAlias(ctx context.Context, collName string, alias string) error
+	CreatePartition(ctx context.Context, collName string, partitionName string, opts ...client.CreatePartitionOption) error
+	ShowPartitions(ctx context.Context, collName string) ([]*entity.Partition, error)
+	HasPartition(ctx context.Context, collName string, partitionName string) (bool, error)
+	LoadPartitions(ctx context.Context, collName string, partitionNames []string, async bool, opts ...client.LoadPartitionsOption) error
+	ReleasePartitions(ctx context.Context, collName string, partitionNames []string, opts ...client.ReleasePartitionsOption) error
+	CreateIndex(ctx context.Context, collName string, fieldName string, idx entity.Index, async bool, opts ...client.IndexOption) error
+	Insert(ctx context.Context, collName string, partitionName string, columns ...entity.Column) (entity.Column, error)
+	Flush(ctx context.Context, collName string, async bool, opts ...client.FlushOption) error
+	DeleteByPks(ctx context.Context, collName string, partitionName string, ids entity.Column) error
+	Delete(ctx context.Context, collName string, partitionName string, expr string) error
+	Search(ctx context.Context, collName string, partitions []string, expr string, outputFields []string, vectors []entity.Vector, vectorField string, metricType entity.MetricType, topK int, sp entity.SearchParam, opts ...client.SearchQueryOptionFunc) ([]client.SearchResult, error)
+	Query(ctx context.Context, collectionName string, partitionNames []string, expr string, outputFields []string, opts ...client.SearchQueryOptionFunc) (client.ResultSet, error)
+	GetLoadingProgress(ctx context.Context, collectionName string, partitionNames []string) (int64, error)
+}
+
+// dialMilvus 按配置连接Milvus
+func dialMilvus(cfg *config.MilvusConfig) func(ctx context.Context) (milvusClient, error) {
+	return func(ctx context.Context) (milvusClient, error) {
+		return client.NewClient(ctx, client.Config{
+			Address: fmt.Sprintf("%s:%s", cfg.Host, cfg.Port),
+		})
+	}
+}
+
+// isConnectionError 是否为连接类错误（Milvus重启、网络中断），调用方取消或超时不算
+func isConnectionError(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	if errors.Is(err, client.ErrClientNotReady) {
+		return true
+	}
+	var grpcErr interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &grpcErr) {
+		switch grpcErr.GRPCStatus().Code() {
+		case codes.Unavailable, codes.DeadlineExceeded, codes.Aborted:
+			return true
+		}
+	}
+	return false
+}
+
+// backoff 第attempt次（从0开始）重试前的等待时间：指数增长，带±20%抖动，不超过max
+func backoff(base, max time.Duration, attempt int) time.Duration {
+	if base <= 0 {
+		base = 100 * time.Millisecond
+	}
+	d := base
+	for i := 0; i < attempt && (max <= 0 || d < max); i++ {
+		d *= 2
+	}
+	if max > 0 && d > max {
+		d = max
+	}
+	jitter := time.Duration(rand.Int63n(int64(d)/5 + 1))
+	if rand.Intn(2) == 0 {
+		return d - jitter
+	}
+	return d + jitter
+}
+
+// sleepContext 等待d，context取消时提前返回错误
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// 熔断器状态
+const (
+	BreakerClosed   = "closed"    // 正常放行
+	BreakerOpen     = "open"      // 熔断中，直接失败
+	BreakerHalfOpen = "half_open" // 冷却结束，放行一个探测请求
+)
+
+// circuitBreaker 熔断器：连续threshold次连接错误后熔断，cooldown后放行一个探测请求，成功则恢复
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool
+	trips    int64
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown, state: BreakerClosed}
+}
+
+// allow 是否放行请求；熔断中返回ErrMilvusUnavailable
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return ErrMilvusUnavailable
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return nil
+	case BreakerHalfOpen:
+		if b.probing {
+			return ErrMilvusUnavailable
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// success 记录一次成功（包括业务错误，说明Milvus可以访问）
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != BreakerClosed {
+		slog.Info("Milvus连接已恢复，解除熔断")
+	}
+	b.state = BreakerClosed
+	b.failures = 0
+	b.probing = false
+}
+
+// failure 记录一次连接错误
+func (b *circuitBreaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.probing = false
+	if b.threshold <= 0 {
+		return
+	}
+	if b.state == BreakerHalfOpen || (b.state == BreakerClosed && b.failures >= b.threshold) {
+		if b.state == BreakerClosed {
+			slog.Warn("Milvus连续连接失败，开启熔断", "failures", b.failures, "cooldown", b.cooldown.String())
+		}
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+		b.trips++
+	}
+}
+
+// cancel 请求被调用方取消，不改变状态，只释放探测名额
+func (b *circuitBreaker) cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// stats 熔断器状态
+func (b *circuitBreaker) stats() map[string]interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	stats := map[string]interface{}{
+		"state":                b.state,
+		"consecutive_failures": b.failures,
+		"threshold":            b.threshold,
+		"cooldown":             b.cooldown.String(),
+		"trips":                b.trips,
+	}
+	if b.state != BreakerClosed {
+		stats["opened_at"] = b.openedAt
+	}
+	return stats
+}
+
+// resilientClient 带重试、熔断和自动重连的Milvus客户端。
+// 幂等操作遇到连接错误时按指数退避重试；连接错误时在后台重新建立连接，成功后替换旧连接
+type resilientClient struct {
+	dial     func(ctx context.Context) (milvusClient, error)
+	attempts int
+	base     time.Duration
+	max      time.Duration
+	breaker  *circuitBreaker
+
+	mu            sync.RWMutex
+	conn          milvusClient
+	reconnecting  bool
+	closed        bool
+	stop          chan struct{}
+	reconnects    int64
+	lastReconnect time.Time
+	lastError     string
+}
+
+// newResilientClient 建立连接并返回带重试和熔断的客户端
+func newResilientClient(ctx context.Context, cfg *config.MilvusConfig, dial func(ctx context.Context) (milvusClient, error)) (*resilientClient, error) {
+	conn, err := dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	attempts := cfg.RetryAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	return &resilientClient{
+		dial:     dial,
+		attempts: attempts,
+		base:     cfg.RetryBackoff,
+		max:      cfg.MaxBackoff,
+		breaker:  newCircuitBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown),
+		conn:     conn,
+		stop:     make(chan struct{}),
+	}, nil
+}
+
+// current 当前连接
+func (c *resilientClient) current() milvusClient {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.conn
+}
+
+// call 经过熔断器执行操作，记录耗时和span；idempotent为true时连接错误按退避重试
+func (c *resilientClient) call(ctx context.Context, operation string, idempotent bool, fn func(conn milvusClient) error) (err error) {
+	start := time.Now()
+	_, span := tracing.Start(ctx, "milvus."+operation)
+	defer func() {
+		metrics.ObserveMilvus(operation, start, err)
+		tracing.End(span, err)
+	}()
+
+	attempts := 1
+	if idempotent {
+		attempts = c.attempts
+	}
+
+	for attempt := 0; attempt < attempts; attempt++ {
+		span.SetAttributes(attribute.Int("milvus.attempts", attempt+1))
+		if attempt > 0 {
+			if sleepErr := sleepContext(ctx, backoff(c.base, c.max, attempt-1)); sleepErr != nil {
+				return err
+			}
+		}
+		if allowErr := c.breaker.allow(); allowErr != nil {
+			if err != nil {
+				return fmt.Errorf("%w: %v", allowErr, err)
+			}
+			return allowErr
+		}
+
+		conn := c.current()
+		err = fn(conn)
+		switch {
+		case err == nil || (!isConnectionError(ctx, err) && ctx.Err() == nil):
+			c.breaker.success()
+			return err
+		case ctx.Err() != nil:
+			c.breaker.cancel()
+			return err
+		}
+		c.breaker.failure()
+		c.reconnect(conn)
+	}
+	return err
+}
+
+// reconnect 连接出错后在后台重新建立连接，已在重连或连接已替换时忽略
+func (c *resilientClient) reconnect(failed milvusClient) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.reconnecting || c.closed || c.conn != failed {
+		return
+	}
+	c.reconnecting = true
+	go c.reconnectLoop()
+}
+
+// reconnectLoop 按指数退避重试建立连接，直到成功或客户端关闭
+func (c *resilientClient) reconnectLoop() {
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), reconnectDialTimeout)
+		conn, err := c.dial(ctx)
+		cancel()
+
+		c.mu.Lock()
+		if c.closed {
+			c.reconnecting = false
+			c.mu.Unlock()
+			if conn != nil {
+				conn.Close()
+			}
+			return
+		}
+		if err == nil {
+			old := c.conn
+			c.conn = conn
+			c.reconnecting = false
+			c.reconnects++
+			c.lastReconnect = time.Now()
+			c.lastError = ""
+			c.mu.Unlock()
+
+			old.Close()
+			slog.Info("已重新连接到Milvus", "attempt", attempt+1)
+			return
+		}
+		c.lastError = err.Error()
+		c.mu.Unlock()
+
+		wait := backoff(c.base, c.max, attempt)
+		slog.Warn("重新连接Milvus失败，稍后重试", "attempt", attempt+1, "retry_in", wait.String(), "error", err)
+		select {
+		case <-c.stop:
+			c.mu.Lock()
+			c.reconnecting = false
+			c.mu.Unlock()
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Stats 连接和熔断器状态
+func (c *resilientClient) Stats() map[string]interface{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	stats := map[string]interface{}{
+		"circuit_breaker": c.breaker.stats(),
+		"reconnecting":    c.reconnecting,
+		"reconnects":      c.reconnects,
+		"retry_attempts":  c.attempts,
+	}
+	if !c.lastReconnect.IsZero() {
+		stats["last_reconnect"] = c.lastReconnect
+	}
+	if c.lastError != "" {
+		stats["last_error"] = c.lastError
+	}
+	return stats
+}
+
+// Close 停止重连并关闭连接
+func (c *resilientClient) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	conn := c.conn
+	c.mu.Unlock()
+
+	close(c.stop)
+	return conn.Close()
+}
+
+// 以下为milvusClient的实现。查询类操作和删除（按条件删除可重复执行）会重试，
+// 插入、建表等非幂等操作只执行一次，但同样经过熔断器并在连接错误时触发重连
+
+func (c *resilientClient) CreateCollection(ctx context.Context, schema *entity.Schema, shardsNum int32, opts ...client.CreateCollectionOption) error {
+	return c.call(ctx, "create_collection", false, func(conn milvusClient) error {
+		return conn.CreateCollection(ctx, schema, shardsNum, opts...)
+	})
+}
+
+func (c *resilientClient) DescribeCollection(ctx context.Context, collName string) (coll *entity.Collection, err error) {
+	err = c.call(ctx, "describe_collection", true, func(conn milvusClient) (err error) {
+		coll, err = conn.DescribeCollection(ctx, collName)
+		return err
+	})
+	return coll, err
+}
+
+func (c *resilientClient) DropCollection(ctx context.Context, collName string, opts ...client.DropCollectionOption) error {
+	return c.call(ctx, "drop_collection", false, func(conn milvusClient) error {
+		return conn.DropCollection(ctx, collName, opts...)
+	})
+}
+
+func (c *resilientClient) GetCollectionStatistics(ctx context.Context, collName string) (stats map[string]string, err error) {
+	err = c.call(ctx, "get_collection_statistics", true, func(conn milvusClient) (err error) {
+		stats, err = conn.GetCollectionStatistics(ctx, collName)
+		return err
+	})
+	return stats, err
+}
+
+func (c *resilientClient) LoadCollection(ctx context.Context, collName string, async bool, opts ...client.LoadCollectionOption) error {
+	return c.call(ctx, "load_collection", true, func(conn milvusClient) error {
+		return conn.LoadCollection(ctx, collName, async, opts...)
+	})
+}
+
+func (c *resilientClient) ReleaseCollection(ctx context.Context, collName string, opts ...client.ReleaseCollectionOption) error {
+	return c.call(ctx, "release_collection", true, func(conn milvusClient) error {
+		return conn.ReleaseCollection(ctx, collName, opts...)
+	})
+}
+
+func (c *resilientClient) HasCollection(ctx context.Context, collName string) (has bool, err error) {
+	err = c.call(ctx, "has_collection", true, func(conn milvusClient) (err error) {
+		has, err = conn.HasCollection(ctx, collName)
+		return err
+	})
+	return has, err
+}
+
+func (c *resilientClient) RenameCollection(ctx context.Context, collName, newName string) error {
+	return c.call(ctx, "rename_collection", false, func(conn milvusClient) error {
+		return conn.RenameCollection(ctx, collName, newName)
+	})
+}
+
+func (c *resilientClient) CreateAlias(ctx context.Context, collName string, alias string) error {
+	return c.call(ctx, "create_alias", false, func(conn milvusClient) error {
+		return conn.CreateAlias(ctx, collName, alias)
+	})
+}
+
+func (c *resilientClient) DropAlias(ctx context.Context, alias string) error {
+	return c.call(ctx, "drop_alias", false, func(conn milvusClient) error {
+		return conn.DropAlias(ctx, alias)
+	})
+}
+
+func (c *resilientClient) AlterAlias(ctx context.Context, collName string, alias string) error {
+	return c.call(ctx, "alter_alias", false, func(conn milvusClient) error {
+		return conn.AlterAlias(ctx, collName, alias)
+	})
+}
+
+func (c *resilientClient) CreatePartition(ctx context.Context, collName string, partitionName string, opts ...client.CreatePartitionOption) error {
+	return c.call(ctx, "create_partition", false, func(conn milvusClient) error {
+		return conn.CreatePartition(ctx, collName, partitionName, opts...)
+	})
+}
+
+func (c *resilientClient) ShowPartitions(ctx context.Context, collName string) (partitions []*entity.Partition, err error) {
+	err = c.call(ctx, "show_partitions", true, func(conn milvusClient) (err error) {
+		partitions, err = conn.ShowPartitions(ctx, collName)
+		return err
+	})
+	return partitions, err
+}
+
+func (c *resilientClient) HasPartition(ctx context.Context, collName string, partitionName string) (has bool, err error) {
+	err = c.call(ctx, "has_partition", true, func(conn milvusClient) (err error) {
+		has, err = conn.HasPartition(ctx, collName, partitionName)
+		return err
+	})
+	return has, err
+}
+
+func (c *resilientClient) LoadPartitions(ctx context.Context, collName string, partitionNames []string, async bool, opts ...client.LoadPartitionsOption) error {
+	return c.call(ctx, "load_partitions", true, func(conn milvusClient) error {
+		return conn.LoadPartitions(ctx, collName, partitionNames, async, opts...)
+	})
+}
+
+func (c *resilientClient) ReleasePartitions(ctx context.Context, collName string, partitionNames []string, opts ...client.ReleasePartitionsOption) error {
+	return c.call(ctx, "release_partitions", true, func(conn milvusClient) error {
+		return conn.ReleasePartitions(ctx, collName, partitionNames, opts...)
+	})
+}
+
+func (c *resilientClient) CreateIndex(ctx context.Context, collName string, fieldName string, idx entity.Index, async bool, opts ...client.IndexOption) error {
+	return c.call(ctx, "create_index", false, func(conn milvusClient) error {
+		return conn.CreateIndex(ctx, collName, fieldName, idx, async, opts...)
+	})
+}
+
+func (c *resilientClient) Insert(ctx context.Context, collName string, partitionName string, columns ...entity.Column) (ids entity.Column, err error) {
+	err = c.call(ctx, "insert", false, func(conn milvusClient) (err error) {
+		ids, err = conn.Insert(ctx, collName, partitionName, columns...)
+		return err
+	})
+	return ids, err
+}
+
+func (c *resilientClient) Flush(ctx context.Context, collName string, async bool, opts ...client.FlushOption) error {
+	return c.call(ctx, "flush", true, func(conn milvusClient) error {
+		return conn.Flush(ctx, collName, async, opts...)
+	})
+}
+
+func (c *resilientClient) DeleteByPks(ctx context.Context, collName string, partitionName string, ids entity.Column) error {
+	return c.call(ctx, "delete_by_pks", true, func(conn milvusClient) error {
+		return conn.DeleteByPks(ctx, collName, partitionName, ids)
+	})
+}
+
+func (c *resilientClient) Delete(ctx context.Context, collName string, partitionName string, expr string) error {
+	return c.call(ctx, "delete", true, func(conn milvusClient) error {
+		return conn.Delete(ctx, collName, partitionName, expr)
+	})
+}
+
+func (c *resilientClient) Search(ctx context.Context, collName string, partitions []string, expr string, outputFields []string, vectors []entity.Vector, vectorField string, metricType entity.MetricType, topK int, sp entity.SearchParam, opts ...client.SearchQueryOptionFunc) (results []client.SearchResult, err error) {
+	err = c.call(ctx, "search", true, func(conn milvusClient) (err error) {
+		results, err = conn.Search(ctx, collName, partitions, expr, outputFields, vectors, vectorField, metricType, topK, sp, opts...)
+		return err
+	})
+	return results, err
+}
+
+func (c *resilientClient) Query(ctx context.Context, collectionName string, partitionNames []string, expr string, outputFields []string, opts ...client.SearchQueryOptionFunc) (resultSet client.ResultSet, err error) {
+	err = c.call(ctx, "query", true, func(conn milvusClient) (err error) {
+		resultSet, err = conn.Query(ctx, collectionName, partitionNames, expr, outputFields, opts...)
+		return err
+	})
+	return resultSet, err
+}
+
+func (c *resilientClient) GetLoadingProgress(ctx context.Context, collectionName string, partitionNames []string) (progress int64, err error) {
+	err = c.call(ctx, "get_loading_progress", true, func(conn milvusClient) (err error) {
+		progress, err = conn.GetLoadingProgress(ctx, collectionName, partitionNames)
+		return err
+	})
+	return progress, err
+}
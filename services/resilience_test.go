@@ -0,0 +1,591 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"image-search-go/config"
+
+	"github.com/milvus-io/milvus-sdk-go/v2/client"
+	"github.com/milvus-io/milvus-sdk-go/v2/entity"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errDropped 模拟连接断开时Milvus返回的错误
+var errDropped = status.Error(codes.Unavailable, "connection refused")
+
+// fakeMilvus 注入故障的Milvus客户端：按顺序返回预设的错误，并可设置每次调用的延迟。
+// 未实现的方法调用时panic
+type fakeMilvus struct {
+	milvusClient
+
+	mu          sync.Mutex
+	faults      []error // 依次返回的错误，nil表示成功，用完后调用成功
+	down        bool    // 为true时每次调用都返回errDropped（连接已断开）
+	latency     time.Duration
+	calls       int
+	closed      bool
+	collections map[string]bool
+	inserted    int
+	// insertStarted、insertGate 非nil时Insert先通知开始，再等待insertGate关闭
+	insertStarted chan struct{}
+	insertGate    chan struct{}
+	// writes 依次记录Insert和Delete调用
+	writes []string
+	// rowCounts 各collection的count(*)查询结果
+	rowCounts map[string]int64
+	// searches Search的调用次数
+	searches int
+}
+
+func newFakeMilvus() *fakeMilvus {
+	return &fakeMilvus{collections: map[string]bool{}}
+}
+
+// script 设置之后调用依次返回的错误
+func (f *fakeMilvus) script(faults ...error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults = append(f.faults, faults...)
+}
+
+// setDown 设置连接是否断开
+func (f *fakeMilvus) setDown(down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down = down
+}
+
+func (f *fakeMilvus) callCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+func (f *fakeMilvus) isClosed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.closed
+}
+
+// do 模拟一次调用：等待延迟后返回预设的错误
+func (f *fakeMilvus) do(ctx context.Context) error {
+	f.mu.Lock()
+	latency := f.latency
+	f.mu.Unlock()
+	if latency > 0 {
+		if err := sleepContext(ctx, latency); err != nil {
+			return err
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if f.closed {
+		return client.ErrClientNotReady
+	}
+	if f.down {
+		return errDropped
+	}
+	if len(f.faults) > 0 {
+		err := f.faults[0]
+		f.faults = f.faults[1:]
+		return err
+	}
+	return nil
+}
+
+func (f *fakeMilvus) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	return nil
+}
+
+func (f *fakeMilvus) HasCollection(ctx context.Context, collName string) (bool, error) {
+	if err := f.do(ctx); err != nil {
+		return false, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.collections[collName], nil
+}
+
+func (f *fakeMilvus) CreateCollection(ctx context.Context, schema *entity.Schema, shardsNum int32, opts ...client.CreateCollectionOption) error {
+	if err := f.do(ctx); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.collections[schema.CollectionName] = true
+	return nil
+}
+
+func (f *fakeMilvus) CreateIndex(ctx context.Context, collName string, fieldName string, idx entity.Index, async bool, opts ...client.IndexOption) error {
+	return f.do(ctx)
+}
+
+func (f *fakeMilvus) LoadCollection(ctx context.Context, collName string, async bool, opts ...client.LoadCollectionOption) error {
+	return f.do(ctx)
+}
+
+func (f *fakeMilvus) Insert(ctx context.Context, collName string, partitionName string, columns ...entity.Column) (entity.Column, error) {
+	f.mu.Lock()
+	started, gate := f.insertStarted, f.insertGate
+	f.mu.Unlock()
+	if gate != nil {
+		started <- struct{}{}
+		<-gate
+	}
+	if err := f.do(ctx); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(columns) > 0 {
+		f.inserted += columns[0].Len()
+	}
+	f.writes = append(f.writes, "insert")
+	return nil, nil
+}
+
+func (f *fakeMilvus) Flush(ctx context.Context, collName string, async bool, opts ...client.FlushOption) error {
+	return f.do(ctx)
+}
+
+func (f *fakeMilvus) Delete(ctx context.Context, collName string, partitionName string, expr string) error {
+	if err := f.do(ctx); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.writes = append(f.writes, "delete")
+	return nil
+}
+
+// Search 返回空结果
+func (f *fakeMilvus) Search(ctx context.Context, collName string, partitions []string, expr string, outputFields []string, vectors []entity.Vector, vectorField string, metricType entity.MetricType, topK int, sp entity.SearchParam, opts ...client.SearchQueryOptionFunc) ([]client.SearchResult, error) {
+	f.mu.Lock()
+	f.searches++
+	f.mu.Unlock()
+	if err := f.do(ctx); err != nil {
+		return nil, err
+	}
+	return make([]client.SearchResult, len(vectors)), nil
+}
+
+// Query 只支持count(*)查询
+func (f *fakeMilvus) Query(ctx context.Context, collectionName string, partitionNames []string, expr string, outputFields []string, opts ...client.SearchQueryOptionFunc) (client.ResultSet, error) {
+	if err := f.do(ctx); err != nil {
+		return nil, err
+	}
+	if len(outputFields) != 1 || outputFields[0] != "count(*)" {
+		return nil, fmt.Errorf("fakeMilvus不支持查询 %v", outputFields)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return client.ResultSet{entity.NewColumnInt64("count(*)", []int64{f.rowCounts[collectionName]})}, nil
+}
+
+// writeLog 返回Insert和Delete的调用顺序
+func (f *fakeMilvus) writeLog() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.writes...)
+}
+
+// fakeDialer 依次返回预设的连接错误，之后每次连接创建新的fakeMilvus
+type fakeDialer struct {
+	mu     sync.Mutex
+	errs   []error
+	conns  []*fakeMilvus
+	onDial func(conn *fakeMilvus)
+}
+
+func (d *fakeDialer) dial(ctx context.Context) (milvusClient, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.errs) > 0 {
+		err := d.errs[0]
+		d.errs = d.errs[1:]
+		return nil, err
+	}
+	conn := newFakeMilvus()
+	if d.onDial != nil {
+		d.onDial(conn)
+	}
+	d.conns = append(d.conns, conn)
+	return conn, nil
+}
+
+// setOnDial 设置新建连接时的回调（用于模拟Milvus仍不可用）
+func (d *fakeDialer) setOnDial(fn func(conn *fakeMilvus)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.onDial = fn
+}
+
+// failDials 之后n次连接都失败
+func (d *fakeDialer) failDials(n int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i := 0; i < n; i++ {
+		d.errs = append(d.errs, errors.New("dial tcp: connection refused"))
+	}
+}
+
+// conn 第i次成功建立的连接
+func (d *fakeDialer) conn(i int) *fakeMilvus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if i >= len(d.conns) {
+		return nil
+	}
+	return d.conns[i]
+}
+
+func (d *fakeDialer) dials() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.conns)
+}
+
+// testMilvusConfig 测试使用的Milvus配置：重试和熔断的等待时间很短
+func testMilvusConfig() *config.MilvusConfig {
+	return &config.MilvusConfig{
+		CollectionName:   "test_images",
+		Dimension:        8,
+		IndexType:        IndexFlat,
+		MetricType:       "L2",
+		RetryAttempts:    3,
+		RetryBackoff:     20 * time.Millisecond,
+		MaxBackoff:       100 * time.Millisecond,
+		BreakerThreshold: 3,
+		BreakerCooldown:  100 * time.Millisecond,
+	}
+}
+
+// waitFor 等待cond成立，超时后测试失败
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("等待超时: %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func breakerState(c *resilientClient) string {
+	return c.breaker.stats()["state"].(string)
+}
+
+func TestRetryWithBackoff(t *testing.T) {
+	cfg := testMilvusConfig()
+	cfg.BreakerThreshold = 0
+	dialer := &fakeDialer{}
+	c, err := newResilientClient(context.Background(), cfg, dialer.dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	// 重连一直失败，重试都发往同一个连接
+	dialer.failDials(100)
+
+	// 幂等操作：前两次连接错误，第三次成功
+	conn := dialer.conn(0)
+	conn.script(errDropped, errDropped)
+	start := time.Now()
+	if _, err := c.HasCollection(context.Background(), "test_images"); err != nil {
+		t.Fatalf("重试后应成功: %v", err)
+	}
+	elapsed := time.Since(start)
+	if calls := conn.callCount(); calls != 3 {
+		t.Fatalf("应调用3次，实际 %d 次", calls)
+	}
+	// 两次退避分别约为20ms和40ms（±20%抖动）
+	if min := 48 * time.Millisecond; elapsed < min {
+		t.Fatalf("重试间隔应按指数退避，总耗时 %s 小于 %s", elapsed, min)
+	}
+
+	// 超过最多尝试次数后返回最后一次的错误
+	before := conn.callCount()
+	conn.script(errDropped, errDropped, errDropped)
+	if _, err := c.HasCollection(context.Background(), "test_images"); !errors.Is(err, errDropped) {
+		t.Fatalf("应返回连接错误，实际 %v", err)
+	}
+	if calls := conn.callCount() - before; calls != cfg.RetryAttempts {
+		t.Fatalf("应尝试 %d 次，实际 %d 次", cfg.RetryAttempts, calls)
+	}
+}
+
+func TestNoRetryForNonIdempotentOrBusinessErrors(t *testing.T) {
+	cfg := testMilvusConfig()
+	dialer := &fakeDialer{}
+	c, err := newResilientClient(context.Background(), cfg, dialer.dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	dialer.failDials(100)
+	conn := dialer.conn(0)
+
+	// 插入不是幂等操作，连接错误时不重试
+	conn.script(errDropped)
+	if _, err := c.Insert(context.Background(), "test_images", ""); !errors.Is(err, errDropped) {
+		t.Fatalf("应返回连接错误，实际 %v", err)
+	}
+	if calls := conn.callCount(); calls != 1 {
+		t.Fatalf("插入应只执行1次，实际 %d 次", calls)
+	}
+
+	// 业务错误说明Milvus可以访问：不重试，并重置熔断器的失败计数
+	businessErr := errors.New("collection not found")
+	before := conn.callCount()
+	conn.script(businessErr)
+	if _, err := c.HasCollection(context.Background(), "missing"); !errors.Is(err, businessErr) {
+		t.Fatalf("应返回业务错误，实际 %v", err)
+	}
+	if calls := conn.callCount() - before; calls != 1 {
+		t.Fatalf("业务错误不应重试，实际调用 %d 次", calls)
+	}
+	if failures := c.breaker.stats()["consecutive_failures"]; failures != 0 {
+		t.Fatalf("业务错误后连续失败次数应为0，实际 %v", failures)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	cfg := testMilvusConfig()
+	cfg.RetryAttempts = 1
+	// 重连一直失败，所有调用都落在同一个断开的连接上
+	dialer := &fakeDialer{}
+	c, err := newResilientClient(context.Background(), cfg, dialer.dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	dialer.setOnDial(func(conn *fakeMilvus) { conn.setDown(true) })
+	conn := dialer.conn(0)
+	conn.setDown(true)
+
+	// 连续threshold次连接错误后熔断
+	for i := 0; i < cfg.BreakerThreshold; i++ {
+		if _, err := c.HasCollection(context.Background(), "test_images"); !errors.Is(err, errDropped) {
+			t.Fatalf("第 %d 次调用应返回连接错误，实际 %v", i+1, err)
+		}
+		if i < cfg.BreakerThreshold-1 && breakerState(c) != BreakerClosed {
+			t.Fatalf("失败 %d 次后不应熔断", i+1)
+		}
+	}
+	if state := breakerState(c); state != BreakerOpen {
+		t.Fatalf("连续失败后应熔断，实际状态 %s", state)
+	}
+
+	// 熔断期间直接失败，不调用Milvus
+	calls := c.current().(*fakeMilvus).callCount()
+	if _, err := c.HasCollection(context.Background(), "test_images"); !errors.Is(err, ErrMilvusUnavailable) {
+		t.Fatalf("熔断期间应返回ErrMilvusUnavailable，实际 %v", err)
+	}
+	if got := c.current().(*fakeMilvus).callCount(); got != calls {
+		t.Fatal("熔断期间不应调用Milvus")
+	}
+
+	// 冷却后放行一个探测请求，探测期间其他请求直接失败；探测失败时重新熔断
+	time.Sleep(cfg.BreakerCooldown + 20*time.Millisecond)
+	probe := c.current().(*fakeMilvus)
+	probe.mu.Lock()
+	probe.latency = 50 * time.Millisecond
+	probe.mu.Unlock()
+
+	probeDone := make(chan error, 1)
+	go func() {
+		_, err := c.HasCollection(context.Background(), "test_images")
+		probeDone <- err
+	}()
+	waitFor(t, "进入半开状态", func() bool { return breakerState(c) == BreakerHalfOpen })
+	if _, err := c.HasCollection(context.Background(), "test_images"); !errors.Is(err, ErrMilvusUnavailable) {
+		t.Fatalf("半开状态下只放行一个探测请求，实际 %v", err)
+	}
+	if err := <-probeDone; !errors.Is(err, errDropped) {
+		t.Fatalf("探测请求应返回连接错误，实际 %v", err)
+	}
+	stats := c.breaker.stats()
+	if stats["state"] != BreakerOpen || stats["trips"] != int64(2) {
+		t.Fatalf("探测失败后应重新熔断，实际 %v", stats)
+	}
+
+	// Milvus恢复后，冷却结束的探测请求成功，熔断器关闭
+	dialer.setOnDial(nil)
+	for i := 0; i < dialer.dials(); i++ {
+		dialer.conn(i).setDown(false)
+		dialer.conn(i).mu.Lock()
+		dialer.conn(i).latency = 0
+		dialer.conn(i).mu.Unlock()
+	}
+	time.Sleep(cfg.BreakerCooldown + 20*time.Millisecond)
+	if _, err := c.HasCollection(context.Background(), "test_images"); err != nil {
+		t.Fatalf("恢复后的探测请求应成功: %v", err)
+	}
+	stats = c.breaker.stats()
+	if stats["state"] != BreakerClosed || stats["consecutive_failures"] != 0 {
+		t.Fatalf("探测成功后应关闭熔断器，实际 %v", stats)
+	}
+}
+
+func TestCircuitBreakerCancelReleasesProbe(t *testing.T) {
+	b := newCircuitBreaker(1, 10*time.Millisecond)
+	b.failure()
+	time.Sleep(20 * time.Millisecond)
+
+	if err := b.allow(); err != nil {
+		t.Fatalf("冷却后应放行探测请求: %v", err)
+	}
+	if err := b.allow(); !errors.Is(err, ErrMilvusUnavailable) {
+		t.Fatal("探测期间应拒绝其他请求")
+	}
+	// 探测请求被调用方取消，释放探测名额但保持半开状态
+	b.cancel()
+	if err := b.allow(); err != nil {
+		t.Fatalf("取消后应放行新的探测请求: %v", err)
+	}
+	if state := b.stats()["state"]; state != BreakerHalfOpen {
+		t.Fatalf("取消不应改变状态，实际 %v", state)
+	}
+}
+
+func TestReconnectAfterDroppedConnection(t *testing.T) {
+	cfg := testMilvusConfig()
+	cfg.RetryAttempts = 1
+	// 第一次重连失败，第二次成功
+	dialer := &fakeDialer{}
+	c, err := newResilientClient(context.Background(), cfg, dialer.dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	dialer.failDials(1)
+
+	dropped := dialer.conn(0)
+	dropped.setDown(true)
+	if _, err := c.HasCollection(context.Background(), "test_images"); !errors.Is(err, errDropped) {
+		t.Fatalf("应返回连接错误，实际 %v", err)
+	}
+
+	// 后台按退避重连，成功后替换并关闭旧连接
+	waitFor(t, "重新连接", func() bool { return dialer.dials() == 2 && !c.Stats()["reconnecting"].(bool) })
+	stats := c.Stats()
+	if stats["reconnects"] != int64(1) {
+		t.Fatalf("应重连1次，实际 %v", stats["reconnects"])
+	}
+	if _, ok := stats["last_reconnect"]; !ok {
+		t.Fatal("应记录最近一次重连时间")
+	}
+	if !dropped.isClosed() {
+		t.Fatal("旧连接应被关闭")
+	}
+
+	fresh := dialer.conn(1)
+	if c.current() != fresh {
+		t.Fatal("应使用新连接")
+	}
+	if _, err := c.HasCollection(context.Background(), "test_images"); err != nil {
+		t.Fatalf("重连后调用应成功: %v", err)
+	}
+	if fresh.callCount() != 1 {
+		t.Fatal("重连后的调用应发往新连接")
+	}
+
+	// 重连期间的多次连接错误只触发一次重连
+	fresh.setDown(true)
+	for i := 0; i < 2; i++ {
+		c.HasCollection(context.Background(), "test_images")
+	}
+	waitFor(t, "再次重连", func() bool { return dialer.dials() == 3 && !c.Stats()["reconnecting"].(bool) })
+	time.Sleep(50 * time.Millisecond)
+	if n := dialer.dials(); n != 3 {
+		t.Fatalf("同一连接出错只应重连一次，实际建立 %d 个连接", n)
+	}
+}
+
+func TestCancelledCallDoesNotTripBreaker(t *testing.T) {
+	cfg := testMilvusConfig()
+	cfg.BreakerThreshold = 1
+	dialer := &fakeDialer{}
+	c, err := newResilientClient(context.Background(), cfg, dialer.dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	conn := dialer.conn(0)
+	conn.mu.Lock()
+	conn.latency = time.Second
+	conn.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := c.HasCollection(ctx, "test_images"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("应返回超时错误，实际 %v", err)
+	}
+	if state := breakerState(c); state != BreakerClosed {
+		t.Fatalf("调用方超时不应触发熔断，实际状态 %s", state)
+	}
+	if dialer.dials() != 1 {
+		t.Fatal("调用方超时不应触发重连")
+	}
+}
+
+func TestHealthReportsBreakerState(t *testing.T) {
+	cfg := testMilvusConfig()
+	cfg.RetryAttempts = 1
+	dialer := &fakeDialer{}
+	milvus, err := newMilvusService(context.Background(), cfg, dialer.dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer milvus.Close()
+
+	backend := &Backend{namespaces: &NamespaceManager{tenants: map[string]*Tenant{
+		DefaultNamespace: {Namespace: &Namespace{Name: DefaultNamespace}, Milvus: milvus},
+	}}}
+	health := NewHealthChecker(backend, &config.Config{Milvus: *cfg})
+
+	report := health.Run(context.Background(), CheckMilvus)
+	if !report.OK() {
+		t.Fatalf("Milvus正常时检查应通过: %+v", report.Checks[0])
+	}
+	breaker := report.Checks[0].Details["circuit_breaker"].(map[string]interface{})
+	if breaker["state"] != BreakerClosed {
+		t.Fatalf("熔断器应为closed，实际 %v", breaker["state"])
+	}
+
+	// Milvus断开：重连同样失败，连续失败后熔断，健康检查报告熔断状态
+	dialer.setOnDial(func(conn *fakeMilvus) { conn.setDown(true) })
+	dialer.conn(0).setDown(true)
+	for i := 0; i < cfg.BreakerThreshold; i++ {
+		health.Run(context.Background(), CheckMilvus)
+	}
+
+	report = health.Run(context.Background(), CheckMilvus)
+	result := report.Checks[0]
+	if report.OK() || result.Status != CheckFailed {
+		t.Fatalf("熔断时检查应失败: %+v", result)
+	}
+	breaker = result.Details["circuit_breaker"].(map[string]interface{})
+	if breaker["state"] != BreakerOpen {
+		t.Fatalf("熔断器应为open，实际 %v", breaker["state"])
+	}
+	if _, ok := breaker["opened_at"]; !ok {
+		t.Fatal("熔断时应报告开始时间")
+	}
+	if !errors.Is(milvus.HealthCheck(context.Background()), ErrMilvusUnavailable) {
+		t.Fatal("熔断期间健康检查应返回ErrMilvusUnavailable")
+	}
+}
@@ -0,0 +1,187 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/milvus-io/milvus-sdk-go/v2/entity"
+)
+
+// SchemaMigration collection结构的一个版本
+type SchemaMigration struct {
+	Version     int
+	Description string
+	Fields      []string // 该版本新增的字段
+}
+
+// schemaMigrations 按版本排列的结构变更，新增字段时在末尾追加一个版本
+var schemaMigrations = []SchemaMigration{
+	{Version: 1, Description: "初始结构", Fields: []string{"id", "image_id", "vector", "timestamp"}},
+	{Version: 2, Description: "增加文件信息字段", Fields: []string{fieldObjectKey, fieldMimeType, fieldFileSize}},
+}
+
+// LatestSchemaVersion 最新的结构版本
+var LatestSchemaVersion = schemaMigrations[len(schemaMigrations)-1].Version
+
+// ExpectedSchema 最新版本的collection结构
+func ExpectedSchema(collection string, dimension int) *entity.Schema {
+	return &entity.Schema{
+		CollectionName: collection,
+		Description:    "图像特征向量存储",
+		Fields: []*entity.Field{
+			{
+				Name:       "id",
+				DataType:   entity.FieldTypeInt64,
+				PrimaryKey: true,
+				AutoID:     true,
+			},
+			{
+				Name:     "image_id",
+				DataType: entity.FieldTypeVarChar,
+				TypeParams: map[string]string{
+					"max_length": "255",
+				},
+			},
+			{
+				Name:     "vector",
+				DataType: entity.FieldTypeFloatVector,
+				TypeParams: map[string]string{
+					"dim": fmt.Sprintf("%d", dimension),
+				},
+			},
+			{
+				Name:     "timestamp",
+				DataType: entity.FieldTypeInt64,
+			},
+			{
+				Name:     fieldObjectKey,
+				DataType: entity.FieldTypeVarChar,
+				TypeParams: map[string]string{
+					"max_length": "512",
+				},
+			},
+			{
+				Name:     fieldMimeType,
+				DataType: entity.FieldTypeVarChar,
+				TypeParams: map[string]string{
+					"max_length": "64",
+				},
+			},
+			{
+				Name:     fieldFileSize,
+				DataType: entity.FieldTypeInt64,
+			},
+		},
+	}
+}
+
+// SchemaDiff 现有collection与期望结构的差异
+type SchemaDiff struct {
+	Version           int      `json:"version"` // 现有collection对应的结构版本
+	MissingFields     []string `json:"missing_fields,omitempty"`
+	ExtraFields       []string `json:"extra_fields,omitempty"`
+	TypeMismatches    []string `json:"type_mismatches,omitempty"`
+	Dimension         int      `json:"dimension"`
+	ExpectedDimension int      `json:"expected_dimension"`
+}
+
+// Compatible 现有collection能否继续使用（字段类型和向量维度一致，缺少的字段可以通过迁移补齐）
+func (d *SchemaDiff) Compatible() bool {
+	return d.Version >= 1 && len(d.TypeMismatches) == 0 && d.Dimension == d.ExpectedDimension
+}
+
+// UpToDate 现有collection是否已是最新结构
+func (d *SchemaDiff) UpToDate() bool {
+	return d.Compatible() && len(d.MissingFields) == 0
+}
+
+func (d *SchemaDiff) String() string {
+	var parts []string
+	if d.Dimension != d.ExpectedDimension {
+		parts = append(parts, fmt.Sprintf("向量维度 %d，配置为 %d", d.Dimension, d.ExpectedDimension))
+	}
+	if len(d.TypeMismatches) > 0 {
+		parts = append(parts, "字段类型不一致: "+strings.Join(d.TypeMismatches, ", "))
+	}
+	if len(d.MissingFields) > 0 {
+		parts = append(parts, "缺少字段: "+strings.Join(d.MissingFields, ", "))
+	}
+	if len(d.ExtraFields) > 0 {
+		parts = append(parts, "多余字段: "+strings.Join(d.ExtraFields, ", "))
+	}
+	if len(parts) == 0 {
+		return "结构一致"
+	}
+	return strings.Join(parts, "；")
+}
+
+// CompareSchema 比较现有collection结构与期望结构
+func CompareSchema(live, expected *entity.Schema) *SchemaDiff {
+	diff := &SchemaDiff{}
+
+	liveFields := map[string]*entity.Field{}
+	for _, field := range live.Fields {
+		liveFields[field.Name] = field
+	}
+
+	expectedFields := map[string]bool{}
+	for _, field := range expected.Fields {
+		expectedFields[field.Name] = true
+
+		liveField, ok := liveFields[field.Name]
+		if !ok {
+			diff.MissingFields = append(diff.MissingFields, field.Name)
+			continue
+		}
+		if liveField.DataType != field.DataType || liveField.PrimaryKey != field.PrimaryKey {
+			diff.TypeMismatches = append(diff.TypeMismatches,
+				fmt.Sprintf("%s（%s，期望 %s）", field.Name, liveField.DataType.Name(), field.DataType.Name()))
+		}
+		if field.DataType == entity.FieldTypeFloatVector {
+			diff.ExpectedDimension, _ = strconv.Atoi(field.TypeParams["dim"])
+			diff.Dimension, _ = strconv.Atoi(liveField.TypeParams["dim"])
+		}
+	}
+	for _, field := range live.Fields {
+		if !expectedFields[field.Name] {
+			diff.ExtraFields = append(diff.ExtraFields, field.Name)
+		}
+	}
+
+	// 结构版本：所有字段都存在的最高版本
+	for _, migration := range schemaMigrations {
+		for _, name := range migration.Fields {
+			if _, ok := liveFields[name]; !ok {
+				return diff
+			}
+		}
+		diff.Version = migration.Version
+	}
+	return diff
+}
+
+// checkSchema 启动时比较现有collection与期望结构：不兼容时拒绝启动，缺少字段时回退为兼容模式并提示迁移
+func (s *MilvusService) checkSchema(ctx context.Context) error {
+	coll, err := s.client.DescribeCollection(ctx, s.collection)
+	if err != nil {
+		return fmt.Errorf("获取collection结构失败: %v", err)
+	}
+
+	diff := CompareSchema(coll.Schema, ExpectedSchema(s.collection, s.config.Dimension))
+	if !diff.Compatible() {
+		return fmt.Errorf("collection %s 的结构与配置不一致（%s），请运行 migrate plan 查看", s.collection, diff)
+	}
+
+	s.hasFileFields = diff.Version >= 2
+	if !diff.UpToDate() {
+		slog.WarnContext(ctx, "Collection结构不是最新版本，运行 migrate apply 升级",
+			"collection", s.collection, "version", diff.Version, "latest", LatestSchemaVersion, "diff", diff.String())
+	}
+	if !s.hasFileFields {
+		slog.WarnContext(ctx, "Collection缺少文件信息字段，搜索时将回退为按扩展名查找文件", "collection", s.collection)
+	}
+	return nil
+}
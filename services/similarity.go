@@ -0,0 +1,78 @@
+package services
+
+import "strings"
+
+// 距离度量类型
+const (
+	MetricL2     = "L2"
+	MetricIP     = "IP"
+	MetricCOSINE = "COSINE"
+)
+
+// MaxRangeResults 单次范围搜索返回的最大结果数（Milvus topK上限）
+const MaxRangeResults = 16384
+
+// HigherIsBetter 判断度量的原始分数是否越大越相似
+func HigherIsBetter(metricType string) bool {
+	switch strings.ToUpper(metricType) {
+	case MetricIP, MetricCOSINE:
+		return true
+	default:
+		return false
+	}
+}
+
+// ScoreToSimilarity 将Milvus返回的原始分数转换为[0,1]区间、越大越相似的相似度。
+// 特征向量已做L2归一化：L2返回的是平方距离d，对单位向量有 cos = 1 - d/2；
+// IP和COSINE返回的就是余弦相似度。
+func ScoreToSimilarity(metricType string, score float32) float32 {
+	var similarity float32
+	if HigherIsBetter(metricType) {
+		similarity = score
+	} else {
+		similarity = 1 - score/2
+	}
+	return clamp01(similarity)
+}
+
+// ScoreToDistance 将Milvus返回的原始分数转换为越小越相似的距离
+func ScoreToDistance(metricType string, score float32) float32 {
+	if HigherIsBetter(metricType) {
+		return 1 - score
+	}
+	return score
+}
+
+// SimilarityToScore 将相似度阈值转换为对应度量下的原始分数
+func SimilarityToScore(metricType string, similarity float32) float32 {
+	if HigherIsBetter(metricType) {
+		return similarity
+	}
+	return 2 * (1 - similarity)
+}
+
+// clamp01 将数值限制在[0,1]区间
+func clamp01(v float32) float32 {
+	if v < 0 {
+		return 0
+	}
+	if v > 1 {
+		return 1
+	}
+	return v
+}
+
+// FilterBySimilarity 过滤相似度低于阈值的结果
+func FilterBySimilarity(results []*SearchResult, minSimilarity float32) []*SearchResult {
+	if minSimilarity <= 0 {
+		return results
+	}
+
+	filtered := results[:0]
+	for _, result := range results {
+		if result.Score >= minSimilarity {
+			filtered = append(filtered, result)
+		}
+	}
+	return filtered
+}
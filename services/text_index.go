@@ -0,0 +1,206 @@
+package services
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// BM25参数
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// TextMatch 文本检索结果
+type TextMatch struct {
+	ImageID string
+	Score   float64
+}
+
+// TextIndex 进程内倒排索引，对图像的描述和标签做BM25检索
+type TextIndex struct {
+	mu          sync.RWMutex
+	postings    map[string]map[string]int // 词 -> image_id -> 词频
+	docLengths  map[string]int            // image_id -> 文档长度
+	docTerms    map[string][]string       // image_id -> 去重后的词，用于删除
+	namespaces  map[string]string         // image_id -> 命名空间
+	totalLength int
+}
+
+// NewTextIndex 创建空的文本索引
+func NewTextIndex() *TextIndex {
+	return &TextIndex{
+		postings:   map[string]map[string]int{},
+		docLengths: map[string]int{},
+		docTerms:   map[string][]string{},
+		namespaces: map[string]string{},
+	}
+}
+
+// Index 写入或替换一个图像的文本
+func (t *TextIndex) Index(meta *ImageMetadata) {
+	tokens := Tokenize(meta.Description + " " + strings.Join(meta.Tags, " "))
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.remove(meta.ImageID)
+	if len(tokens) == 0 {
+		return
+	}
+
+	frequencies := map[string]int{}
+	for _, token := range tokens {
+		frequencies[token]++
+	}
+	terms := make([]string, 0, len(frequencies))
+	for term, frequency := range frequencies {
+		if t.postings[term] == nil {
+			t.postings[term] = map[string]int{}
+		}
+		t.postings[term][meta.ImageID] = frequency
+		terms = append(terms, term)
+	}
+
+	t.docTerms[meta.ImageID] = terms
+	t.namespaces[meta.ImageID] = meta.Namespace
+	t.docLengths[meta.ImageID] = len(tokens)
+	t.totalLength += len(tokens)
+}
+
+// Remove 从索引中删除图像
+func (t *TextIndex) Remove(imageID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.remove(imageID)
+}
+
+func (t *TextIndex) remove(imageID string) {
+	for _, term := range t.docTerms[imageID] {
+		delete(t.postings[term], imageID)
+		if len(t.postings[term]) == 0 {
+			delete(t.postings, term)
+		}
+	}
+	t.totalLength -= t.docLengths[imageID]
+	delete(t.docTerms, imageID)
+	delete(t.docLengths, imageID)
+	delete(t.namespaces, imageID)
+}
+
+// Len 返回已索引的图像数
+func (t *TextIndex) Len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.docLengths)
+}
+
+// Search 按BM25分数返回命名空间内最相关的limit个图像
+func (t *TextIndex) Search(query, namespace string, limit int) []TextMatch {
+	scores := t.Score(query, nil)
+
+	t.mu.RLock()
+	matches := make([]TextMatch, 0, len(scores))
+	for imageID, score := range scores {
+		if sameNamespace(t.namespaces[imageID], namespace) {
+			matches = append(matches, TextMatch{ImageID: imageID, Score: score})
+		}
+	}
+	t.mu.RUnlock()
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].ImageID < matches[j].ImageID
+	})
+
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
+
+// Score 计算查询对各图像的BM25分数。only不为nil时只计算其中的图像
+func (t *TextIndex) Score(query string, only map[string]bool) map[string]float64 {
+	terms := Tokenize(query)
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	scores := map[string]float64{}
+	docCount := float64(len(t.docLengths))
+	if docCount == 0 {
+		return scores
+	}
+	avgLength := float64(t.totalLength) / docCount
+
+	seen := map[string]bool{}
+	for _, term := range terms {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+
+		postings := t.postings[term]
+		if len(postings) == 0 {
+			continue
+		}
+		df := float64(len(postings))
+		idf := math.Log(1 + (docCount-df+0.5)/(df+0.5))
+
+		for imageID, frequency := range postings {
+			if only != nil && !only[imageID] {
+				continue
+			}
+			tf := float64(frequency)
+			norm := 1 - bm25B + bm25B*float64(t.docLengths[imageID])/avgLength
+			scores[imageID] += idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+		}
+	}
+	return scores
+}
+
+// Tokenize 分词：英文和数字按非字母数字字符切分并转小写；
+// 中日韩文字没有空格分隔，按单字和相邻二字组切分
+func Tokenize(text string) []string {
+	var tokens []string
+	var word []rune
+	var cjk []rune
+
+	flushWord := func() {
+		if len(word) > 0 {
+			tokens = append(tokens, string(word))
+			word = word[:0]
+		}
+	}
+	flushCJK := func() {
+		for i, r := range cjk {
+			tokens = append(tokens, string(r))
+			if i+1 < len(cjk) {
+				tokens = append(tokens, string(cjk[i:i+2]))
+			}
+		}
+		cjk = cjk[:0]
+	}
+
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r):
+			flushWord()
+			cjk = append(cjk, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			flushCJK()
+			word = append(word, unicode.ToLower(r))
+		default:
+			flushWord()
+			flushCJK()
+		}
+	}
+	flushWord()
+	flushCJK()
+	return tokens
+}
@@ -0,0 +1,304 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"image-search-go/metrics"
+	"image-search-go/models"
+	"image-search-go/storage"
+	"image-search-go/tracing"
+	"image-search-go/utils"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 上传流程的各个阶段
+const (
+	StageInspect  = "inspect"  // 读取图像信息、移除EXIF
+	StageStore    = "store"    // 写入图像文件
+	StageExtract  = "extract"  // 提取特征
+	StageMetadata = "metadata" // 写入元数据
+	StageVector   = "vector"   // 写入向量
+)
+
+// compensationTimeout 失败回滚时删除已写入数据的超时时间
+const compensationTimeout = 10 * time.Second
+
+// ErrUploadInProgress 相同幂等键的上传仍在进行中
+var ErrUploadInProgress = errors.New("相同幂等键的上传正在进行中")
+
+// StageError 上传阶段错误
+type StageError struct {
+	Stage string
+	Err   error
+}
+
+func (e *StageError) Error() string {
+	return fmt.Sprintf("上传阶段 %s 失败: %v", e.Stage, e.Err)
+}
+
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+// VectorWriter 上传流程使用的向量写入接口
+type VectorWriter interface {
+	InsertImages(ctx context.Context, records []*ImageRecord) error
+	DeleteVector(ctx context.Context, imageID string) error
+}
+
+// MetadataWriter 上传流程使用的元数据写入接口
+type MetadataWriter interface {
+	Put(meta *ImageMetadata) error
+	Delete(imageID string) error
+}
+
+// UploadInput 上传流程输入
+type UploadInput struct {
+	IdempotencyKey string // 为空时不做幂等处理
+	Filename       string // 原始文件名
+	Data           []byte
+	Description    string
+	Tags           []string
+	Category       string // 按分类分区时决定写入的分区
+	ClientIP       string
+	UploadedBy     string // 上传者标识，未启用认证时为空
+	QuotaCharge    *QuotaCharge
+}
+
+// UploadResult 上传流程结果
+type UploadResult struct {
+	ImageID   string           `json:"image_id"`
+	ObjectKey string           `json:"object_key"`
+	ImageInfo *utils.ImageInfo `json:"image_info"`
+	Metadata  *ImageMetadata   `json:"metadata"`
+	// Replayed 为true表示结果来自之前相同幂等键的上传
+	Replayed bool `json:"-"`
+}
+
+// UploadPipeline 图像上传流程：按阶段执行，失败时按相反顺序执行补偿操作
+type UploadPipeline struct {
+	blobStore      storage.BlobStore
+	metadataStore  *MetadataStore // 幂等记录
+	metadata       MetadataWriter
+	vectors        VectorWriter
+	extractor      models.FeatureExtractor
+	stripExif      bool
+	idempotencyTTL time.Duration
+	// idempotencyLease 进行中幂等记录的有效期，超过后视为上传已中断（如进程崩溃），允许重试接管
+	idempotencyLease time.Duration
+	// namespace 所属命名空间，决定文件key前缀和幂等键的作用域
+	namespace string
+}
+
+// NewUploadPipeline 创建上传流程
+func NewUploadPipeline(blobStore storage.BlobStore, metadataStore *MetadataStore, vectors VectorWriter, extractor models.FeatureExtractor, stripExif bool, idempotencyTTL, idempotencyLease time.Duration) *UploadPipeline {
+	return &UploadPipeline{
+		blobStore:        blobStore,
+		metadataStore:    metadataStore,
+		metadata:         metadataStore,
+		vectors:          vectors,
+		extractor:        extractor,
+		stripExif:        stripExif,
+		idempotencyTTL:   idempotencyTTL,
+		idempotencyLease: idempotencyLease,
+	}
+}
+
+// WithVectors 返回使用另一个向量写入接口的上传流程（如按请求的一致性级别写入）
+func (p *UploadPipeline) WithVectors(vectors VectorWriter) *UploadPipeline {
+	scoped := *p
+	scoped.vectors = vectors
+	return &scoped
+}
+
+// Run 执行上传流程
+func (p *UploadPipeline) Run(ctx context.Context, in *UploadInput) (*UploadResult, error) {
+	if in.IdempotencyKey == "" {
+		return p.run(ctx, in)
+	}
+
+	idempotencyKey := p.idempotencyKey(in.IdempotencyKey)
+
+	existing, token, err := p.metadataStore.BeginIdempotent(idempotencyKey, p.idempotencyTTL, p.idempotencyLease)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		if existing.Status != IdempotencyCompleted {
+			return nil, ErrUploadInProgress
+		}
+		result := &UploadResult{}
+		if err := json.Unmarshal(existing.Result, result); err != nil {
+			return nil, fmt.Errorf("解析幂等记录失败: %v", err)
+		}
+		result.Replayed = true
+		return result, nil
+	}
+
+	result, err := p.run(ctx, in)
+	if err != nil {
+		if releaseErr := p.metadataStore.ReleaseIdempotent(idempotencyKey, token); releaseErr != nil {
+			slog.ErrorContext(ctx, "释放幂等键失败", "idempotency_key", idempotencyKey, "error", releaseErr)
+		}
+		return nil, err
+	}
+
+	// 上传已成功，记录失败只影响重试时的去重
+	if err := p.metadataStore.CompleteIdempotent(idempotencyKey, token, result); err != nil {
+		slog.ErrorContext(ctx, "记录幂等结果失败", "idempotency_key", idempotencyKey, "error", err)
+	}
+	return result, nil
+}
+
+// idempotencyKey 按命名空间隔离的幂等键。所有命名空间（含默认命名空间）都加前缀，
+// 分隔符\x00不会出现在请求头中，客户端无法构造出其他命名空间的键
+func (p *UploadPipeline) idempotencyKey(key string) string {
+	namespace := p.namespace
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+	return namespace + "\x00" + key
+}
+
+// run 依次执行各阶段
+func (p *UploadPipeline) run(ctx context.Context, in *UploadInput) (*UploadResult, error) {
+	imageID := uuid.New().String()
+	objectKey := NamespaceKeyPrefix(p.namespace) + imageID + filepath.Ext(in.Filename)
+	data := in.Data
+
+	// 每个阶段一个span，开始下一阶段或失败时结束上一阶段的span
+	var span trace.Span
+	begin := func(stage string) context.Context {
+		if span != nil {
+			span.End()
+		}
+		var stageCtx context.Context
+		stageCtx, span = tracing.Start(ctx, "upload."+stage)
+		return stageCtx
+	}
+	defer func() {
+		if span != nil {
+			span.End()
+		}
+	}()
+
+	var compensations []func() error
+	fail := func(stage string, err error) (*UploadResult, error) {
+		tracing.End(span, err)
+		span = nil
+		for i := len(compensations) - 1; i >= 0; i-- {
+			if cerr := compensations[i](); cerr != nil {
+				slog.ErrorContext(ctx, "上传补偿操作失败", "image_id", imageID, "error", cerr)
+			}
+		}
+		return nil, &StageError{Stage: stage, Err: err}
+	}
+
+	// 读取图像元数据（尺寸、EXIF），需在移除EXIF之前读取
+	begin(StageInspect)
+	span.SetAttributes(attribute.Int("image.size", len(data)))
+	imageInfo, err := utils.GetImageInfoFromBytes(data, objectKey)
+	if err != nil {
+		return fail(StageInspect, fmt.Errorf("读取图像信息失败: %v", err))
+	}
+	imageInfo.Path = objectKey
+
+	// 按配置移除存储文件中的EXIF隐私信息（保留方向标记）
+	if p.stripExif {
+		if data, err = utils.StripExif(data); err != nil {
+			return fail(StageInspect, fmt.Errorf("移除EXIF失败: %v", err))
+		}
+		imageInfo.Size = int64(len(data))
+	}
+	span.SetAttributes(attribute.Int("image.width", imageInfo.Width), attribute.Int("image.height", imageInfo.Height), attribute.String("image.format", imageInfo.Format))
+
+	// 保存文件
+	storeCtx := begin(StageStore)
+	mimeType := utils.ContentTypeByFilename(objectKey)
+	if err := p.blobStore.Put(storeCtx, objectKey, bytes.NewReader(data), int64(len(data)), mimeType); err != nil {
+		return fail(StageStore, err)
+	}
+	compensations = append(compensations, func() error {
+		cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), compensationTimeout)
+		defer cancel()
+		return p.blobStore.Delete(cleanupCtx, objectKey)
+	})
+
+	// 加载图像并提取特征（按EXIF方向自动校正）
+	extractCtx := begin(StageExtract)
+	img, err := utils.LoadImageFromBytes(data)
+	if err != nil {
+		return fail(StageExtract, err)
+	}
+	features, err := p.extractor.ExtractFeatures(extractCtx, img)
+	if err != nil {
+		return fail(StageExtract, err)
+	}
+
+	// 写入元数据
+	begin(StageMetadata)
+	meta := &ImageMetadata{
+		ImageID:          imageID,
+		ObjectKey:        objectKey,
+		MimeType:         mimeType,
+		FileSize:         int64(len(data)),
+		Width:            imageInfo.Width,
+		Height:           imageInfo.Height,
+		Format:           imageInfo.Format,
+		OriginalFilename: in.Filename,
+		Description:      in.Description,
+		Tags:             in.Tags,
+		Category:         in.Category,
+		Exif:             imageInfo.Exif,
+		ClientIP:         in.ClientIP,
+		UploadedBy:       in.UploadedBy,
+		QuotaCharge:      in.QuotaCharge,
+	}
+	if p.namespace != DefaultNamespace {
+		meta.Namespace = p.namespace
+	}
+	if err := p.metadata.Put(meta); err != nil {
+		return fail(StageMetadata, err)
+	}
+	compensations = append(compensations, func() error {
+		return p.metadata.Delete(imageID)
+	})
+
+	// 写入向量，同时记录文件key、类型和大小
+	vectorCtx := begin(StageVector)
+	record := &ImageRecord{
+		ImageID:   imageID,
+		Vector:    features,
+		ObjectKey: objectKey,
+		MimeType:  mimeType,
+		FileSize:  meta.FileSize,
+		Category:  in.Category,
+	}
+	if err := p.vectors.InsertImages(vectorCtx, []*ImageRecord{record}); err != nil {
+		// 插入可能已部分生效，补偿时一并删除向量（请求已超时或取消时仍需执行）
+		compensations = append(compensations, func() error {
+			cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), compensationTimeout)
+			defer cancel()
+			return p.vectors.DeleteVector(cleanupCtx, imageID)
+		})
+		return fail(StageVector, err)
+	}
+	metrics.AddUploadBytes(p.namespace, meta.FileSize)
+
+	return &UploadResult{
+		ImageID:   imageID,
+		ObjectKey: objectKey,
+		ImageInfo: imageInfo,
+		Metadata:  meta,
+	}, nil
+}
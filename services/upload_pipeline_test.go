@@ -0,0 +1,481 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"image-search-go/storage"
+)
+
+// errInjected 测试注入的阶段错误
+var errInjected = errors.New("injected failure")
+
+// journal 按调用顺序记录各fake的写入和补偿操作
+type journal struct {
+	mu  sync.Mutex
+	ops []string
+}
+
+func (j *journal) add(op string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.ops = append(j.ops, op)
+}
+
+func (j *journal) list() []string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return append([]string(nil), j.ops...)
+}
+
+// fakeBlobStore 内存中的文件存储
+type fakeBlobStore struct {
+	log     *journal
+	failPut bool
+
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (s *fakeBlobStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	if s.failPut {
+		return errInjected
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.objects[key] = data
+	s.mu.Unlock()
+	s.log.add("blob.put")
+	return nil
+}
+
+func (s *fakeBlobStore) Get(ctx context.Context, key string) (io.ReadCloser, *storage.ObjectInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.objects[key]
+	if !ok {
+		return nil, nil, storage.ErrNotFound
+	}
+	return io.NopCloser(bytes.NewReader(data)), &storage.ObjectInfo{Key: key, Size: int64(len(data))}, nil
+}
+
+func (s *fakeBlobStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	delete(s.objects, key)
+	s.mu.Unlock()
+	s.log.add("blob.delete")
+	return nil
+}
+
+func (s *fakeBlobStore) Stat(ctx context.Context, key string) (*storage.ObjectInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.objects[key]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return &storage.ObjectInfo{Key: key, Size: int64(len(data))}, nil
+}
+
+func (s *fakeBlobStore) SignedURL(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	return "/uploads/" + key, nil
+}
+
+func (s *fakeBlobStore) List(ctx context.Context, prefix string, fn func(info *storage.ObjectInfo) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, data := range s.objects {
+		if err := fn(&storage.ObjectInfo{Key: key, Size: int64(len(data))}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *fakeBlobStore) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.objects)
+}
+
+// fakeExtractor 返回固定特征的提取器
+type fakeExtractor struct {
+	fail bool
+}
+
+func (e *fakeExtractor) ExtractFeatures(ctx context.Context, img image.Image) ([]float32, error) {
+	if e.fail {
+		return nil, errInjected
+	}
+	return []float32{1, 0, 0, 0, 0, 0, 0, 0}, nil
+}
+
+func (e *fakeExtractor) GetDimension() int {
+	return 8
+}
+
+// fakeMetadataWriter 内存中的元数据存储
+type fakeMetadataWriter struct {
+	log     *journal
+	failPut bool
+
+	mu   sync.Mutex
+	rows map[string]*ImageMetadata
+}
+
+func (m *fakeMetadataWriter) Put(meta *ImageMetadata) error {
+	if m.failPut {
+		return errInjected
+	}
+	m.mu.Lock()
+	m.rows[meta.ImageID] = meta
+	m.mu.Unlock()
+	m.log.add("metadata.put")
+	return nil
+}
+
+func (m *fakeMetadataWriter) Delete(imageID string) error {
+	m.mu.Lock()
+	delete(m.rows, imageID)
+	m.mu.Unlock()
+	m.log.add("metadata.delete")
+	return nil
+}
+
+func (m *fakeMetadataWriter) count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.rows)
+}
+
+// fakeVectorWriter 内存中的向量存储。failInsert时模拟插入已部分生效后返回错误
+type fakeVectorWriter struct {
+	log        *journal
+	failInsert bool
+
+	mu      sync.Mutex
+	vectors map[string][]float32
+	// deleteCtxErr 补偿删除时ctx的状态，用于确认请求取消后补偿仍会执行
+	deleteCtxErr error
+}
+
+func (v *fakeVectorWriter) InsertImages(ctx context.Context, records []*ImageRecord) error {
+	v.mu.Lock()
+	for _, record := range records {
+		v.vectors[record.ImageID] = record.Vector
+	}
+	v.mu.Unlock()
+	v.log.add("vector.insert")
+	if v.failInsert {
+		return errInjected
+	}
+	return nil
+}
+
+func (v *fakeVectorWriter) DeleteVector(ctx context.Context, imageID string) error {
+	v.mu.Lock()
+	delete(v.vectors, imageID)
+	v.deleteCtxErr = ctx.Err()
+	v.mu.Unlock()
+	v.log.add("vector.delete")
+	return nil
+}
+
+func (v *fakeVectorWriter) count() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return len(v.vectors)
+}
+
+// pipelineFixture 使用fake依赖的上传流程，幂等记录使用临时目录中的真实BoltDB
+type pipelineFixture struct {
+	log       *journal
+	blobs     *fakeBlobStore
+	extractor *fakeExtractor
+	metadata  *fakeMetadataWriter
+	vectors   *fakeVectorWriter
+	store     *MetadataStore
+	pipeline  *UploadPipeline
+}
+
+func newPipelineFixture(t *testing.T) *pipelineFixture {
+	t.Helper()
+	store, err := NewMetadataStore(filepath.Join(t.TempDir(), "metadata.db"))
+	if err != nil {
+		t.Fatalf("NewMetadataStore: %v", err)
+	}
+	t.Cleanup(store.Close)
+
+	log := &journal{}
+	f := &pipelineFixture{
+		log:       log,
+		blobs:     &fakeBlobStore{log: log, objects: map[string][]byte{}},
+		extractor: &fakeExtractor{},
+		metadata:  &fakeMetadataWriter{log: log, rows: map[string]*ImageMetadata{}},
+		vectors:   &fakeVectorWriter{log: log, vectors: map[string][]float32{}},
+		store:     store,
+	}
+	f.pipeline = NewUploadPipeline(f.blobs, store, f.vectors, f.extractor, true, time.Hour, time.Minute)
+	f.pipeline.metadata = f.metadata
+	return f
+}
+
+// assertNoOrphans 确认失败后没有残留的文件、元数据和向量
+func (f *pipelineFixture) assertNoOrphans(t *testing.T) {
+	t.Helper()
+	if n := f.blobs.count(); n != 0 {
+		t.Errorf("不应残留文件，实际 %d 个", n)
+	}
+	if n := f.metadata.count(); n != 0 {
+		t.Errorf("不应残留元数据，实际 %d 条", n)
+	}
+	if n := f.vectors.count(); n != 0 {
+		t.Errorf("不应残留向量，实际 %d 条", n)
+	}
+}
+
+// testPNG 生成一张小的PNG图像
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 16, 12))
+	for y := 0; y < 12; y++ {
+		for x := 0; x < 16; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 16), G: uint8(y * 20), B: 128, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("png.Encode: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestUploadPipelineSuccess(t *testing.T) {
+	f := newPipelineFixture(t)
+	result, err := f.pipeline.Run(context.Background(), &UploadInput{Filename: "cat.png", Data: testPNG(t)})
+	if err != nil {
+		t.Fatalf("上传应成功: %v", err)
+	}
+	if result.ObjectKey != result.ImageID+".png" {
+		t.Errorf("文件key应为 %q，实际 %q", result.ImageID+".png", result.ObjectKey)
+	}
+	if result.ImageInfo.Width != 16 || result.ImageInfo.Height != 12 {
+		t.Errorf("图像尺寸应为16x12，实际 %dx%d", result.ImageInfo.Width, result.ImageInfo.Height)
+	}
+	if f.blobs.count() != 1 || f.metadata.count() != 1 || f.vectors.count() != 1 {
+		t.Errorf("文件、元数据、向量应各1条，实际 %d/%d/%d", f.blobs.count(), f.metadata.count(), f.vectors.count())
+	}
+	want := []string{"blob.put", "metadata.put", "vector.insert"}
+	if got := f.log.list(); !reflect.DeepEqual(got, want) {
+		t.Errorf("操作顺序应为 %v，实际 %v", want, got)
+	}
+}
+
+func TestUploadPipelineStageFailures(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte // 为nil时使用有效的PNG
+		inject func(f *pipelineFixture)
+		stage  string
+		// ops 期望的操作顺序：已完成的写入，然后按相反顺序执行的补偿
+		ops []string
+	}{
+		{
+			name:  "inspect",
+			data:  []byte("not an image"),
+			stage: StageInspect,
+		},
+		{
+			name:   "store",
+			inject: func(f *pipelineFixture) { f.blobs.failPut = true },
+			stage:  StageStore,
+		},
+		{
+			name:   "extract",
+			inject: func(f *pipelineFixture) { f.extractor.fail = true },
+			stage:  StageExtract,
+			ops:    []string{"blob.put", "blob.delete"},
+		},
+		{
+			name:   "metadata",
+			inject: func(f *pipelineFixture) { f.metadata.failPut = true },
+			stage:  StageMetadata,
+			ops:    []string{"blob.put", "blob.delete"},
+		},
+		{
+			name:   "vector",
+			inject: func(f *pipelineFixture) { f.vectors.failInsert = true },
+			stage:  StageVector,
+			ops:    []string{"blob.put", "metadata.put", "vector.insert", "vector.delete", "metadata.delete", "blob.delete"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newPipelineFixture(t)
+			if tt.inject != nil {
+				tt.inject(f)
+			}
+			data := tt.data
+			if data == nil {
+				data = testPNG(t)
+			}
+
+			_, err := f.pipeline.Run(context.Background(), &UploadInput{Filename: "cat.png", Data: data})
+			var stageErr *StageError
+			if !errors.As(err, &stageErr) {
+				t.Fatalf("应返回StageError，实际 %v", err)
+			}
+			if stageErr.Stage != tt.stage {
+				t.Errorf("失败阶段应为 %s，实际 %s", tt.stage, stageErr.Stage)
+			}
+			if tt.data == nil && !errors.Is(err, errInjected) {
+				t.Errorf("应包装注入的错误，实际 %v", err)
+			}
+			if got := f.log.list(); !reflect.DeepEqual(got, tt.ops) {
+				t.Errorf("操作顺序应为 %v，实际 %v", tt.ops, got)
+			}
+			f.assertNoOrphans(t)
+		})
+	}
+}
+
+func TestUploadPipelineCompensatesAfterCancel(t *testing.T) {
+	f := newPipelineFixture(t)
+	f.vectors.failInsert = true
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := f.pipeline.Run(ctx, &UploadInput{Filename: "cat.png", Data: testPNG(t)}); err == nil {
+		t.Fatal("写入向量失败时上传应失败")
+	}
+	if f.vectors.deleteCtxErr != nil {
+		t.Errorf("请求取消后删除向量的补偿不应使用已取消的context: %v", f.vectors.deleteCtxErr)
+	}
+	f.assertNoOrphans(t)
+}
+
+func TestUploadPipelineIdempotency(t *testing.T) {
+	f := newPipelineFixture(t)
+	in := &UploadInput{IdempotencyKey: "req-1", Filename: "cat.png", Data: testPNG(t)}
+
+	// 失败后释放幂等键，相同键可以重试
+	f.vectors.failInsert = true
+	if _, err := f.pipeline.Run(context.Background(), in); err == nil {
+		t.Fatal("写入向量失败时上传应失败")
+	}
+	f.assertNoOrphans(t)
+
+	f.vectors.failInsert = false
+	first, err := f.pipeline.Run(context.Background(), in)
+	if err != nil {
+		t.Fatalf("失败后使用相同幂等键重试应成功: %v", err)
+	}
+	if first.Replayed {
+		t.Error("首次成功的上传不应标记为重放")
+	}
+
+	// 相同键返回之前的结果，不再写入
+	second, err := f.pipeline.Run(context.Background(), in)
+	if err != nil {
+		t.Fatalf("重放应成功: %v", err)
+	}
+	if !second.Replayed || second.ImageID != first.ImageID {
+		t.Errorf("应重放 %s 的结果，实际 %+v", first.ImageID, second)
+	}
+	if n := f.blobs.count(); n != 1 {
+		t.Errorf("重放不应再写入文件，实际 %d 个", n)
+	}
+
+	// 其他命名空间使用相同的键不会得到默认命名空间的结果
+	scoped := *f.pipeline
+	scoped.namespace = "team_a"
+	other, err := scoped.Run(context.Background(), in)
+	if err != nil {
+		t.Fatalf("其他命名空间上传应成功: %v", err)
+	}
+	if other.Replayed || other.ImageID == first.ImageID {
+		t.Errorf("其他命名空间不应重放默认命名空间的结果 %s", first.ImageID)
+	}
+}
+
+func TestUploadPipelineIdempotencyLease(t *testing.T) {
+	f := newPipelineFixture(t)
+	f.pipeline.idempotencyLease = 50 * time.Millisecond
+	in := &UploadInput{IdempotencyKey: "req-1", Filename: "cat.png", Data: testPNG(t)}
+
+	// 模拟上传进行中进程崩溃：占用幂等键后没有完成或释放
+	key := f.pipeline.idempotencyKey(in.IdempotencyKey)
+	_, crashed, err := f.store.BeginIdempotent(key, time.Hour, f.pipeline.idempotencyLease)
+	if err != nil {
+		t.Fatalf("BeginIdempotent: %v", err)
+	}
+	if _, err := f.pipeline.Run(context.Background(), in); !errors.Is(err, ErrUploadInProgress) {
+		t.Fatalf("占用期内重试应返回ErrUploadInProgress，实际 %v", err)
+	}
+
+	// 占用过期后重试接管幂等键
+	time.Sleep(60 * time.Millisecond)
+	result, err := f.pipeline.Run(context.Background(), in)
+	if err != nil {
+		t.Fatalf("占用过期后重试应成功: %v", err)
+	}
+
+	// 原上传不能再释放或覆盖接管后的结果
+	if err := f.store.ReleaseIdempotent(key, crashed); err != nil {
+		t.Fatalf("ReleaseIdempotent: %v", err)
+	}
+	if err := f.store.CompleteIdempotent(key, crashed, &UploadResult{ImageID: "stale"}); err == nil {
+		t.Error("被接管后完成幂等记录应返回错误")
+	}
+	replayed, err := f.pipeline.Run(context.Background(), in)
+	if err != nil || !replayed.Replayed || replayed.ImageID != result.ImageID {
+		t.Errorf("应重放接管后上传 %s 的结果，实际 %+v, %v", result.ImageID, replayed, err)
+	}
+}
+
+func TestPruneIdempotent(t *testing.T) {
+	f := newPipelineFixture(t)
+	lease := 50 * time.Millisecond
+
+	if _, token, err := f.store.BeginIdempotent("done", time.Hour, lease); err != nil {
+		t.Fatalf("BeginIdempotent: %v", err)
+	} else if err := f.store.CompleteIdempotent("done", token, &UploadResult{ImageID: "img"}); err != nil {
+		t.Fatalf("CompleteIdempotent: %v", err)
+	}
+	for _, key := range []string{"stale-1", "stale-2"} {
+		if _, _, err := f.store.BeginIdempotent(key, time.Hour, lease); err != nil {
+			t.Fatalf("BeginIdempotent: %v", err)
+		}
+	}
+	time.Sleep(60 * time.Millisecond)
+
+	pruned, err := f.store.PruneIdempotent(time.Hour, lease)
+	if err != nil {
+		t.Fatalf("PruneIdempotent: %v", err)
+	}
+	if pruned != 2 {
+		t.Errorf("应清理2条过期的进行中记录，实际 %d 条", pruned)
+	}
+	if existing, _, err := f.store.BeginIdempotent("done", time.Hour, lease); err != nil || existing == nil {
+		t.Errorf("未过期的完成记录应保留，实际 %+v, %v", existing, err)
+	}
+
+	// 完成记录超过ttl后同样清理
+	if pruned, err := f.store.PruneIdempotent(0, lease); err != nil || pruned != 1 {
+		t.Errorf("应清理1条过期的完成记录，实际 %d 条, %v", pruned, err)
+	}
+}
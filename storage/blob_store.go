@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"image-search-go/config"
+)
+
+// ErrNotFound 对象不存在
+var ErrNotFound = errors.New("对象不存在")
+
+// ObjectInfo 对象元信息
+type ObjectInfo struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	ContentType  string    `json:"content_type"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+// BlobStore 图像文件存储接口，ctx用于取消请求和限定请求的截止时间
+type BlobStore interface {
+	// Put 写入对象，size为-1时表示长度未知
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get 读取对象，调用方负责关闭返回的ReadCloser
+	Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error)
+	// Delete 删除对象，对象不存在时不返回错误
+	Delete(ctx context.Context, key string) error
+	// Stat 获取对象元信息，对象不存在时返回ErrNotFound
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	// SignedURL 生成对象的临时访问地址
+	SignedURL(ctx context.Context, key string, expiry time.Duration) (string, error)
+	// List 遍历指定前缀下的全部对象，fn返回错误时停止遍历
+	List(ctx context.Context, prefix string, fn func(info *ObjectInfo) error) error
+}
+
+// NewBlobStore 根据配置创建存储实例，ctx限定初始化检查（S3 bucket）的时间
+func NewBlobStore(ctx context.Context, cfg *config.Config) (BlobStore, error) {
+	switch cfg.Storage.Backend {
+	case "", "local":
+		return NewLocalBlobStore(cfg.Server.UploadPath, "/uploads")
+	case "s3":
+		return NewS3BlobStore(ctx, &cfg.Storage)
+	default:
+		return nil, fmt.Errorf("不支持的存储后端: %s", cfg.Storage.Backend)
+	}
+}
@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// LocalBlobStore 本地文件系统存储
+type LocalBlobStore struct {
+	root      string
+	publicURL string
+}
+
+// NewLocalBlobStore 创建本地文件系统存储，publicURL为静态文件访问前缀
+func NewLocalBlobStore(root, publicURL string) (*LocalBlobStore, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("创建存储目录失败: %v", err)
+	}
+
+	return &LocalBlobStore{
+		root:      root,
+		publicURL: strings.TrimRight(publicURL, "/"),
+	}, nil
+}
+
+// path 将对象key转换为本地路径，拒绝越出根目录的key
+func (s *LocalBlobStore) path(key string) (string, error) {
+	cleaned := path.Clean("/" + key)
+	if cleaned == "/" {
+		return "", fmt.Errorf("无效的对象key: %q", key)
+	}
+	return filepath.Join(s.root, filepath.FromSlash(cleaned)), nil
+}
+
+// Put 写入对象，先写临时文件再重命名，避免读到不完整的文件
+func (s *LocalBlobStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	fullPath, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return fmt.Errorf("创建目录失败: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(fullPath), ".upload-*")
+	if err != nil {
+		return fmt.Errorf("创建临时文件失败: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return fmt.Errorf("写入文件失败: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("写入文件失败: %v", err)
+	}
+
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("设置文件权限失败: %v", err)
+	}
+	return os.Rename(tmp.Name(), fullPath)
+}
+
+// Get 读取对象
+func (s *LocalBlobStore) Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	fullPath, err := s.path(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file, err := os.Open(fullPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, ErrNotFound
+		}
+		return nil, nil, fmt.Errorf("打开文件失败: %v", err)
+	}
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("获取文件信息失败: %v", err)
+	}
+
+	return file, s.objectInfo(key, fileInfo), nil
+}
+
+// Delete 删除对象
+func (s *LocalBlobStore) Delete(ctx context.Context, key string) error {
+	fullPath, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(fullPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("删除文件失败: %v", err)
+	}
+	return nil
+}
+
+// Stat 获取对象元信息
+func (s *LocalBlobStore) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	fullPath, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	fileInfo, err := os.Stat(fullPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("获取文件信息失败: %v", err)
+	}
+	if fileInfo.IsDir() {
+		return nil, ErrNotFound
+	}
+
+	return s.objectInfo(key, fileInfo), nil
+}
+
+// SignedURL 本地存储通过静态文件路由访问，无需签名
+func (s *LocalBlobStore) SignedURL(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	return s.publicURL + "/" + strings.TrimLeft(key, "/"), nil
+}
+
+// List 遍历根目录下的文件，跳过隐藏文件和写入中的临时文件。ctx取消时停止遍历
+func (s *LocalBlobStore) List(ctx context.Context, prefix string, fn func(info *ObjectInfo) error) error {
+	return filepath.Walk(s.root, func(fullPath string, fileInfo os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if strings.HasPrefix(fileInfo.Name(), ".") {
+			if fileInfo.IsDir() && fullPath != s.root {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if fileInfo.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.root, fullPath)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		return fn(s.objectInfo(key, fileInfo))
+	})
+}
+
+// objectInfo 根据文件信息构造对象元信息
+func (s *LocalBlobStore) objectInfo(key string, fileInfo os.FileInfo) *ObjectInfo {
+	return &ObjectInfo{
+		Key:          key,
+		Size:         fileInfo.Size(),
+		ContentType:  mime.TypeByExtension(filepath.Ext(key)),
+		LastModified: fileInfo.ModTime(),
+	}
+}
@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"image-search-go/config"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// s3MaxPresignAge 预签名地址的最长有效期（SigV4限制）
+const s3MaxPresignAge = 7 * 24 * time.Hour
+
+// S3BlobStore S3兼容对象存储（AWS S3、MinIO等），使用path-style访问
+type S3BlobStore struct {
+	client *minio.Client
+	bucket string
+}
+
+// NewS3BlobStore 创建S3兼容存储，并确保bucket存在
+func NewS3BlobStore(ctx context.Context, cfg *config.StorageConfig) (*S3BlobStore, error) {
+	client, err := newS3Client(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &S3BlobStore{client: client, bucket: cfg.S3Bucket}
+	if err := store.ensureBucket(ctx, cfg.S3Region); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// newS3Client 根据配置创建S3客户端，endpoint可带http://或https://前缀
+func newS3Client(cfg *config.StorageConfig) (*minio.Client, error) {
+	if cfg.S3Endpoint == "" || cfg.S3Bucket == "" {
+		return nil, fmt.Errorf("S3存储需要配置endpoint和bucket")
+	}
+
+	endpoint := strings.TrimPrefix(strings.TrimPrefix(cfg.S3Endpoint, "http://"), "https://")
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:        credentials.NewStaticV4(cfg.S3AccessKey, cfg.S3SecretKey, ""),
+		Secure:       cfg.S3UseSSL,
+		Region:       cfg.S3Region,
+		BucketLookup: minio.BucketLookupPath,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("无效的S3 endpoint: %v", err)
+	}
+	return client, nil
+}
+
+// ensureBucket 检查bucket是否存在，不存在则创建
+func (s *S3BlobStore) ensureBucket(ctx context.Context, region string) error {
+	exists, err := s.client.BucketExists(ctx, s.bucket)
+	if err != nil {
+		return fmt.Errorf("检查bucket失败: %v", err)
+	}
+	if exists {
+		return nil
+	}
+	if err := s.client.MakeBucket(ctx, s.bucket, minio.MakeBucketOptions{Region: region}); err != nil {
+		return fmt.Errorf("创建bucket失败: %v", err)
+	}
+	return nil
+}
+
+// Put 写入对象。请求体不参与签名（UNSIGNED-PAYLOAD），长度已知时直接流式上传；
+// 长度未知时先读入内存，以单个请求上传（图像不大，避免分片上传）
+func (s *S3BlobStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	if size < 0 {
+		body, err := io.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("读取数据失败: %v", err)
+		}
+		r, size = bytes.NewReader(body), int64(len(body))
+	}
+
+	_, err := s.client.PutObject(ctx, s.bucket, key, r, size, minio.PutObjectOptions{
+		ContentType:          contentType,
+		DisableContentSha256: true,
+	})
+	if err != nil {
+		return fmt.Errorf("上传对象失败: %v", err)
+	}
+	return nil
+}
+
+// Get 读取对象
+func (s *S3BlobStore) Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	obj, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("读取对象失败: %v", err)
+	}
+	// GetObject在首次读取或Stat时才发送请求
+	stat, err := obj.Stat()
+	if err != nil {
+		obj.Close()
+		if isS3NotFound(err) {
+			return nil, nil, ErrNotFound
+		}
+		return nil, nil, fmt.Errorf("读取对象失败: %v", err)
+	}
+	return obj, objectInfoFromS3(stat), nil
+}
+
+// Delete 删除对象
+func (s *S3BlobStore) Delete(ctx context.Context, key string) error {
+	if err := s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{}); err != nil && !isS3NotFound(err) {
+		return fmt.Errorf("删除对象失败: %v", err)
+	}
+	return nil
+}
+
+// Stat 获取对象元信息
+func (s *S3BlobStore) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	stat, err := s.client.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		if isS3NotFound(err) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("获取对象信息失败: %v", err)
+	}
+	return objectInfoFromS3(stat), nil
+}
+
+// SignedURL 生成预签名GET地址
+func (s *S3BlobStore) SignedURL(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	if expiry < time.Second || expiry > s3MaxPresignAge {
+		return "", fmt.Errorf("无效的签名有效期: %v", expiry)
+	}
+	u, err := s.client.PresignedGetObject(ctx, s.bucket, key, expiry, nil)
+	if err != nil {
+		return "", fmt.Errorf("生成签名地址失败: %v", err)
+	}
+	return u.String(), nil
+}
+
+// List 分页遍历指定前缀下的对象
+func (s *S3BlobStore) List(ctx context.Context, prefix string, fn func(info *ObjectInfo) error) error {
+	// fn返回错误提前结束时取消，停止后台分页请求
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return fmt.Errorf("列出对象失败: %v", obj.Err)
+		}
+		if err := fn(objectInfoFromS3(obj)); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// isS3NotFound 对象不存在（HEAD请求没有响应体，客户端按404同样返回NoSuchKey）
+func isS3NotFound(err error) bool {
+	return minio.ToErrorResponse(err).Code == "NoSuchKey"
+}
+
+// objectInfoFromS3 转换S3对象信息
+func objectInfoFromS3(info minio.ObjectInfo) *ObjectInfo {
+	return &ObjectInfo{
+		Key:          info.Key,
+		Size:         info.Size,
+		ContentType:  info.ContentType,
+		LastModified: info.LastModified,
+	}
+}
@@ -0,0 +1,579 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"image-search-go/config"
+)
+
+const (
+	testAccessKey = "AKIDTEST"
+	testSecretKey = "test-secret-key"
+	testRegion    = "us-east-1"
+	testBucket    = "images"
+
+	sigV4Algorithm  = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat = "20060102T150405Z"
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+// s3Object fakeS3中保存的对象
+type s3Object struct {
+	data        []byte
+	contentType string
+	modified    time.Time
+}
+
+// fakeS3 S3的最小替身：path-style访问单个bucket，独立按SigV4规则校验请求头签名和预签名地址
+type fakeS3 struct {
+	t *testing.T
+
+	mu           sync.Mutex
+	bucketExists bool
+	objects      map[string]*s3Object
+	// lastPut 最近一次PUT对象请求的Content-Length、Transfer-Encoding和x-amz-content-sha256
+	lastPut struct {
+		contentLength    int64
+		transferEncoding []string
+		payloadHash      string
+	}
+	// putStarted 收到PUT对象请求（请求头）时通知，用于确认请求体是流式发送的
+	putStarted chan struct{}
+	// listPageSize 列出对象时每页的最大数量，listPages 已返回的页数
+	listPageSize int
+	listPages    int
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *httptest.Server) {
+	f := &fakeS3{t: t, objects: map[string]*s3Object{}, putStarted: make(chan struct{}, 16), listPageSize: 1000}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rawPath, _, _ := strings.Cut(r.RequestURI, "?")
+	if err := f.verify(r, rawPath); err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w, "<Error><Code>SignatureDoesNotMatch</Code><Message>%s</Message></Error>", err)
+		return
+	}
+
+	path, err := url.PathUnescape(rawPath)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if bucket != testBucket {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if key == "" {
+		switch r.Method {
+		case http.MethodHead:
+			if !f.bucketExists {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		case http.MethodPut:
+			f.bucketExists = true
+		case http.MethodGet:
+			f.listObjects(w, r.URL.Query())
+		default:
+			w.WriteHeader(http.StatusNotImplemented)
+		}
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		f.lastPut.contentLength = r.ContentLength
+		f.lastPut.transferEncoding = r.TransferEncoding
+		f.lastPut.payloadHash = r.Header.Get("X-Amz-Content-Sha256")
+		f.putStarted <- struct{}{}
+		// 读取请求体时释放锁，发送方可能在等待putStarted之后才写完
+		f.mu.Unlock()
+		data, err := io.ReadAll(r.Body)
+		f.mu.Lock()
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if hash := r.Header.Get("X-Amz-Content-Sha256"); hash != unsignedPayload {
+			if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != hash {
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, "<Error><Code>XAmzContentSHA256Mismatch</Code></Error>")
+				return
+			}
+		}
+		f.objects[key] = &s3Object{data: data, contentType: r.Header.Get("Content-Type"), modified: time.Now()}
+	case http.MethodGet, http.MethodHead:
+		obj, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", obj.contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(obj.data)))
+		w.Header().Set("Last-Modified", obj.modified.UTC().Format(http.TimeFormat))
+		if r.Method == http.MethodGet {
+			w.Write(obj.data)
+		}
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+// listObjects 按ListObjectsV2返回前缀下的对象，每页最多max-keys个，continuation-token为上一页最后的key
+func (f *fakeS3) listObjects(w http.ResponseWriter, query url.Values) {
+	if query.Get("list-type") != "2" {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+	maxKeys, err := strconv.Atoi(query.Get("max-keys"))
+	if err != nil || maxKeys <= 0 || maxKeys > f.listPageSize {
+		maxKeys = f.listPageSize
+	}
+
+	keys := make([]string, 0, len(f.objects))
+	for key := range f.objects {
+		if strings.HasPrefix(key, query.Get("prefix")) && key > query.Get("continuation-token") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	type content struct {
+		Key          string
+		Size         int
+		LastModified string
+	}
+	result := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Name                  string
+		Prefix                string
+		KeyCount              int
+		MaxKeys               int
+		IsTruncated           bool
+		NextContinuationToken string `xml:",omitempty"`
+		Contents              []content
+	}{Name: testBucket, Prefix: query.Get("prefix"), MaxKeys: maxKeys}
+	if len(keys) > maxKeys {
+		keys = keys[:maxKeys]
+		result.IsTruncated = true
+		result.NextContinuationToken = keys[len(keys)-1]
+	}
+	for _, key := range keys {
+		obj := f.objects[key]
+		result.Contents = append(result.Contents, content{Key: key, Size: len(obj.data), LastModified: obj.modified.UTC().Format(time.RFC3339)})
+	}
+	result.KeyCount = len(result.Contents)
+	f.listPages++
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+// verify 按请求头（Authorization）或预签名查询参数校验SigV4签名
+func (f *fakeS3) verify(r *http.Request, rawPath string) error {
+	query := r.URL.Query()
+	if query.Get("X-Amz-Signature") != "" {
+		return f.verifyPresigned(r, rawPath, query)
+	}
+
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, sigV4Algorithm+" ") {
+		return errors.New("missing authorization")
+	}
+	fields := map[string]string{}
+	for _, part := range strings.Split(strings.TrimPrefix(auth, sigV4Algorithm+" "), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		fields[name] = value
+	}
+	amzDate := r.Header.Get("X-Amz-Date")
+	signedHeaders := strings.Split(fields["SignedHeaders"], ";")
+	for _, required := range []string{"host", "x-amz-content-sha256", "x-amz-date"} {
+		if !contains(signedHeaders, required) {
+			return fmt.Errorf("header %s not signed", required)
+		}
+	}
+	want := testSign(r.Method, rawPath, query, r, signedHeaders, r.Header.Get("X-Amz-Content-Sha256"), amzDate)
+	if fields["Credential"] != testAccessKey+"/"+testScope(amzDate) || fields["Signature"] != want {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+// verifyPresigned 校验预签名地址及其有效期
+func (f *fakeS3) verifyPresigned(r *http.Request, rawPath string, query url.Values) error {
+	signature := query.Get("X-Amz-Signature")
+	query.Del("X-Amz-Signature")
+	amzDate := query.Get("X-Amz-Date")
+	if query.Get("X-Amz-Credential") != testAccessKey+"/"+testScope(amzDate) {
+		return errors.New("credential mismatch")
+	}
+	signedAt, err := time.Parse(sigV4TimeFormat, amzDate)
+	if err != nil {
+		return err
+	}
+	expires, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+	if err != nil || time.Now().After(signedAt.Add(time.Duration(expires)*time.Second)) {
+		return errors.New("request has expired")
+	}
+	want := testSign(r.Method, rawPath, query, r, strings.Split(query.Get("X-Amz-SignedHeaders"), ";"), unsignedPayload, amzDate)
+	if signature != want {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+// testSign 独立实现的SigV4签名计算（不使用被测代码的签名函数）
+func testSign(method, rawPath string, query url.Values, r *http.Request, signedHeaders []string, payloadHash, amzDate string) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var params []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			params = append(params, awsEscape(k)+"="+awsEscape(v))
+		}
+	}
+
+	var headers strings.Builder
+	for _, name := range signedHeaders {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		headers.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+
+	canonical := strings.Join([]string{method, rawPath, strings.Join(params, "&"), headers.String(), strings.Join(signedHeaders, ";"), payloadHash}, "\n")
+	sum := sha256.Sum256([]byte(canonical))
+	stringToSign := strings.Join([]string{sigV4Algorithm, amzDate, testScope(amzDate), hex.EncodeToString(sum[:])}, "\n")
+
+	key := []byte("AWS4" + testSecretKey)
+	for _, part := range []string{amzDate[:8], testRegion, "s3", "aws4_request", stringToSign} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	return hex.EncodeToString(key)
+}
+
+func testScope(amzDate string) string {
+	if len(amzDate) < 8 {
+		return ""
+	}
+	return amzDate[:8] + "/" + testRegion + "/s3/aws4_request"
+}
+
+// awsEscape 按SigV4规则编码查询参数（空格为%20，~不编码）
+func awsEscape(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(url.QueryEscape(s), "+", "%20"), "%7E", "~")
+}
+
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func testS3Config(srv *httptest.Server, secretKey string) *config.StorageConfig {
+	return &config.StorageConfig{
+		S3Endpoint:  srv.URL,
+		S3Region:    testRegion,
+		S3Bucket:    testBucket,
+		S3AccessKey: testAccessKey,
+		S3SecretKey: secretKey,
+	}
+}
+
+func newTestS3Store(t *testing.T, srv *httptest.Server, secretKey string) (*S3BlobStore, error) {
+	t.Helper()
+	return NewS3BlobStore(context.Background(), testS3Config(srv, secretKey))
+}
+
+func TestS3BlobStoreRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	fake, srv := newFakeS3(t)
+	store, err := newTestS3Store(t, srv, testSecretKey)
+	if err != nil {
+		t.Fatalf("NewS3BlobStore: %v", err)
+	}
+	if !fake.bucketExists {
+		t.Fatal("应创建bucket")
+	}
+
+	const key = "team_a/photo 1.png"
+	data := []byte("\x89PNG fake image data")
+	if err := store.Put(ctx, key, bytes.NewReader(data), int64(len(data)), "image/png"); err != nil {
+		t.Fatalf("上传对象应成功: %v", err)
+	}
+
+	rc, info, err := store.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("读取对象应成功: %v", err)
+	}
+	got, _ := io.ReadAll(rc)
+	rc.Close()
+	if !bytes.Equal(got, data) {
+		t.Errorf("读取的内容应为 %q，实际 %q", data, got)
+	}
+	if info.Size != int64(len(data)) || info.ContentType != "image/png" || info.LastModified.IsZero() {
+		t.Errorf("读取的对象信息不正确: %+v", info)
+	}
+
+	info, err = store.Stat(ctx, key)
+	if err != nil {
+		t.Fatalf("获取对象信息应成功: %v", err)
+	}
+	if info.Key != key || info.Size != int64(len(data)) || info.ContentType != "image/png" {
+		t.Errorf("对象信息不正确: %+v", info)
+	}
+
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatalf("删除对象应成功: %v", err)
+	}
+	if _, err := store.Stat(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("删除后获取对象信息应返回ErrNotFound，实际 %v", err)
+	}
+	if _, _, err := store.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("删除后读取应返回ErrNotFound，实际 %v", err)
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		t.Errorf("删除不存在的对象不应返回错误: %v", err)
+	}
+}
+
+func TestS3BlobStorePutStreamsBody(t *testing.T) {
+	ctx := context.Background()
+	fake, srv := newFakeS3(t)
+	store, err := newTestS3Store(t, srv, testSecretKey)
+	if err != nil {
+		t.Fatalf("NewS3BlobStore: %v", err)
+	}
+
+	// 前半部分写入后，等服务端收到请求再写后半部分：Put先读完整个body时服务端收不到请求
+	data := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+	pr, pw := io.Pipe()
+	go func() {
+		half := len(data) / 2
+		pw.Write(data[:half])
+		select {
+		case <-fake.putStarted:
+		case <-time.After(5 * time.Second):
+			pw.CloseWithError(errors.New("body was buffered before the request was sent"))
+			return
+		}
+		pw.Write(data[half:])
+		pw.Close()
+	}()
+
+	if err := store.Put(ctx, "big.jpg", pr, int64(len(data)), "image/jpeg"); err != nil {
+		t.Fatalf("上传对象应成功: %v", err)
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if fake.lastPut.contentLength != int64(len(data)) || len(fake.lastPut.transferEncoding) != 0 {
+		t.Errorf("应带Content-Length %d且不使用分块传输，实际 %d、%v",
+			len(data), fake.lastPut.contentLength, fake.lastPut.transferEncoding)
+	}
+	if fake.lastPut.payloadHash != unsignedPayload {
+		t.Errorf("x-amz-content-sha256应为 %s，实际 %q", unsignedPayload, fake.lastPut.payloadHash)
+	}
+	if !bytes.Equal(fake.objects["big.jpg"].data, data) {
+		t.Error("保存的内容与上传的不一致")
+	}
+}
+
+func TestS3BlobStorePutUnknownSize(t *testing.T) {
+	ctx := context.Background()
+	fake, srv := newFakeS3(t)
+	store, err := newTestS3Store(t, srv, testSecretKey)
+	if err != nil {
+		t.Fatalf("NewS3BlobStore: %v", err)
+	}
+
+	data := []byte("unknown length")
+	if err := store.Put(ctx, "a.png", io.MultiReader(bytes.NewReader(data)), -1, "image/png"); err != nil {
+		t.Fatalf("上传对象应成功: %v", err)
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if fake.lastPut.contentLength != int64(len(data)) {
+		t.Errorf("Content-Length应为 %d，实际 %d", len(data), fake.lastPut.contentLength)
+	}
+	if obj := fake.objects["a.png"]; obj == nil || !bytes.Equal(obj.data, data) {
+		t.Error("保存的内容与上传的不一致")
+	}
+}
+
+func TestS3BlobStoreList(t *testing.T) {
+	ctx := context.Background()
+	fake, srv := newFakeS3(t)
+	store, err := newTestS3Store(t, srv, testSecretKey)
+	if err != nil {
+		t.Fatalf("NewS3BlobStore: %v", err)
+	}
+	fake.listPageSize = 2
+
+	keys := []string{"a.png", "team_a/1.png", "team_a/2.jpg", "team_a/3.png", "team_b/1.png"}
+	for _, key := range keys {
+		if err := store.Put(ctx, key, strings.NewReader(key), int64(len(key)), "image/png"); err != nil {
+			t.Fatalf("上传对象应成功: %v", err)
+		}
+	}
+
+	var listed []string
+	err = store.List(ctx, "team_a/", func(info *ObjectInfo) error {
+		if info.Size != int64(len(info.Key)) || info.LastModified.IsZero() {
+			t.Errorf("对象信息不正确: %+v", info)
+		}
+		listed = append(listed, info.Key)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("列出对象应成功: %v", err)
+	}
+	if want := keys[1:4]; strings.Join(listed, ",") != strings.Join(want, ",") {
+		t.Errorf("应列出 %v，实际 %v", want, listed)
+	}
+	if fake.listPages < 2 {
+		t.Errorf("超过每页数量时应分页请求，实际 %d 页", fake.listPages)
+	}
+
+	stop := errors.New("stop")
+	if err := store.List(ctx, "", func(*ObjectInfo) error { return stop }); !errors.Is(err, stop) {
+		t.Errorf("fn返回错误时应停止遍历并返回该错误，实际 %v", err)
+	}
+}
+
+func TestS3BlobStoreCanceledContext(t *testing.T) {
+	fake, srv := newFakeS3(t)
+	store, err := newTestS3Store(t, srv, testSecretKey)
+	if err != nil {
+		t.Fatalf("NewS3BlobStore: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	data := []byte("data")
+	if err := store.Put(ctx, "a.png", bytes.NewReader(data), int64(len(data)), "image/png"); err == nil {
+		t.Error("ctx已取消时上传应返回错误")
+	}
+	if _, err := store.Stat(ctx, "a.png"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("ctx已取消时获取对象信息应返回请求错误，实际 %v", err)
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if len(fake.objects) != 0 {
+		t.Error("ctx已取消时不应保存对象")
+	}
+}
+
+func TestS3BlobStoreSignedURL(t *testing.T) {
+	ctx := context.Background()
+	_, srv := newFakeS3(t)
+	store, err := newTestS3Store(t, srv, testSecretKey)
+	if err != nil {
+		t.Fatalf("NewS3BlobStore: %v", err)
+	}
+	data := []byte("signed content")
+	if err := store.Put(ctx, "team_a/x.png", bytes.NewReader(data), int64(len(data)), "image/png"); err != nil {
+		t.Fatalf("上传对象应成功: %v", err)
+	}
+
+	signed, err := store.SignedURL(ctx, "team_a/x.png", time.Minute)
+	if err != nil {
+		t.Fatalf("生成签名地址应成功: %v", err)
+	}
+	resp, err := http.Get(signed)
+	if err != nil {
+		t.Fatalf("访问签名地址失败: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(body, data) {
+		t.Fatalf("签名地址应返回200 %q，实际 %d %q", data, resp.StatusCode, body)
+	}
+
+	// 篡改对象key或有效期后签名失效
+	for name, tamper := range map[string]func(u *url.URL){
+		"对象key": func(u *url.URL) { u.Path = strings.Replace(u.Path, "x.png", "y.png", 1); u.RawPath = "" },
+		"有效期": func(u *url.URL) {
+			q := u.Query()
+			q.Set("X-Amz-Expires", "604800")
+			u.RawQuery = q.Encode()
+		},
+	} {
+		u, _ := url.Parse(signed)
+		tamper(u)
+		resp, err := http.Get(u.String())
+		if err != nil {
+			t.Fatalf("访问篡改的签名地址失败: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("篡改%s后签名地址应返回403，实际 %d", name, resp.StatusCode)
+		}
+	}
+
+	for _, expiry := range []time.Duration{0, 8 * 24 * time.Hour} {
+		if _, err := store.SignedURL(ctx, "team_a/x.png", expiry); err == nil {
+			t.Errorf("有效期 %v 应返回错误", expiry)
+		}
+	}
+}
+
+func TestS3BlobStoreRejectsWrongSecret(t *testing.T) {
+	ctx := context.Background()
+	fake, srv := newFakeS3(t)
+	fake.bucketExists = true
+	client, err := newS3Client(testS3Config(srv, "wrong-secret"))
+	if err != nil {
+		t.Fatalf("newS3Client: %v", err)
+	}
+	store := &S3BlobStore{client: client, bucket: testBucket}
+
+	data := []byte("data")
+	err = store.Put(ctx, "a.png", bytes.NewReader(data), int64(len(data)), "image/png")
+	if err == nil || !strings.Contains(err.Error(), "signature mismatch") {
+		t.Errorf("密钥错误时上传应返回签名错误，实际 %v", err)
+	}
+	if len(fake.objects) != 0 {
+		t.Error("签名无效时不应保存对象")
+	}
+	if _, err := newTestS3Store(t, srv, "wrong-secret"); err == nil {
+		t.Error("密钥错误时创建存储应失败")
+	}
+}
@@ -1,17 +1,21 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
 	"image-search-go/config"
 	"image-search-go/models"
 	"image-search-go/services"
+	"image-search-go/storage"
 	"image-search-go/utils"
 
 	"github.com/google/uuid"
@@ -20,27 +24,43 @@ import (
 type BatchInserter struct {
 	milvusService    *services.MilvusService
 	featureExtractor models.FeatureExtractor
+	blobStore        storage.BlobStore
+	metadataStore    *services.MetadataStore
 	config           *config.Config
 }
 
-func NewBatchInserter(cfg *config.Config) (*BatchInserter, error) {
+func NewBatchInserter(ctx context.Context, cfg *config.Config) (*BatchInserter, error) {
 	// 初始化特征提取器
 	featureExtractor := models.NewSimpleFeatureExtractor()
 
 	// 初始化Milvus服务
-	milvusService, err := services.NewMilvusService(&cfg.Milvus)
+	milvusService, err := services.NewMilvusService(ctx, &cfg.Milvus)
 	if err != nil {
 		return nil, fmt.Errorf("初始化Milvus服务失败: %v", err)
 	}
 
+	// 初始化图像存储
+	blobStore, err := storage.NewBlobStore(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("初始化图像存储失败: %v", err)
+	}
+
+	// 初始化元数据存储
+	metadataStore, err := services.NewMetadataStore(cfg.Server.MetadataPath)
+	if err != nil {
+		return nil, fmt.Errorf("初始化元数据存储失败: %v", err)
+	}
+
 	return &BatchInserter{
 		milvusService:    milvusService,
 		featureExtractor: featureExtractor,
+		blobStore:        blobStore,
+		metadataStore:    metadataStore,
 		config:           cfg,
 	}, nil
 }
 
-func (bi *BatchInserter) ProcessDataset(datasetPath string, batchSize int, maxWorkers int) error {
+func (bi *BatchInserter) ProcessDataset(ctx context.Context, datasetPath string, batchSize int, maxWorkers int) error {
 	log.Printf("开始处理数据集: %s", datasetPath)
 
 	// 获取所有图像文件
@@ -59,7 +79,7 @@ func (bi *BatchInserter) ProcessDataset(datasetPath string, batchSize int, maxWo
 	// 启动工作协程
 	for i := 0; i < maxWorkers; i++ {
 		wg.Add(1)
-		go bi.worker(jobs, results, &wg)
+		go bi.worker(ctx, jobs, results, &wg)
 	}
 
 	// 启动结果收集协程
@@ -96,21 +116,21 @@ type BatchResult struct {
 	Error          error
 }
 
-func (bi *BatchInserter) worker(jobs <-chan []string, results chan<- BatchResult, wg *sync.WaitGroup) {
+func (bi *BatchInserter) worker(ctx context.Context, jobs <-chan []string, results chan<- BatchResult, wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	for batch := range jobs {
-		result := bi.processBatch(batch)
+		result := bi.processBatch(ctx, batch)
 		results <- result
 	}
 }
 
-func (bi *BatchInserter) processBatch(imagePaths []string) BatchResult {
+func (bi *BatchInserter) processBatch(ctx context.Context, imagePaths []string) BatchResult {
 	batchID := uuid.New().String()[:8]
 	log.Printf("[批次 %s] 开始处理 %d 个图像", batchID, len(imagePaths))
 
-	var imageIDs []string
-	var vectors [][]float32
+	var records []*services.ImageRecord
+	var metas []*services.ImageMetadata
 	var successCount, errorCount int
 
 	for _, imagePath := range imagePaths {
@@ -123,7 +143,7 @@ func (bi *BatchInserter) processBatch(imagePaths []string) BatchResult {
 		}
 
 		// 提取特征
-		features, err := bi.featureExtractor.ExtractFeatures(img)
+		features, err := bi.featureExtractor.ExtractFeatures(ctx, img)
 		if err != nil {
 			log.Printf("[批次 %s] 提取特征失败 %s: %v", batchID, imagePath, err)
 			errorCount++
@@ -132,23 +152,47 @@ func (bi *BatchInserter) processBatch(imagePaths []string) BatchResult {
 
 		// 生成图像ID并复制文件
 		imageID := uuid.New().String()
-		destPath := filepath.Join(bi.config.Server.UploadPath, imageID+filepath.Ext(imagePath))
+		destKey := imageID + filepath.Ext(imagePath)
 
-		if err := bi.copyImageFile(imagePath, destPath); err != nil {
+		fileSize, err := bi.copyImageFile(ctx, imagePath, destKey)
+		if err != nil {
 			log.Printf("[批次 %s] 复制文件失败 %s: %v", batchID, imagePath, err)
 			errorCount++
 			continue
 		}
 
-		imageIDs = append(imageIDs, imageID)
-		vectors = append(vectors, features)
+		records = append(records, &services.ImageRecord{
+			ImageID:   imageID,
+			Vector:    features,
+			ObjectKey: destKey,
+			MimeType:  utils.ContentTypeByFilename(destKey),
+			FileSize:  fileSize,
+		})
+		metas = append(metas, &services.ImageMetadata{
+			ImageID:          imageID,
+			ObjectKey:        destKey,
+			MimeType:         utils.ContentTypeByFilename(destKey),
+			FileSize:         fileSize,
+			Width:            img.Bounds().Dx(),
+			Height:           img.Bounds().Dy(),
+			Format:           strings.TrimPrefix(filepath.Ext(destKey), "."),
+			OriginalFilename: filepath.Base(imagePath),
+		})
 		successCount++
 	}
 
+	// 写入元数据
+	for _, meta := range metas {
+		if err := bi.metadataStore.Put(meta); err != nil {
+			log.Printf("[批次 %s] 写入元数据失败 %s: %v", batchID, meta.ImageID, err)
+		}
+	}
+
 	// 批量插入到Milvus
-	if len(imageIDs) > 0 {
-		if err := bi.milvusService.InsertVectors(imageIDs, vectors); err != nil {
+	if len(records) > 0 {
+		if err := bi.milvusService.InsertImages(ctx, records); err != nil {
 			log.Printf("[批次 %s] 插入Milvus失败: %v", batchID, err)
+			bi.rollbackBatch(context.WithoutCancel(ctx), records)
 			return BatchResult{
 				Success:        false,
 				ProcessedCount: 0,
@@ -169,19 +213,33 @@ func (bi *BatchInserter) processBatch(imagePaths []string) BatchResult {
 	}
 }
 
-func (bi *BatchInserter) copyImageFile(srcPath, destPath string) error {
-	// 确保目标目录存在
-	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
-		return err
+// rollbackBatch 插入失败时清理本批次已写入的文件和元数据
+func (bi *BatchInserter) rollbackBatch(ctx context.Context, records []*services.ImageRecord) {
+	for _, record := range records {
+		if err := bi.metadataStore.Delete(record.ImageID); err != nil {
+			log.Printf("回滚元数据失败 %s: %v", record.ImageID, err)
+		}
+		if err := bi.blobStore.Delete(ctx, record.ObjectKey); err != nil {
+			log.Printf("回滚文件失败 %s: %v", record.ObjectKey, err)
+		}
 	}
+}
 
+func (bi *BatchInserter) copyImageFile(ctx context.Context, srcPath, destKey string) (int64, error) {
 	// 加载并保存图像（这样可以统一格式）
 	img, err := utils.LoadImageFromFile(srcPath)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	return utils.SaveImage(img, destPath)
+	format := strings.TrimPrefix(filepath.Ext(destKey), ".")
+	data, err := utils.ImageToBytes(img, format)
+	if err != nil {
+		return 0, err
+	}
+
+	size := int64(len(data))
+	return size, bi.blobStore.Put(ctx, destKey, bytes.NewReader(data), size, utils.ContentTypeByFilename(destKey))
 }
 
 func (bi *BatchInserter) findImageFiles(rootPath string) ([]string, error) {
@@ -247,18 +305,23 @@ func main() {
 	cfg := config.LoadConfig()
 	log.Printf("配置加载完成")
 
+	ctx := context.Background()
+
 	// 创建批量插入器
-	inserter, err := NewBatchInserter(cfg)
+	inserter, err := NewBatchInserter(ctx, cfg)
 	if err != nil {
 		log.Fatalf("创建批量插入器失败: %v", err)
 	}
 
 	// 开始处理
 	startTime := time.Now()
-	if err := inserter.ProcessDataset(*datasetPath, *batchSize, *workers); err != nil {
+	if err := inserter.ProcessDataset(ctx, *datasetPath, *batchSize, *workers); err != nil {
 		log.Fatalf("处理数据集失败: %v", err)
 	}
 
+	// 写入缓冲中剩余的向量
+	inserter.milvusService.Close()
+
 	duration := time.Since(startTime)
 	log.Printf("数据集插入完成，耗时: %v", duration)
 }
@@ -0,0 +1,6 @@
+package utils
+
+import "errors"
+
+// ErrDiskFreeUnsupported 当前平台不支持获取磁盘剩余空间
+var ErrDiskFreeUnsupported = errors.New("当前平台不支持获取磁盘剩余空间")
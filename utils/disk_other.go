@@ -0,0 +1,8 @@
+//go:build !unix
+
+package utils
+
+// DiskFree 当前平台不支持，返回ErrDiskFreeUnsupported
+func DiskFree(path string) (int64, error) {
+	return 0, ErrDiskFreeUnsupported
+}
@@ -0,0 +1,561 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+// JPEG段标记
+const (
+	jpegMarkerSOI  = 0xffd8
+	jpegMarkerAPP1 = 0xffe1
+	jpegMarkerSOS  = 0xffda
+)
+
+// EXIF标签
+const (
+	exifTagMake             = 0x010f
+	exifTagModel            = 0x0110
+	exifTagOrientation      = 0x0112
+	exifTagDateTime         = 0x0132
+	exifTagExifIFD          = 0x8769
+	exifTagGPSIFD           = 0x8825
+	exifTagDateTimeOriginal = 0x9003
+	exifTagOffsetTime       = 0x9010
+	exifTagOffsetTimeOrig   = 0x9011
+	gpsTagLatitudeRef       = 0x0001
+	gpsTagLatitude          = 0x0002
+	gpsTagLongitudeRef      = 0x0003
+	gpsTagLongitude         = 0x0004
+)
+
+// exifHeader APP1段中EXIF数据的前缀
+var exifHeader = []byte("Exif\x00\x00")
+
+// xmpHeaders APP1段中XMP数据（含扩展XMP）的前缀，XMP中常包含GPS、相机和作者信息
+var xmpHeaders = [][]byte{
+	[]byte("http://ns.adobe.com/xap/1.0/\x00"),
+	[]byte("http://ns.adobe.com/xmp/extension/\x00"),
+}
+
+// PNG文件签名、保存EXIF的数据块类型和保存XMP的iTXt关键字
+var (
+	pngSignature  = []byte("\x89PNG\r\n\x1a\n")
+	pngChunkExif  = "eXIf"
+	pngChunkIText = "iTXt"
+	pngXMPKeyword = []byte("XML:com.adobe.xmp\x00")
+)
+
+// maxExifPayload EXIF数据的最大长度。JPEG的APP1段最长64KB，PNG的eXIf块长度由文件声明，
+// 超过该值视为无效，避免按伪造的长度分配内存
+const maxExifPayload = 1 << 20
+
+// isTIFF 判断数据是否以TIFF文件头开始（TIFF文件本身就是EXIF使用的结构）
+func isTIFF(head []byte) bool {
+	return bytes.HasPrefix(head, []byte("II*\x00")) || bytes.HasPrefix(head, []byte("MM\x00*"))
+}
+
+// ExifData 从图像中提取的关键EXIF字段
+type ExifData struct {
+	Make        string `json:"make,omitempty"`
+	Model       string `json:"model,omitempty"`
+	Orientation int    `json:"orientation,omitempty"`
+	// CaptureTime 拍摄时间，有时区偏移（OffsetTimeOriginal）时为RFC3339格式，
+	// 否则为不带时区的相机本地时间（如 2024-01-02T15:04:05）
+	CaptureTime string   `json:"capture_time,omitempty"`
+	Latitude    *float64 `json:"latitude,omitempty"`
+	Longitude   *float64 `json:"longitude,omitempty"`
+}
+
+// ExtractExif 从JPEG、PNG（eXIf数据块）或TIFF数据中提取EXIF信息，没有EXIF时返回nil
+func ExtractExif(r io.Reader) (*ExifData, error) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(len(pngSignature))
+
+	var payload []byte
+	var err error
+	switch {
+	case bytes.Equal(head, pngSignature):
+		payload, err = findPNGExifPayload(br)
+	case isTIFF(head):
+		payload, err = io.ReadAll(br)
+	default:
+		payload, err = findExifPayload(br)
+	}
+	if err != nil || payload == nil {
+		return nil, err
+	}
+	return parseExif(payload)
+}
+
+// ExtractExifFromFile 从图像文件中提取EXIF信息
+func ExtractExifFromFile(filePath string) (*ExifData, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("无法打开图像文件: %v", err)
+	}
+	defer file.Close()
+
+	return ExtractExif(file)
+}
+
+// findExifPayload 在JPEG段中查找EXIF数据块（不含Exif头）
+func findExifPayload(r io.Reader) ([]byte, error) {
+	var soi uint16
+	if err := binary.Read(r, binary.BigEndian, &soi); err != nil || soi != jpegMarkerSOI {
+		// 不是JPEG图像，没有EXIF
+		return nil, nil
+	}
+
+	for {
+		var marker, size uint16
+		if err := binary.Read(r, binary.BigEndian, &marker); err != nil {
+			return nil, nil
+		}
+		if marker>>8 != 0xff || marker == jpegMarkerSOS {
+			return nil, nil
+		}
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			return nil, nil
+		}
+		if size < 2 {
+			return nil, fmt.Errorf("无效的JPEG段长度: %d", size)
+		}
+
+		data := make([]byte, int(size)-2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, fmt.Errorf("读取JPEG段失败: %v", err)
+		}
+
+		if marker == jpegMarkerAPP1 && bytes.HasPrefix(data, exifHeader) {
+			return data[len(exifHeader):], nil
+		}
+	}
+}
+
+// findPNGExifPayload 在PNG数据块中查找eXIf块
+func findPNGExifPayload(r io.Reader) ([]byte, error) {
+	if _, err := io.CopyN(io.Discard, r, int64(len(pngSignature))); err != nil {
+		return nil, nil
+	}
+
+	for {
+		var length uint32
+		chunkType := make([]byte, 4)
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			return nil, nil
+		}
+		if _, err := io.ReadFull(r, chunkType); err != nil || string(chunkType) == "IEND" {
+			return nil, nil
+		}
+
+		if string(chunkType) == pngChunkExif {
+			if length > maxExifPayload {
+				return nil, fmt.Errorf("PNG eXIf数据块过长: %d 字节", length)
+			}
+			// 按实际读到的数据分配，声明的长度超过剩余数据时报错
+			data, err := io.ReadAll(io.LimitReader(r, int64(length)))
+			if err != nil {
+				return nil, fmt.Errorf("读取PNG数据块失败: %v", err)
+			}
+			if len(data) != int(length) {
+				return nil, fmt.Errorf("PNG数据块不完整: 声明 %d 字节，实际 %d 字节", length, len(data))
+			}
+			return data, nil
+		}
+		// 跳过数据和CRC
+		if _, err := io.CopyN(io.Discard, r, int64(length)+4); err != nil {
+			return nil, nil
+		}
+	}
+}
+
+// tiffReader TIFF结构读取器
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// ifdEntry IFD条目
+type ifdEntry struct {
+	tag    uint16
+	count  uint32
+	offset uint32
+	raw    []byte
+}
+
+// parseExif 解析TIFF格式的EXIF数据
+func parseExif(payload []byte) (*ExifData, error) {
+	if len(payload) < 8 {
+		return nil, fmt.Errorf("EXIF数据过短")
+	}
+
+	tr := &tiffReader{data: payload}
+	switch string(payload[:2]) {
+	case "II":
+		tr.order = binary.LittleEndian
+	case "MM":
+		tr.order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("无效的EXIF字节序")
+	}
+
+	ifd0, err := tr.readIFD(tr.order.Uint32(payload[4:8]))
+	if err != nil {
+		return nil, err
+	}
+
+	exif := &ExifData{}
+	var exifOffset, gpsOffset uint32
+	var dateTime, dateTimeOriginal, offsetTime, offsetTimeOriginal string
+	for _, e := range ifd0 {
+		switch e.tag {
+		case exifTagMake:
+			exif.Make = tr.asciiValue(e)
+		case exifTagModel:
+			exif.Model = tr.asciiValue(e)
+		case exifTagOrientation:
+			exif.Orientation = int(tr.shortValue(e))
+		case exifTagDateTime:
+			dateTime = tr.asciiValue(e)
+		case exifTagExifIFD:
+			exifOffset = tr.longValue(e)
+		case exifTagGPSIFD:
+			gpsOffset = tr.longValue(e)
+		}
+	}
+
+	if exifOffset > 0 {
+		if entries, err := tr.readIFD(exifOffset); err == nil {
+			for _, e := range entries {
+				switch e.tag {
+				case exifTagDateTimeOriginal:
+					dateTimeOriginal = tr.asciiValue(e)
+				case exifTagOffsetTimeOrig:
+					offsetTimeOriginal = tr.asciiValue(e)
+				case exifTagOffsetTime:
+					offsetTime = tr.asciiValue(e)
+				}
+			}
+		}
+	}
+
+	// 拍摄时间优先使用DateTimeOriginal，时区偏移使用对应的OffsetTime标签
+	exif.CaptureTime = formatExifTime(dateTimeOriginal, offsetTimeOriginal)
+	if exif.CaptureTime == "" {
+		exif.CaptureTime = formatExifTime(dateTime, offsetTime)
+	}
+
+	if gpsOffset > 0 {
+		if entries, err := tr.readIFD(gpsOffset); err == nil {
+			exif.Latitude, exif.Longitude = tr.gpsCoordinates(entries)
+		}
+	}
+
+	return exif, nil
+}
+
+// readIFD 读取指定偏移处的IFD条目
+func (tr *tiffReader) readIFD(offset uint32) ([]ifdEntry, error) {
+	if int(offset)+2 > len(tr.data) {
+		return nil, fmt.Errorf("IFD偏移越界: %d", offset)
+	}
+
+	count := int(tr.order.Uint16(tr.data[offset:]))
+	start := int(offset) + 2
+	if start+count*12 > len(tr.data) {
+		return nil, fmt.Errorf("IFD条目越界")
+	}
+
+	entries := make([]ifdEntry, 0, count)
+	for i := 0; i < count; i++ {
+		raw := tr.data[start+i*12 : start+(i+1)*12]
+		entries = append(entries, ifdEntry{
+			tag:    tr.order.Uint16(raw[0:2]),
+			count:  tr.order.Uint32(raw[4:8]),
+			offset: tr.order.Uint32(raw[8:12]),
+			raw:    raw[8:12],
+		})
+	}
+	return entries, nil
+}
+
+// valueBytes 返回条目的值字节（小于4字节时内联存储）
+func (tr *tiffReader) valueBytes(e ifdEntry, size int) []byte {
+	if size <= 4 {
+		return e.raw[:size]
+	}
+	end := int(e.offset) + size
+	if end > len(tr.data) || end < size {
+		return nil
+	}
+	return tr.data[e.offset:end]
+}
+
+func (tr *tiffReader) asciiValue(e ifdEntry) string {
+	b := tr.valueBytes(e, int(e.count))
+	return strings.TrimSpace(strings.TrimRight(string(b), "\x00"))
+}
+
+func (tr *tiffReader) shortValue(e ifdEntry) uint16 {
+	return tr.order.Uint16(e.raw[0:2])
+}
+
+func (tr *tiffReader) longValue(e ifdEntry) uint32 {
+	return tr.order.Uint32(e.raw)
+}
+
+// rationals 读取RATIONAL类型的数组
+func (tr *tiffReader) rationals(e ifdEntry) []float64 {
+	b := tr.valueBytes(e, int(e.count)*8)
+	if b == nil {
+		return nil
+	}
+
+	values := make([]float64, e.count)
+	for i := range values {
+		num := tr.order.Uint32(b[i*8:])
+		den := tr.order.Uint32(b[i*8+4:])
+		if den != 0 {
+			values[i] = float64(num) / float64(den)
+		}
+	}
+	return values
+}
+
+// gpsCoordinates 将GPS IFD转换为十进制经纬度
+func (tr *tiffReader) gpsCoordinates(entries []ifdEntry) (*float64, *float64) {
+	var latRef, lonRef string
+	var lat, lon []float64
+	for _, e := range entries {
+		switch e.tag {
+		case gpsTagLatitudeRef:
+			latRef = tr.asciiValue(e)
+		case gpsTagLatitude:
+			lat = tr.rationals(e)
+		case gpsTagLongitudeRef:
+			lonRef = tr.asciiValue(e)
+		case gpsTagLongitude:
+			lon = tr.rationals(e)
+		}
+	}
+
+	if len(lat) != 3 || len(lon) != 3 {
+		return nil, nil
+	}
+
+	latitude := lat[0] + lat[1]/60 + lat[2]/3600
+	if latRef == "S" {
+		latitude = -latitude
+	}
+	longitude := lon[0] + lon[1]/60 + lon[2]/3600
+	if lonRef == "W" {
+		longitude = -longitude
+	}
+	return &latitude, &longitude
+}
+
+// formatExifTime 解析EXIF时间 "2006:01:02 15:04:05"。EXIF时间是相机的本地时间，
+// 有时区偏移（如 "+08:00"）时返回RFC3339格式，否则返回不带时区的时间，无法解析时返回空
+func formatExifTime(value, offset string) string {
+	t, err := time.Parse("2006:01:02 15:04:05", value)
+	if err != nil {
+		return ""
+	}
+	if zone, err := time.Parse("-07:00", offset); err == nil {
+		_, seconds := zone.Zone()
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.FixedZone("", seconds)).Format(time.RFC3339)
+	}
+	return t.Format("2006-01-02T15:04:05")
+}
+
+// StripExif 移除图像中的EXIF和XMP数据：JPEG和PNG仅保留方向标记以保证图像显示正确，
+// TIFF的EXIF与图像结构在同一组标签中，按方向校正后重新编码。其他格式没有EXIF，直接返回
+func StripExif(data []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, pngSignature):
+		return stripPNGExif(data)
+	case isTIFF(data):
+		return stripTIFFExif(data)
+	case len(data) < 4 || binary.BigEndian.Uint16(data) != jpegMarkerSOI:
+		return data, nil
+	}
+
+	var out bytes.Buffer
+	out.Write(data[:2])
+
+	pos := 2
+	for pos+4 <= len(data) {
+		marker := binary.BigEndian.Uint16(data[pos:])
+		if marker>>8 != 0xff || marker == jpegMarkerSOS {
+			break
+		}
+		size := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + size
+		if size < 2 || end > len(data) {
+			return nil, fmt.Errorf("无效的JPEG段长度: %d", size)
+		}
+
+		segment := data[pos:end]
+		switch {
+		case marker == jpegMarkerAPP1 && bytes.HasPrefix(segment[4:], exifHeader):
+			if exif, err := parseExif(segment[4+len(exifHeader):]); err == nil && exif.Orientation > 1 {
+				out.Write(orientationOnlyApp1(exif.Orientation))
+			}
+		case marker == jpegMarkerAPP1 && isXMP(segment[4:]):
+			// XMP整段移除
+		default:
+			out.Write(segment)
+		}
+		pos = end
+	}
+
+	out.Write(data[pos:])
+	return out.Bytes(), nil
+}
+
+// stripPNGExif 移除PNG中的eXIf和XMP数据块，有方向标记时替换为只包含方向标记的eXIf块
+func stripPNGExif(data []byte) ([]byte, error) {
+	var out bytes.Buffer
+	out.Write(pngSignature)
+
+	pos := len(pngSignature)
+	for pos+12 <= len(data) {
+		length := binary.BigEndian.Uint32(data[pos:])
+		if int64(length) > int64(len(data)-pos-12) {
+			return nil, fmt.Errorf("无效的PNG数据块长度: %d", length)
+		}
+		end := pos + 12 + int(length)
+
+		chunk := data[pos:end]
+		switch string(chunk[4:8]) {
+		case pngChunkExif:
+			if exif, err := parseExif(chunk[8 : end-pos-4]); err == nil && exif.Orientation > 1 {
+				out.Write(pngChunk(pngChunkExif, orientationOnlyTIFF(exif.Orientation)))
+			}
+		case pngChunkIText:
+			// XMP保存在关键字为XML:com.adobe.xmp的iTXt块中，整块移除
+			if !bytes.HasPrefix(chunk[8:], pngXMPKeyword) {
+				out.Write(chunk)
+			}
+		default:
+			out.Write(chunk)
+		}
+		pos = end
+	}
+
+	out.Write(data[pos:])
+	return out.Bytes(), nil
+}
+
+// isXMP 判断APP1段数据是否为XMP
+func isXMP(payload []byte) bool {
+	for _, header := range xmpHeaders {
+		if bytes.HasPrefix(payload, header) {
+			return true
+		}
+	}
+	return false
+}
+
+// pngChunk 构造PNG数据块（长度、类型、数据、CRC）
+func pngChunk(chunkType string, payload []byte) []byte {
+	var chunk bytes.Buffer
+	binary.Write(&chunk, binary.BigEndian, uint32(len(payload)))
+	chunk.WriteString(chunkType)
+	chunk.Write(payload)
+	binary.Write(&chunk, binary.BigEndian, crc32.ChecksumIEEE(chunk.Bytes()[4:]))
+	return chunk.Bytes()
+}
+
+// stripTIFFExif 解码后重新编码TIFF，只保留像素数据。重新编码不写方向标记，先按方向校正像素
+func stripTIFFExif(data []byte) ([]byte, error) {
+	img, err := imaging.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("无法解码TIFF图像: %v", err)
+	}
+	if exif, err := parseExif(data); err == nil {
+		img = applyOrientation(img, exif.Orientation)
+	}
+
+	var out bytes.Buffer
+	if err := imaging.Encode(&out, img, imaging.TIFF); err != nil {
+		return nil, fmt.Errorf("重新编码TIFF图像失败: %v", err)
+	}
+	return out.Bytes(), nil
+}
+
+// applyOrientation 按EXIF方向标记变换图像，使其按正常方向显示
+func applyOrientation(img image.Image, orientation int) image.Image {
+	switch orientation {
+	case 2:
+		return imaging.FlipH(img)
+	case 3:
+		return imaging.Rotate180(img)
+	case 4:
+		return imaging.FlipV(img)
+	case 5:
+		return imaging.Transpose(img)
+	case 6:
+		return imaging.Rotate270(img)
+	case 7:
+		return imaging.Transverse(img)
+	case 8:
+		return imaging.Rotate90(img)
+	default:
+		return img
+	}
+}
+
+// StripExifFromFile 原地移除图像文件中的EXIF数据
+func StripExifFromFile(filePath string) error {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("读取文件失败: %v", err)
+	}
+
+	stripped, err := StripExif(data)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filePath, stripped, 0644)
+}
+
+// orientationOnlyApp1 构造只包含方向标记的APP1段
+func orientationOnlyApp1(orientation int) []byte {
+	tiff := orientationOnlyTIFF(orientation)
+
+	var seg bytes.Buffer
+	binary.Write(&seg, binary.BigEndian, uint16(jpegMarkerAPP1))
+	binary.Write(&seg, binary.BigEndian, uint16(2+len(exifHeader)+len(tiff)))
+	seg.Write(exifHeader)
+	seg.Write(tiff)
+	return seg.Bytes()
+}
+
+// orientationOnlyTIFF 构造只包含方向标记的EXIF数据（TIFF结构）
+func orientationOnlyTIFF(orientation int) []byte {
+	var tiff bytes.Buffer
+	tiff.WriteString("MM")
+	binary.Write(&tiff, binary.BigEndian, uint16(42))
+	binary.Write(&tiff, binary.BigEndian, uint32(8))
+	binary.Write(&tiff, binary.BigEndian, uint16(1))
+	binary.Write(&tiff, binary.BigEndian, uint16(exifTagOrientation))
+	binary.Write(&tiff, binary.BigEndian, uint16(3)) // SHORT
+	binary.Write(&tiff, binary.BigEndian, uint32(1))
+	binary.Write(&tiff, binary.BigEndian, uint16(orientation))
+	binary.Write(&tiff, binary.BigEndian, uint16(0))
+	binary.Write(&tiff, binary.BigEndian, uint32(0)) // 没有下一个IFD
+	return tiff.Bytes()
+}
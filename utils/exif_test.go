@@ -0,0 +1,226 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"sort"
+	"testing"
+)
+
+// tiffEntry 测试用的IFD条目，value为已按大端序编码的值
+type tiffEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+func shortEntry(tag uint16, v uint16) tiffEntry {
+	return tiffEntry{tag, 3, 1, binary.BigEndian.AppendUint16(nil, v)}
+}
+
+func longEntry(tag uint16, v uint32) tiffEntry {
+	return tiffEntry{tag, 4, 1, binary.BigEndian.AppendUint32(nil, v)}
+}
+
+func asciiEntry(tag uint16, s string) tiffEntry {
+	return tiffEntry{tag, 2, uint32(len(s) + 1), append([]byte(s), 0)}
+}
+
+// buildTIFF 构造大端序的TIFF结构：IFD0后依次是超过4字节的值和pixels，
+// 返回数据和pixels的偏移
+func buildTIFF(entries []tiffEntry, pixels []byte) ([]byte, uint32) {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].tag < entries[j].tag })
+
+	var head, extra bytes.Buffer
+	head.WriteString("MM")
+	binary.Write(&head, binary.BigEndian, uint16(42))
+	binary.Write(&head, binary.BigEndian, uint32(8))
+	binary.Write(&head, binary.BigEndian, uint16(len(entries)))
+
+	extraStart := uint32(8 + 2 + len(entries)*12 + 4)
+	for _, e := range entries {
+		binary.Write(&head, binary.BigEndian, e.tag)
+		binary.Write(&head, binary.BigEndian, e.typ)
+		binary.Write(&head, binary.BigEndian, e.count)
+		if len(e.value) <= 4 {
+			head.Write(append(e.value, make([]byte, 4-len(e.value))...))
+		} else {
+			binary.Write(&head, binary.BigEndian, extraStart+uint32(extra.Len()))
+			extra.Write(e.value)
+		}
+	}
+	binary.Write(&head, binary.BigEndian, uint32(0))
+
+	pixelOffset := extraStart + uint32(extra.Len())
+	head.Write(extra.Bytes())
+	head.Write(pixels)
+	return head.Bytes(), pixelOffset
+}
+
+// testTIFF 2x1的灰度TIFF，带相机信息和方向标记6（需顺时针旋转90度显示）
+func testTIFF() []byte {
+	entries := []tiffEntry{
+		shortEntry(256, 2),                                 // ImageWidth
+		shortEntry(257, 1),                                 // ImageLength
+		shortEntry(258, 8),                                 // BitsPerSample
+		shortEntry(259, 1),                                 // Compression: 无
+		shortEntry(262, 1),                                 // PhotometricInterpretation: BlackIsZero
+		asciiEntry(exifTagMake, "SecretCam"),               // Make
+		shortEntry(exifTagOrientation, 6),                  // Orientation
+		shortEntry(277, 1),                                 // SamplesPerPixel
+		shortEntry(278, 1),                                 // RowsPerStrip
+		longEntry(279, 2),                                  // StripByteCounts
+		asciiEntry(exifTagDateTime, "2024:01:02 15:04:05"), // DateTime
+	}
+	// 先用占位偏移计算像素位置，再写入StripOffsets（左黑右白）
+	pixels := []byte{0, 255}
+	_, offset := buildTIFF(append(entries, longEntry(273, 0)), pixels)
+	data, _ := buildTIFF(append(entries, longEntry(273, offset)), pixels)
+	return data
+}
+
+// testPNGWithExif 1x1的PNG，在IHDR之后插入带相机信息和方向标记的eXIf块
+func testPNGWithExif(t *testing.T) []byte {
+	var buf bytes.Buffer
+	img := image.NewGray(image.Rect(0, 0, 1, 1))
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("编码PNG失败: %v", err)
+	}
+	data := buf.Bytes()
+
+	exif, _ := buildTIFF([]tiffEntry{
+		asciiEntry(exifTagMake, "SecretCam"),
+		shortEntry(exifTagOrientation, 6),
+	}, nil)
+	ihdrEnd := len(pngSignature) + 12 + 13
+	out := append([]byte{}, data[:ihdrEnd]...)
+	out = append(out, pngChunk(pngChunkExif, exif)...)
+	return append(out, data[ihdrEnd:]...)
+}
+
+func TestStripExifPNG(t *testing.T) {
+	data := testPNGWithExif(t)
+	if exif, err := ExtractExif(bytes.NewReader(data)); err != nil || exif == nil || exif.Make != "SecretCam" {
+		t.Fatalf("应读取到PNG中的EXIF，实际 %+v, %v", exif, err)
+	}
+
+	stripped, err := StripExif(data)
+	if err != nil {
+		t.Fatalf("StripExif: %v", err)
+	}
+	if bytes.Contains(stripped, []byte("SecretCam")) {
+		t.Error("移除后仍包含相机信息")
+	}
+	exif, err := ExtractExif(bytes.NewReader(stripped))
+	if err != nil || exif == nil || exif.Orientation != 6 || exif.Make != "" {
+		t.Errorf("移除后应只保留方向标记6，实际 %+v, %v", exif, err)
+	}
+	if _, err := png.Decode(bytes.NewReader(stripped)); err != nil {
+		t.Errorf("移除后的PNG应能正常解码: %v", err)
+	}
+}
+
+func TestStripExifTIFF(t *testing.T) {
+	data := testTIFF()
+	if exif, err := ExtractExif(bytes.NewReader(data)); err != nil || exif == nil || exif.Make != "SecretCam" {
+		t.Fatalf("应读取到TIFF中的EXIF，实际 %+v, %v", exif, err)
+	}
+
+	stripped, err := StripExif(data)
+	if err != nil {
+		t.Fatalf("StripExif: %v", err)
+	}
+	if bytes.Contains(stripped, []byte("SecretCam")) || bytes.Contains(stripped, []byte("2024:01:02")) {
+		t.Error("移除后仍包含相机信息或拍摄时间")
+	}
+
+	// 重新编码时按方向6旋转：2x1变为1x2，左侧的黑色像素转到上方
+	img, format, err := image.Decode(bytes.NewReader(stripped))
+	if err != nil || format != "tiff" {
+		t.Fatalf("移除后的TIFF应能正常解码，实际 %s, %v", format, err)
+	}
+	if size := img.Bounds().Size(); size != image.Pt(1, 2) {
+		t.Fatalf("按方向校正后尺寸应为1x2，实际 %v", size)
+	}
+	if gray := color.GrayModel.Convert(img.At(0, 0)).(color.Gray); gray.Y != 0 {
+		t.Errorf("校正后上方应为黑色像素，实际 %d", gray.Y)
+	}
+}
+
+func TestFormatExifTime(t *testing.T) {
+	cases := []struct {
+		value, offset, want string
+	}{
+		{"2024:01:02 15:04:05", "+08:00", "2024-01-02T15:04:05+08:00"},
+		{"2024:01:02 15:04:05", "-05:30", "2024-01-02T15:04:05-05:30"},
+		{"2024:01:02 15:04:05", "", "2024-01-02T15:04:05"},
+		{"2024:01:02 15:04:05", "   :  ", "2024-01-02T15:04:05"},
+		{"0000:00:00 00:00:00", "", ""},
+	}
+	for _, tc := range cases {
+		if got := formatExifTime(tc.value, tc.offset); got != tc.want {
+			t.Errorf("formatExifTime(%q, %q) 应为 %q，实际 %q", tc.value, tc.offset, tc.want, got)
+		}
+	}
+}
+
+func TestExtractExifRejectsOversizedPNGChunk(t *testing.T) {
+	// eXIf块声明约4GB，实际只有几个字节
+	var data bytes.Buffer
+	data.Write(pngSignature)
+	binary.Write(&data, binary.BigEndian, uint32(0xfffffff0))
+	data.WriteString(pngChunkExif)
+	data.WriteString("MM\x00*")
+	if _, err := ExtractExif(bytes.NewReader(data.Bytes())); err == nil {
+		t.Error("声明长度超过上限的eXIf块应返回错误")
+	}
+
+	// 声明长度在上限内但超过剩余数据
+	data.Reset()
+	data.Write(pngSignature)
+	binary.Write(&data, binary.BigEndian, uint32(1000))
+	data.WriteString(pngChunkExif)
+	data.WriteString("MM\x00*")
+	if _, err := ExtractExif(bytes.NewReader(data.Bytes())); err == nil {
+		t.Error("声明长度超过剩余数据的eXIf块应返回错误")
+	}
+	if _, err := StripExif(data.Bytes()); err == nil {
+		t.Error("移除EXIF时声明长度超过剩余数据应返回错误")
+	}
+}
+
+func TestStripExifRemovesJPEGXMP(t *testing.T) {
+	app1 := func(payload string) []byte {
+		segment := binary.BigEndian.AppendUint16(nil, jpegMarkerAPP1)
+		segment = binary.BigEndian.AppendUint16(segment, uint16(2+len(payload)))
+		return append(segment, payload...)
+	}
+	var data bytes.Buffer
+	binary.Write(&data, binary.BigEndian, uint16(jpegMarkerSOI))
+	data.Write(app1("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta><exif:GPSLatitude>31,14.1N</exif:GPSLatitude></x:xmpmeta>"))
+	data.Write(app1("http://ns.adobe.com/xmp/extension/\x00GPSLongitude"))
+	data.Write([]byte{0xff, 0xda, 0x00, 0x02, 0x01, 0x02, 0xff, 0xd9})
+
+	stripped, err := StripExif(data.Bytes())
+	if err != nil {
+		t.Fatalf("StripExif: %v", err)
+	}
+	if bytes.Contains(stripped, []byte("GPS")) || bytes.Contains(stripped, []byte("adobe")) {
+		t.Errorf("移除后仍包含XMP数据: %q", stripped)
+	}
+	if want := []byte{0xff, 0xd8, 0xff, 0xda, 0x00, 0x02, 0x01, 0x02, 0xff, 0xd9}; !bytes.Equal(stripped, want) {
+		t.Errorf("移除后应只剩图像数据，实际 %x", stripped)
+	}
+}
+
+func TestContentTypeByFilenameTIFF(t *testing.T) {
+	for _, name := range []string{"a.tif", "a.TIFF"} {
+		if got := ContentTypeByFilename(name); got != "image/tiff" {
+			t.Errorf("%s 的MIME类型应为image/tiff，实际 %s", name, got)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"image"
 	"image/jpeg"
@@ -16,20 +17,24 @@ import (
 	"github.com/nfnt/resize"
 )
 
+// ErrDecodeImage 图像数据无法解码（格式损坏或与扩展名不符）
+var ErrDecodeImage = errors.New("无法解码图像")
+
 // SupportedImageTypes 支持的图像格式
-var SupportedImageTypes = []string{".jpg", ".jpeg", ".png", ".bmp", ".tiff"}
+var SupportedImageTypes = []string{".jpg", ".jpeg", ".png", ".bmp", ".tif", ".tiff"}
 
 // ImageInfo 图像信息结构
 type ImageInfo struct {
-	Filename string `json:"filename"`
-	Size     int64  `json:"size"`
-	Width    int    `json:"width"`
-	Height   int    `json:"height"`
-	Format   string `json:"format"`
-	Path     string `json:"path"`
+	Filename string    `json:"filename"`
+	Size     int64     `json:"size"`
+	Width    int       `json:"width"`
+	Height   int       `json:"height"`
+	Format   string    `json:"format"`
+	Path     string    `json:"path"`
+	Exif     *ExifData `json:"exif,omitempty"`
 }
 
-// LoadImageFromFile 从文件路径加载图像
+// LoadImageFromFile 从文件路径加载图像，并根据EXIF方向自动校正
 func LoadImageFromFile(imagePath string) (image.Image, error) {
 	file, err := os.Open(imagePath)
 	if err != nil {
@@ -37,15 +42,15 @@ func LoadImageFromFile(imagePath string) (image.Image, error) {
 	}
 	defer file.Close()
 
-	img, _, err := image.Decode(file)
+	img, err := imaging.Decode(file, imaging.AutoOrientation(true))
 	if err != nil {
-		return nil, fmt.Errorf("无法解码图像: %v", err)
+		return nil, fmt.Errorf("%w: %v", ErrDecodeImage, err)
 	}
 
 	return img, nil
 }
 
-// LoadImageFromMultipart 从multipart文件加载图像
+// LoadImageFromMultipart 从multipart文件加载图像，并根据EXIF方向自动校正
 func LoadImageFromMultipart(fileHeader *multipart.FileHeader) (image.Image, error) {
 	file, err := fileHeader.Open()
 	if err != nil {
@@ -53,14 +58,35 @@ func LoadImageFromMultipart(fileHeader *multipart.FileHeader) (image.Image, erro
 	}
 	defer file.Close()
 
-	img, _, err := image.Decode(file)
+	img, err := imaging.Decode(file, imaging.AutoOrientation(true))
 	if err != nil {
-		return nil, fmt.Errorf("无法解码图像: %v", err)
+		return nil, fmt.Errorf("%w: %v", ErrDecodeImage, err)
 	}
 
 	return img, nil
 }
 
+// LoadImageFromBytes 从内存数据加载图像，并根据EXIF方向自动校正
+func LoadImageFromBytes(data []byte) (image.Image, error) {
+	img, err := imaging.Decode(bytes.NewReader(data), imaging.AutoOrientation(true))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecodeImage, err)
+	}
+
+	return img, nil
+}
+
+// ReadMultipartFile 读取上传文件的全部内容
+func ReadMultipartFile(fileHeader *multipart.FileHeader) ([]byte, error) {
+	file, err := fileHeader.Open()
+	if err != nil {
+		return nil, fmt.Errorf("无法打开上传文件: %v", err)
+	}
+	defer file.Close()
+
+	return io.ReadAll(file)
+}
+
 // ResizeImage 调整图像大小，保持宽高比
 func ResizeImage(img image.Image, targetSize int) image.Image {
 	bounds := img.Bounds()
@@ -181,19 +207,64 @@ func GetImageInfo(filePath string) (*ImageInfo, error) {
 		return nil, err
 	}
 
-	img, format, err := image.DecodeConfig(file)
+	info, err := readImageInfo(file, filepath.Base(filePath), fileInfo.Size())
 	if err != nil {
 		return nil, err
 	}
+	info.Path = filePath
+	return info, nil
+}
 
-	return &ImageInfo{
-		Filename: filepath.Base(filePath),
-		Size:     fileInfo.Size(),
+// GetImageInfoFromBytes 从内存中的图像数据获取图像信息
+func GetImageInfoFromBytes(data []byte, filename string) (*ImageInfo, error) {
+	return readImageInfo(bytes.NewReader(data), filename, int64(len(data)))
+}
+
+// readImageInfo 读取图像尺寸、格式和EXIF信息
+func readImageInfo(r io.ReadSeeker, filename string, size int64) (*ImageInfo, error) {
+	img, format, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &ImageInfo{
+		Filename: filename,
+		Size:     size,
 		Width:    img.Width,
 		Height:   img.Height,
 		Format:   format,
-		Path:     filePath,
-	}, nil
+	}
+
+	// 读取EXIF信息，EXIF损坏不影响基本信息
+	if _, err := r.Seek(0, io.SeekStart); err == nil {
+		if exif, err := ExtractExif(r); err == nil && exif != nil {
+			info.Exif = exif
+			// 方向5-8表示图像需要旋转90度，宽高互换
+			if exif.Orientation >= 5 && exif.Orientation <= 8 {
+				info.Width, info.Height = info.Height, info.Width
+			}
+		}
+	}
+
+	return info, nil
+}
+
+// ContentTypeByFilename 根据文件扩展名返回MIME类型
+func ContentTypeByFilename(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".jpg", ".jpeg":
+		return "image/jpeg"
+	case ".png":
+		return "image/png"
+	case ".bmp":
+		return "image/bmp"
+	case ".tif", ".tiff":
+		return "image/tiff"
+	case ".gif":
+		return "image/gif"
+	default:
+		return "application/octet-stream"
+	}
 }
 
 // ImageToBytes 将图像转换为字节数组
@@ -0,0 +1,12 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestLoadImageFromBytesDecodeError(t *testing.T) {
+	if _, err := LoadImageFromBytes([]byte("not an image")); !errors.Is(err, ErrDecodeImage) {
+		t.Errorf("无法解码的数据应返回ErrDecodeImage，实际 %v", err)
+	}
+}